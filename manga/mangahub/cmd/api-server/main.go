@@ -1,17 +1,29 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"mangahub/internal/auth"
+	"mangahub/internal/backplane"
+	"mangahub/internal/feed"
+	"mangahub/internal/mail"
 	"mangahub/internal/manga"
+	"mangahub/internal/notification"
 	"mangahub/internal/udp"
 	"mangahub/internal/user"
 	socket "mangahub/internal/websocket"
 	"mangahub/pkg/database"
+	"mangahub/pkg/models"
 	"mangahub/proto"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -39,38 +51,86 @@ func SendProgressToTCPServer(message string) {
 	conn.Write([]byte(message))
 }
 
-// Helper for Admin UDP Broadcast
-func broadcastNewManga(message string) {
-	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:12345")
+func main() {
+	// 1. Initialize Database
+	db, err := database.InitDB()
 	if err != nil {
-		log.Printf("UDP Resolve Error: %v", err)
-		return
+		log.Fatal("DB Error:", err)
 	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		log.Printf("UDP Dial Error: %v", err)
-		return
+
+	// Revoked access tokens (logout, refresh token reuse) survive restarts
+	if err := auth.Revocations.Load(db); err != nil {
+		log.Fatal("Revocation List Error:", err)
 	}
-	defer conn.Close()
+	go auth.Revocations.Prune(10 * time.Minute)
 
-	payload := []byte("📢 ADMIN NOTIFICATION: " + message)
-	_, err = conn.Write(payload)
-	if err != nil {
-		log.Printf("UDP Broadcast Error: %v", err)
+	// JWT signing keys (RS256 by default, MANGAHUB_JWT_ALG=EdDSA for Ed25519),
+	// rotated daily unless MANGAHUB_JWT_ROTATE says otherwise
+	jwtAlg := os.Getenv("MANGAHUB_JWT_ALG")
+	if jwtAlg == "" {
+		jwtAlg = auth.AlgRS256
+	}
+	jwtRotate, err := time.ParseDuration(os.Getenv("MANGAHUB_JWT_ROTATE"))
+	if err != nil || jwtRotate <= 0 {
+		jwtRotate = 24 * time.Hour
+	}
+	// Private keys are only stored, sealed, with a key encryption key
+	// (32 bytes, base64: `openssl rand -base64 32`). Without one they stay
+	// in memory and every restart signs everyone out.
+	var kek []byte
+	if encoded := os.Getenv("MANGAHUB_KEY_ENCRYPTION_KEY"); encoded != "" {
+		if kek, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			log.Fatal("MANGAHUB_KEY_ENCRYPTION_KEY must be base64: ", err)
+		}
+	} else if os.Getenv("MANGAHUB_BACKPLANE") == "grpc" {
+		// Gateways verify each other's tokens through the keys they store
+		log.Fatal("MANGAHUB_KEY_ENCRYPTION_KEY is required with MANGAHUB_BACKPLANE=grpc: every gateway needs the same one")
 	} else {
-		log.Printf("🚀 UDP Broadcast sent: %s", message)
+		log.Println("⚠️ MANGAHUB_KEY_ENCRYPTION_KEY is not set: signing keys are kept in memory only")
 	}
-}
-
-func main() {
-	// 1. Initialize Database
-	db, err := database.InitDB()
+	auth.Keys, err = auth.NewKeyManager(db, jwtAlg, jwtRotate, kek)
 	if err != nil {
-		log.Fatal("DB Error:", err)
+		log.Fatal("Signing Key Error:", err)
+	}
+	auth.Verifier = auth.Keys
+	auth.APIKeys = &auth.APIKeyStore{DB: db}
+
+	// Roles that must use two-factor login (admin unless configured, "none" for nobody)
+	if roles := os.Getenv("MANGAHUB_2FA_REQUIRED_ROLES"); roles != "" {
+		auth.MFARequiredRoles = map[string]bool{}
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); auth.ValidRole(role) {
+				auth.MFARequiredRoles[role] = true
+			}
+		}
 	}
+	go auth.Keys.RunRotation()
 
 	// 2. Initialize gRPC Client
-	gConn, err := grpc.Dial("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// The service token authenticates the gateway itself, e.g. for anonymous
+	// manga lookups. Mutual TLS is optional for a gRPC server on this host and
+	// required for any other, so the token never crosses the network in clear.
+	serviceToken := os.Getenv("MANGAHUB_SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Fatal("MANGAHUB_SERVICE_TOKEN is not set: the gateway cannot call the gRPC server")
+	}
+	grpcAddr := envOr("MANGAHUB_GRPC_ADDR", "localhost:50051")
+	grpcCreds := insecure.NewCredentials()
+	if cert := os.Getenv("MANGAHUB_GRPC_CERT"); cert != "" {
+		host, _, _ := net.SplitHostPort(grpcAddr)
+		grpcCreds, err = auth.ClientTLS(cert, os.Getenv("MANGAHUB_GRPC_KEY"), os.Getenv("MANGAHUB_GRPC_CA"), host)
+		if err != nil {
+			log.Fatal("gRPC TLS Error:", err)
+		}
+	} else if !auth.LocalAddr(grpcAddr) {
+		log.Fatalf("gRPC server %s is not on this host: set MANGAHUB_GRPC_CERT for TLS", grpcAddr)
+	}
+	gConn, err := grpc.Dial(grpcAddr,
+		grpc.WithTransportCredentials(grpcCreds),
+		grpc.WithPerRPCCredentials(auth.ServiceCredentials{
+			Token:         serviceToken,
+			AllowInsecure: grpcCreds.Info().SecurityProtocol == "insecure",
+		}))
 	if err != nil {
 		log.Fatal("gRPC Connection Error:", err)
 	}
@@ -78,31 +138,100 @@ func main() {
 	mangaClient := proto.NewMangaServiceClient(gConn)
 
 	// 3. Start Background Servers (Hub, TCP, UDP)
-	hub := socket.NewChatHub()
+	// Several gateways share chat rooms and notification sockets when
+	// MANGAHUB_BACKPLANE=grpc (the gRPC server relays); otherwise this one is alone
+	var bus backplane.Backplane = backplane.NewLocal()
+	if os.Getenv("MANGAHUB_BACKPLANE") == "grpc" {
+		bus = backplane.DialGRPC(gConn)
+		log.Println("🔗 Chat backplane: gRPC broker")
+	}
+	defer bus.Close()
+
+	hub := socket.NewChatHub(db)
+	hub.Backplane = bus
+	// Chat filters run in the listed order, e.g. MANGAHUB_CHAT_FILTERS=profanity,links
+	hub.Filters, err = socket.BuildFilters(
+		strings.Split(envOr("MANGAHUB_CHAT_FILTERS", "profanity"), ","),
+		splitList(os.Getenv("MANGAHUB_CHAT_BANNED_WORDS")),
+		splitList(os.Getenv("MANGAHUB_CHAT_LINK_HOSTS")))
+	if err != nil {
+		log.Fatal("Chat filter error:", err)
+	}
 	go hub.Run()
 
-	udpServer := udp.NotificationServer{
-		Port: "12345",
-		Hub:  hub, // Connect the hub to the UDP server here!
+	notifications := notification.NewService(db)
+	notifications.Hub.Backplane = bus
+	go notifications.Hub.Run()
+	go notifications.RunDigest(time.Hour)
+
+	udpServer := &udp.NotificationServer{
+		Port:          envOr("MANGAHUB_UDP_PORT", "12345"),
+		Notifications: notifications, // External UDP announcements become system notifications
+		SigningKey:    []byte(os.Getenv("MANGAHUB_UDP_KEY")),
+
+		// LAN multicast is off unless a group is configured
+		MulticastGroup:     os.Getenv("MANGAHUB_MULTICAST_GROUP"),
+		MulticastKey:       []byte(os.Getenv("MANGAHUB_MULTICAST_KEY")),
+		MulticastInterface: os.Getenv("MANGAHUB_MULTICAST_IFACE"),
+	}
+	if ttl, err := strconv.Atoi(os.Getenv("MANGAHUB_MULTICAST_TTL")); err == nil {
+		udpServer.MulticastTTL = ttl
+	}
+	if len(udpServer.SigningKey) == 0 {
+		log.Println("⚠️ MANGAHUB_UDP_KEY is not set: UDP admin announcements will be rejected")
+	}
+	if udpServer.MulticastGroup != "" && len(udpServer.MulticastKey) == 0 {
+		log.Println("⚠️ MANGAHUB_MULTICAST_KEY is not set: LAN multicast stays off")
+	}
+	// Listen before the server is shared: delivery reads its socket from other goroutines
+	if err := udpServer.Listen(); err != nil {
+		log.Println("⚠️ UDP Listen Error:", err)
+	} else {
+		notifications.Channels[notification.ChannelUDP] = udpServer // Users can opt into UDP delivery
+		go udpServer.Start()
 	}
-	go udpServer.Start()
 
 	// 4. Initialize Gin
 	r := gin.Default()
+	// Login throttling goes by client IP, so only believe X-Forwarded-For from known proxies
+	var trustedProxies []string
+	if proxies := os.Getenv("MANGAHUB_TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = strings.Split(proxies, ",")
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Trusted Proxies Error:", err)
+	}
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-User-Role"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", auth.HeaderAPIKey},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 
-	authCtrl := &auth.AuthController{DB: db}
+	authCtrl := &auth.AuthController{
+		DB:      db,
+		Mailer:  mail.FromEnv(), // SMTP, .eml files or the log, see README
+		BaseURL: "http://localhost:8080",
+	}
+	// Single sign-on is off unless providers are configured
+	if path := os.Getenv("MANGAHUB_OIDC_CONFIG"); path != "" {
+		authCtrl.OIDC, err = auth.LoadOIDCProviders(path)
+		if err != nil {
+			log.Fatal("OIDC Config Error:", err)
+		}
+		log.Printf("🔐 %d OIDC provider(s) configured", len(authCtrl.OIDC))
+	}
 	mangaCtrl := &manga.MangaController{GRPCClient: mangaClient}
 	userCtrl := &user.UserController{
 		DB:            db,
 		TCPServerAddr: "localhost:8081", // Pointing to your standalone TCP server
 	}
+	notifCtrl := &notification.NotificationController{Service: notifications}
+	feedCtrl := &feed.FeedController{
+		DB:      db,
+		BaseURL: "http://localhost:8080",
+	}
 
 	// --- ROUTES ---
 
@@ -110,10 +239,30 @@ func main() {
 	r.StaticFile("/", "./index.html")
 
 	// Public Routes
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, auth.Keys.JWKS())
+	})
 	r.POST("/auth/register", authCtrl.Register)
 	r.POST("/auth/login", authCtrl.Login)
+	r.POST("/auth/login/2fa", authCtrl.LoginSecondFactor)
+	r.POST("/auth/refresh", authCtrl.Refresh)
+	r.POST("/auth/logout", auth.AuthRequired(), authCtrl.Logout)
+	r.GET("/auth/verify-email", authCtrl.VerifyEmail)
+	r.POST("/auth/verify-email/resend", auth.AuthRequired(), authCtrl.ResendVerification)
+	r.POST("/auth/password/forgot", authCtrl.ForgotPassword)
+	r.POST("/auth/password/reset", authCtrl.ResetPassword)
+	r.GET("/auth/oidc/providers", authCtrl.ListOIDCProviders)
+	r.GET("/auth/oidc/:provider/login", authCtrl.OIDCLogin)
+	r.GET("/auth/oidc/:provider/callback", authCtrl.OIDCCallback)
 	r.GET("/manga/:id", mangaCtrl.GetMangaDetails)
 
+	// Atom/RSS Feeds (?format=atom|rss)
+	r.GET("/feeds/manga", feedCtrl.NewManga)
+	r.GET("/feeds/manga/:id/chapters", feedCtrl.MangaChapters)
+	r.GET("/feeds/genres/:genre/chapters", feedCtrl.GenreChapters)
+	r.GET("/feeds/library/:token", feedCtrl.Library)
+
 	r.GET("/debug/ids", func(c *gin.Context) {
 		rows, _ := db.Query("SELECT id FROM manga")
 		var ids []string
@@ -125,41 +274,95 @@ func main() {
 		c.JSON(200, ids)
 	})
 
-	// Admin Route (UDP Trigger)
-	r.POST("/admin/add-manga", func(c *gin.Context) {
-		// 1. Role Check
-		role, _ := c.Get("role")
-		if role != "admin" {
-			c.JSON(403, gin.H{"error": "Admin only"})
+	// Admin Routes: every one needs a token and a permission of the caller's role
+	adminRoutes := r.Group("/admin")
+
+	adminRoutes.POST("/add-manga", auth.AuthRequired(auth.PermMangaWrite), func(c *gin.Context) {
+		var input struct {
+			ID     string   `json:"id"`
+			Title  string   `json:"title"`
+			Author string   `json:"author"`
+			Genres []string `json:"genres"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		// 1. Add to Database (genres stored as JSON text, same as the seeder)
+		genresJSON, _ := json.Marshal(input.Genres)
+		_, err := db.Exec("INSERT INTO manga (id, title, author, genres, created_at) VALUES (?, ?, ?, ?, ?)",
+			input.ID, input.Title, input.Author, string(genresJSON), time.Now().Unix())
+		if err != nil {
+			c.JSON(500, gin.H{"error": "DB Error: " + err.Error()})
 			return
 		}
 
+		// 2. NOTIFY: Only users following this author or one of its genres
+		notifications.NotifyFollowers(notification.Subject{
+			MangaID: input.ID,
+			Author:  input.Author,
+			Genres:  input.Genres,
+		}, models.Notification{
+			Type:  notification.TypeNewManga,
+			Title: "New Manga Added: " + input.Title,
+			Body:  "by " + input.Author,
+			Link:  "/manga/" + input.ID,
+		})
+
+		c.JSON(200, gin.H{"status": "Manga created and notification sent!"})
+	})
+
+	adminRoutes.POST("/manga/:id/chapters", auth.AuthRequired(auth.PermMangaWrite), func(c *gin.Context) {
 		var input struct {
-			ID     string `json:"id"`
+			Number int    `json:"number" binding:"required,min=1"`
 			Title  string `json:"title"`
-			Author string `json:"author"`
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(400, gin.H{"error": "Invalid input"})
 			return
 		}
 
-		// 2. Add to Database
-		_, err := db.Exec("INSERT INTO manga (id, title, author) VALUES (?, ?, ?)",
-			input.ID, input.Title, input.Author)
+		var mangaTitle string
+		var author, genresRaw sql.NullString
+		err := db.QueryRow("SELECT title, author, genres FROM manga WHERE id = ?", c.Param("id")).
+			Scan(&mangaTitle, &author, &genresRaw)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "DB Error: " + err.Error()})
+			c.JSON(404, gin.H{"error": "Manga not found"})
 			return
 		}
 
-		// 3. BROADCAST: Notify the network via UDP
-		// This will go to the UDP server, which sends it to the Hub
-		broadcastNewManga("New Manga Added: " + input.Title)
+		_, err = db.Exec("INSERT INTO chapters (manga_id, number, title, created_at) VALUES (?, ?, ?, ?)",
+			c.Param("id"), input.Number, input.Title, time.Now().Unix())
+		if err != nil {
+			c.JSON(409, gin.H{"error": "Chapter already exists"})
+			return
+		}
+		db.Exec("UPDATE manga SET total_chapters = MAX(IFNULL(total_chapters, 0), ?) WHERE id = ?",
+			input.Number, c.Param("id"))
+
+		var genres []string
+		json.Unmarshal([]byte(genresRaw.String), &genres)
+
+		notifications.NotifyFollowers(notification.Subject{
+			MangaID: c.Param("id"),
+			Author:  author.String,
+			Genres:  genres,
+		}, models.Notification{
+			Type:  notification.TypeNewChapter,
+			Title: fmt.Sprintf("New Chapter: %s #%d", mangaTitle, input.Number),
+			Body:  input.Title,
+			Link:  "/manga/" + c.Param("id"),
+		})
+
+		c.JSON(200, gin.H{"status": "Chapter added and notification sent!"})
+	})
 
-		c.JSON(200, gin.H{"status": "Manga created and broadcast sent!"})
+	adminRoutes.GET("/udp/stats", auth.AuthRequired(auth.PermStatsRead), func(c *gin.Context) {
+		c.JSON(200, gin.H{"rejections": udpServer.Rejections()})
 	})
 
-	r.DELETE("/admin/manga/:id", func(c *gin.Context) {
+	adminRoutes.DELETE("/manga/:id", auth.AuthRequired(auth.PermMangaWrite), func(c *gin.Context) {
 		id := c.Param("id")
 		_, err := db.Exec("DELETE FROM manga WHERE id = ?", id)
 		if err != nil {
@@ -169,6 +372,13 @@ func main() {
 		c.JSON(200, gin.H{"message": "Manga removed"})
 	})
 
+	adminRoutes.GET("/roles", auth.AuthRequired(auth.PermUsersManage), authCtrl.ListRoles)
+	adminRoutes.GET("/users", auth.AuthRequired(auth.PermUsersManage), authCtrl.ListUsers)
+	adminRoutes.PUT("/users/:id/role", auth.AuthRequired(auth.PermUsersManage), authCtrl.AssignRole)
+	adminRoutes.GET("/users/:id/lockout", auth.AuthRequired(auth.PermUsersManage), authCtrl.LockoutStatus)
+	adminRoutes.POST("/users/:id/unlock", auth.AuthRequired(auth.PermUsersManage), authCtrl.UnlockUser)
+	adminRoutes.GET("/login-attempts", auth.AuthRequired(auth.PermUsersManage), authCtrl.ListLoginAttempts)
+
 	// WebSocket Route (REMOVED DUPLICATE - Keeping the Protected version)
 	// This satisfies the "Distinguish UserID" requirement using JWT
 	r.GET("/ws/guest", func(c *gin.Context) {
@@ -177,23 +387,10 @@ func main() {
 			return
 		}
 
-		client := &socket.Client{
-			Conn:     conn,
-			UserID:   "GUEST",
-			Username: "Guest_Viewer",
-		}
-		hub.Register <- client
-
-		// IMPORTANT: You need this loop to keep the connection alive!
-		go func() {
-			defer func() { hub.Unregister <- client }()
-			for {
-				if _, _, err := conn.ReadMessage(); err != nil {
-					break
-				}
-			}
-		}()
+		// Guests can join and leave rooms; the hub refuses their chat messages.
+		hub.Serve(socket.NewClient(conn, socket.GuestID, "Guest_Viewer"))
 	})
+	r.GET("/ws/notifications", auth.AuthRequired(), notifCtrl.Connect)
 	r.GET("/ws/chat", auth.AuthRequired(), func(c *gin.Context) {
 		uid, _ := c.Get("user_id")
 		uname, _ := c.Get("username")
@@ -207,40 +404,90 @@ func main() {
 			return
 		}
 
-		client := &socket.Client{
-			Conn:     conn,
-			UserID:   fmt.Sprintf("%v", uid),
-			Username: fmt.Sprintf("%v", uname),
-		}
-
-		hub.Register <- client
+		// The client's own pumps do the reading and writing; the hub fills in
+		// the sender and checks room membership
+		client := socket.NewClient(conn, fmt.Sprintf("%v", uid), fmt.Sprintf("%v", uname))
+		client.Moderator = auth.HasPermission(c.GetString("role"), auth.PermChatModerate)
+		hub.Serve(client)
+	})
 
-		go func() {
-			defer func() { hub.Unregister <- client }()
-			for {
-				var msg socket.ChatMessage
-				// If the frontend sends a message that doesn't match the ChatMessage struct,
-				// this ReadJSON will fail and close the connection.
-				if err := conn.ReadJSON(&msg); err != nil {
-					log.Printf("Read Error: %v", err)
-					break
-				}
+	chatCtrl := &socket.ChatController{Hub: hub}
+	r.GET("/chat/rooms", chatCtrl.ListRooms)
+	r.GET("/chat/rooms/:id/messages", auth.OptionalAuth(), chatCtrl.Messages)
+	r.GET("/chat/presence", auth.AuthRequired(), chatCtrl.Presence)
+	r.GET("/chat/conversations", auth.AuthRequired(), chatCtrl.Conversations)
+	r.GET("/chat/conversations/:user_id/messages", auth.AuthRequired(), chatCtrl.DirectMessages)
+	r.POST("/chat/conversations/:user_id/read", auth.AuthRequired(), chatCtrl.MarkRead)
+
+	modCtrl := &socket.ModerationController{Hub: hub}
+	r.GET("/chat/rooms/:id/settings", modCtrl.GetRoomSettings)
+	r.POST("/chat/messages/:id/report", auth.AuthRequired(), modCtrl.ReportMessage)
+	modRoutes := r.Group("/chat")
+	modRoutes.Use(auth.AuthRequired(auth.PermChatModerate))
+	{
+		modRoutes.DELETE("/messages/:id", modCtrl.DeleteMessage)
+		modRoutes.POST("/mutes", modCtrl.Mute)
+		modRoutes.DELETE("/mutes/:user_id", modCtrl.Unmute)
+		modRoutes.POST("/bans", modCtrl.Ban)
+		modRoutes.DELETE("/bans/:user_id", modCtrl.Unban)
+		modRoutes.GET("/sanctions", modCtrl.ListSanctions)
+		modRoutes.PUT("/rooms/:id/settings", modCtrl.UpdateRoomSettings)
+		modRoutes.GET("/reports", modCtrl.ListReports)
+		modRoutes.PUT("/reports/:id", modCtrl.ResolveReport)
+		modRoutes.GET("/moderation/log", modCtrl.Log)
+	}
 
-				msg.Username = client.Username
-				msg.UserID = client.UserID
-				hub.Broadcast <- msg
-			}
-		}()
-	})
+	// Reading progress, which API keys with the progress:write scope may update
+	r.POST("/users/library", auth.AuthRequired(auth.PermProgressWrite), userCtrl.AddToLibrary)
+	r.PUT("/users/progress", auth.AuthRequired(auth.PermProgressWrite), userCtrl.UpdateProgress)
 
 	// Protected User Routes
 	userRoutes := r.Group("/users")
 	userRoutes.Use(auth.AuthRequired())
 	{
-		userRoutes.POST("/library", userCtrl.AddToLibrary)
-		userRoutes.PUT("/progress", userCtrl.UpdateProgress)
+		userRoutes.GET("/feed-token", feedCtrl.GetFeedToken)
+		userRoutes.POST("/feed-token", feedCtrl.RotateFeedToken)
+		userRoutes.GET("/notifications", notifCtrl.List)
+		userRoutes.POST("/notifications/read-all", notifCtrl.MarkAllRead)
+		userRoutes.POST("/notifications/:id/read", notifCtrl.MarkRead)
+		userRoutes.GET("/follows", notifCtrl.ListFollows)
+		userRoutes.POST("/follows", notifCtrl.Follow)
+		userRoutes.DELETE("/follows/:type/:target", notifCtrl.Unfollow)
+		userRoutes.GET("/blocks", chatCtrl.ListBlocks)
+		userRoutes.POST("/blocks", chatCtrl.Block)
+		userRoutes.DELETE("/blocks/:user_id", chatCtrl.Unblock)
+		userRoutes.GET("/notification-prefs", notifCtrl.GetPrefs)
+		userRoutes.PUT("/notification-prefs", notifCtrl.UpdatePrefs)
+		userRoutes.PUT("/email", authCtrl.UpdateEmail)
+		userRoutes.GET("/2fa", authCtrl.MFAStatus)
+		userRoutes.POST("/2fa/enroll", authCtrl.EnrollMFA)
+		userRoutes.POST("/2fa/verify", authCtrl.VerifyMFA)
+		userRoutes.POST("/2fa/recovery-codes", authCtrl.RegenerateRecoveryCodes)
+		userRoutes.DELETE("/2fa", authCtrl.DisableMFA)
+		userRoutes.GET("/api-keys", authCtrl.ListAPIKeys)
+		userRoutes.POST("/api-keys", authCtrl.CreateAPIKey)
+		userRoutes.DELETE("/api-keys/:id", authCtrl.RevokeAPIKey)
+	}
+
+	addr := envOr("MANGAHUB_HTTP_ADDR", ":8080")
+	log.Println("🚀 Gateway running on " + addr)
+	r.Run(addr)
+}
+
+// splitList splits a comma-separated setting, dropping empty entries
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
+}
 
-	log.Println("🚀 Gateway running on :8080")
-	r.Run(":8080")
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
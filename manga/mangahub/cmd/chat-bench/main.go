@@ -0,0 +1,194 @@
+// Measures chat broadcast latency with many WebSocket clients in one room.
+// The hub runs in-process (no database) behind a local HTTP server, so the
+// numbers show the hub and its write pumps, not SQLite.
+//
+//	go run cmd/chat-bench/main.go -clients 100,1000,5000
+//
+// To watch slow consumers get dropped instead of holding everyone up, send
+// more messages than a client's send buffer holds:
+//
+//	go run cmd/chat-bench/main.go -clients 100 -slow 10 -messages 2000 -interval 1ms -size 4096
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	socket "mangahub/internal/websocket"
+
+	"github.com/gorilla/websocket"
+)
+
+const benchRoom = "topic:bench"
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+func main() {
+	counts := flag.String("clients", "100,1000,2000", "Comma-separated client counts to try")
+	messages := flag.Int("messages", 200, "Messages to broadcast per run")
+	interval := flag.Duration("interval", 20*time.Millisecond, "Pause between messages")
+	size := flag.Int("size", 128, "Extra payload bytes per message")
+	slow := flag.Int("slow", 0, "Clients per run that never read (should be dropped, not slow everyone down)")
+	flag.Parse()
+
+	fmt.Printf("%8s %8s %10s %10s %10s %10s %12s %8s\n", "clients", "msgs", "p50", "p90", "p99", "max", "frames/s", "dropped")
+	for _, s := range strings.Split(*counts, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			log.Fatalf("❌ Bad client count %q", s)
+		}
+		run(n, *messages, *interval, *size, *slow)
+	}
+}
+
+type receiver struct {
+	conn      *websocket.Conn
+	latencies []time.Duration
+}
+
+func run(n, messages int, interval time.Duration, size, slow int) {
+	hub := socket.NewChatHub(nil) // No store: nothing is persisted
+	hub.MessageRate = 0           // Senders post faster than a person would
+	hub.FrameRate = 0
+	go hub.Run()
+
+	var nextID int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		id := atomic.AddInt64(&nextID, 1)
+		hub.Serve(socket.NewClient(conn, fmt.Sprint(id), fmt.Sprint("bench-", id)))
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			log.Fatalf("❌ Dial failed (raise the open file limit?): %v", err)
+		}
+		conn.WriteJSON(socket.ClientFrame{Type: socket.TypeJoin, Room: benchRoom})
+		return conn
+	}
+
+	// Receivers note how long each bench message took to arrive; the
+	// message text carries its send time.
+	var received, warm int64
+	var wg sync.WaitGroup
+	receivers := make([]*receiver, n)
+	for i := range receivers {
+		rc := &receiver{conn: dial()}
+		receivers[i] = rc
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var env socket.Envelope
+				if err := rc.conn.ReadJSON(&env); err != nil {
+					return
+				}
+				if env.Type != socket.TypeMessage || env.Room != benchRoom {
+					continue
+				}
+				msg := env.Message
+				if msg.Message == "warmup" {
+					atomic.AddInt64(&warm, 1)
+					continue
+				}
+				sent, _ := strconv.ParseInt(strings.SplitN(msg.Message, " ", 2)[0], 10, 64)
+				rc.latencies = append(rc.latencies, time.Since(time.Unix(0, sent)))
+				atomic.AddInt64(&received, 1)
+			}
+		}()
+	}
+	slowConns := make([]*websocket.Conn, slow)
+	for i := range slowConns {
+		slowConns[i] = dial()
+	}
+
+	sender := dial()
+	defer hangUp(sender)
+	go func() {
+		for {
+			if _, _, err := sender.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Wait until every receiver has drained the join storm
+	sender.WriteJSON(socket.ClientFrame{Room: benchRoom, Message: "warmup"})
+	waitFor(&warm, int64(n), 60*time.Second)
+
+	padding := strings.Repeat("x", size)
+	start := time.Now()
+	for i := 0; i < messages; i++ {
+		sender.WriteJSON(socket.ClientFrame{Room: benchRoom, Message: fmt.Sprintf("%d %s", time.Now().UnixNano(), padding)})
+		time.Sleep(interval)
+	}
+	waitFor(&received, int64(n*messages), 60*time.Second)
+	elapsed := time.Since(start)
+
+	// Slow clients that are still in the room were not dropped
+	dropped := 0
+	for _, room := range hub.ListRooms() {
+		if room.Name == benchRoom {
+			dropped = n + slow + 1 - room.Members
+		}
+	}
+
+	// Hanging up makes a storm of leave frames nobody reads; keep it off the log
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, rc := range receivers {
+		hangUp(rc.conn)
+	}
+	for _, conn := range slowConns {
+		hangUp(conn)
+	}
+	wg.Wait()
+
+	var all []time.Duration
+	for _, rc := range receivers {
+		all = append(all, rc.latencies...)
+	}
+	if len(all) == 0 {
+		log.Printf("❌ %d clients: nothing was delivered", n)
+		return
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	pct := func(p float64) time.Duration { return all[int(float64(len(all)-1)*p)] }
+	fmt.Printf("%8d %8d %10v %10v %10v %10v %12.0f %8d\n", n, messages,
+		pct(0.50).Round(time.Microsecond), pct(0.90).Round(time.Microsecond), pct(0.99).Round(time.Microsecond),
+		all[len(all)-1].Round(time.Microsecond), float64(len(all))/elapsed.Seconds(), dropped)
+	if missing := n*messages - len(all); missing > 0 {
+		log.Printf("⚠️ %d of %d frames never arrived", missing, n*messages)
+	}
+}
+
+func waitFor(counter *int64, want int64, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(counter) < want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// hangUp closes cleanly so the server does not log an abnormal closure
+func hangUp(conn *websocket.Conn) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os"
 	"strings"
+	"time"
 
+	"mangahub/internal/auth"
+	"mangahub/internal/backplane"
 	"mangahub/pkg/database"
 	"mangahub/proto"
 
@@ -21,7 +25,8 @@ type mangaServer struct {
 
 // Implement the GetManga RPC
 func (s *mangaServer) GetManga(ctx context.Context, req *proto.GetMangaRequest) (*proto.MangaResponse, error) {
-	fmt.Printf("🔍 gRPC Server received request for ID: %s\n", req.Id)
+	caller, _ := auth.IdentityFromContext(ctx)
+	fmt.Printf("🔍 gRPC Server received request for ID: %s (from %s)\n", req.Id, caller.Username)
 
 	var m proto.MangaResponse
 	var genresRaw string // Temporary variable to hold the JSON text from DB
@@ -65,9 +70,53 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	// 3. Register Server
-	s := grpc.NewServer()
+	// 3. Tokens are verified with the keys the API gateway publishes
+	jwksURL := os.Getenv("MANGAHUB_JWKS_URL")
+	if jwksURL == "" {
+		jwksURL = "http://localhost:8080/.well-known/jwks.json"
+	}
+	auth.Verifier = auth.NewJWKSClient(jwksURL)
+	auth.APIKeys = &auth.APIKeyStore{DB: db} // Same database as the gateway
+	// Tokens revoked by any gateway (logout, refresh token reuse) are refused here too
+	if err := auth.Revocations.Load(db); err != nil {
+		log.Fatalf("failed to load token revocations: %v", err)
+	}
+	go auth.Revocations.Prune(10 * time.Minute)
+
+	// 4. Every call needs a user JWT or the gateway's service token
+	serviceToken := os.Getenv("MANGAHUB_SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Println("⚠️ MANGAHUB_SERVICE_TOKEN is not set: only calls with a user token are accepted")
+	}
+	guard := &auth.GRPCAuth{
+		ServiceToken:       serviceToken,
+		ServicePermissions: []auth.Permission{auth.PermMangaRead, auth.PermBackplane},
+		Permissions: map[string]auth.Permission{
+			proto.MangaService_GetManga_FullMethodName:       auth.PermMangaRead,
+			proto.MangaService_SearchManga_FullMethodName:    auth.PermMangaRead,
+			proto.MangaService_UpdateProgress_FullMethodName: auth.PermProgressWrite,
+			proto.Backplane_Connect_FullMethodName:           auth.PermBackplane, // Gateways only
+		},
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(guard.Unary()),
+		grpc.ChainStreamInterceptor(guard.Stream()),
+	}
+
+	// 5. Optional mutual TLS: only clients with a certificate from our CA may connect
+	if cert := os.Getenv("MANGAHUB_GRPC_CERT"); cert != "" {
+		creds, err := auth.ServerTLS(cert, os.Getenv("MANGAHUB_GRPC_KEY"), os.Getenv("MANGAHUB_GRPC_CA"))
+		if err != nil {
+			log.Fatalf("failed to load TLS credentials: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+		log.Println("🔒 gRPC mutual TLS enabled")
+	}
+
+	// 6. Register Server
+	s := grpc.NewServer(opts...)
 	proto.RegisterMangaServiceServer(s, &mangaServer{DB: db})
+	backplane.RegisterBroker(s, backplane.NewBroker()) // Relays chat between API gateways
 
 	log.Println("🚀 gRPC Internal Service running on :50051")
 	if err := s.Serve(lis); err != nil {
@@ -0,0 +1,24 @@
+// A stand-in OpenID Connect provider for trying single sign-on locally.
+// It signs in anyone under any name: never expose it beyond your machine.
+package main
+
+import (
+	"flag"
+	"log"
+	"mangahub/internal/oidcdev"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "Listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "Issuer URL (must match the MangaHub config)")
+	clientID := flag.String("client-id", "mangahub", "Client ID accepted by this provider")
+	flag.Parse()
+
+	p, err := oidcdev.New(*issuer, *clientID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("🧪 Dev OIDC provider %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"log"
+	"mangahub/internal/auth"
 	"mangahub/internal/tcp" // Ensure this matches your new structure
+	"mangahub/pkg/database"
+	"os"
+	"time"
 )
 
 func main() {
@@ -10,6 +14,22 @@ func main() {
 	// We pass the port we want it to listen on
 	server := tcp.NewProgressSyncServer("8081")
 
+	// Verify the forwarded tokens against the API gateway's published keys
+	if url := os.Getenv("MANGAHUB_JWKS_URL"); url != "" {
+		auth.Verifier = auth.NewJWKSClient(url)
+		log.Printf("🔑 Verifying progress updates with keys from %s", url)
+
+		// ...and refuse tokens the gateway has revoked, from the shared database
+		db, err := database.InitDB()
+		if err != nil {
+			log.Fatal("DB Error:", err)
+		}
+		if err := auth.Revocations.Load(db); err != nil {
+			log.Fatal("Revocation List Error:", err)
+		}
+		go auth.Revocations.Prune(10 * time.Minute)
+	}
+
 	log.Println("🛰️ Starting Standalone TCP Progress Sync Server...")
 
 	// 2. Start the server (This is a blocking call, so no 'go' keyword here)
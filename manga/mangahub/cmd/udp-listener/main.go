@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"mangahub/pkg/models"
+	"mangahub/pkg/notifyclient"
+	"os"
+)
+
+func main() {
+	server := flag.String("server", "127.0.0.1:12345", "UDP notification server address")
+	token := flag.String("token", "", "JWT to also receive your own alerts (optional)")
+	loss := flag.Float64("loss", 0, "Simulated packet loss rate (0-1) to watch NACK recovery")
+	group := flag.String("multicast", "", "Join this multicast group instead of registering (e.g. 239.255.42.99:12346)")
+	iface := flag.String("iface", "", "Network interface for -multicast (default: system choice)")
+	standalone := flag.Bool("no-repair", false, "With -multicast, do not register with -server to MNACK gaps")
+	flag.Parse()
+
+	var client *notifyclient.Client
+	var err error
+	if *group != "" {
+		// Group packets are signed with the server's MANGAHUB_MULTICAST_KEY
+		repair := *server
+		if *standalone {
+			repair = ""
+		}
+		client, err = notifyclient.ListenMulticast(*group, *iface, repair, []byte(os.Getenv("MANGAHUB_MULTICAST_KEY")))
+	} else {
+		client, err = notifyclient.Dial(*server, *token)
+	}
+	if err != nil {
+		log.Fatalf("❌ Could not open UDP socket: %v", err)
+	}
+	if *loss > 0 {
+		client.Conn = &notifyclient.LossyConn{PacketConn: client.Conn, DropRate: *loss}
+	}
+	defer client.Close()
+
+	if *group == "" || !*standalone {
+		if err := client.Register(); err != nil {
+			log.Fatalf("❌ Register failed: %v", err)
+		}
+	}
+	log.Printf("👂 Listening for notifications...")
+
+	err = client.Run(func(seq uint64, n models.Notification) {
+		log.Printf("🔔 #%d [%s] %s", seq, n.Type, n.Title)
+	})
+	if err != nil {
+		log.Fatalf("❌ Listener stopped: %v", err)
+	}
+}
@@ -2,19 +2,20 @@ package main
 
 import (
 	"fmt"
-	"net"
+	"mangahub/pkg/notifyclient"
 	"os"
 	"strings"
 )
 
 func main() {
 	serverAddr := "127.0.0.1:12345"
-	conn, err := net.Dial("udp", serverAddr)
-	if err != nil {
-		fmt.Printf("Failed to connect: %v\n", err)
+
+	// The server only accepts announcements signed with the shared key
+	key := os.Getenv("MANGAHUB_UDP_KEY")
+	if key == "" {
+		fmt.Println("MANGAHUB_UDP_KEY is not set; the server would reject this announcement")
 		return
 	}
-	defer conn.Close()
 
 	// Default message or take from command line
 	msg := "Admin added: Chainsaw Man Chapter 1"
@@ -22,6 +23,13 @@ func main() {
 		msg = strings.Join(os.Args[1:], " ")
 	}
 
-	conn.Write([]byte(msg))
-	fmt.Println("🚀 Admin Trigger: UDP Broadcast sent to network!")
+	err := notifyclient.SendAnnouncement(serverAddr, []byte(key), notifyclient.Announcement{
+		Type:  "system",
+		Title: msg,
+	})
+	if err != nil {
+		fmt.Printf("Failed to send: %v\n", err)
+		return
+	}
+	fmt.Println("🚀 Admin Trigger: Signed UDP announcement sent to network!")
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"log"
+	"mangahub/internal/auth"
 	"mangahub/proto"
+	"os"
 	"time"
 
 	"google.golang.org/grpc"
@@ -11,8 +13,10 @@ import (
 )
 
 func main() {
-	// Connect to the server
-	conn, err := grpc.Dial("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// Connect to the server (cleartext is fine: it never leaves this host)
+	conn, err := grpc.Dial("localhost:50051",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(auth.ServiceCredentials{Token: os.Getenv("MANGAHUB_SERVICE_TOKEN"), AllowInsecure: true}))
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on how long an API key may live
+const (
+	DefaultAPIKeyDays = 90
+	MaxAPIKeyDays     = 365
+)
+
+// GET /users/api-keys
+// Lists the caller's keys. The secret itself is only ever shown on creation.
+func (ac *AuthController) ListAPIKeys(c *gin.Context) {
+	rows, err := ac.DB.Query(`SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys WHERE user_id = ? ORDER BY created_at DESC`, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []gin.H{}
+	for rows.Next() {
+		var id, createdAt, expiresAt int64
+		var name, prefix, scopesJSON string
+		var lastUsed, revokedAt *int64
+		if err := rows.Scan(&id, &name, &prefix, &scopesJSON, &createdAt, &expiresAt, &lastUsed, &revokedAt); err != nil {
+			continue
+		}
+		var scopes []Permission
+		json.Unmarshal([]byte(scopesJSON), &scopes)
+		keys = append(keys, gin.H{
+			"id":           id,
+			"name":         name,
+			"prefix":       prefix,
+			"scopes":       scopes,
+			"created_at":   createdAt,
+			"expires_at":   expiresAt,
+			"last_used_at": lastUsed,
+			"revoked_at":   revokedAt,
+		})
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// POST /users/api-keys
+// Creates a key limited to scopes the caller's role already grants.
+func (ac *AuthController) CreateAPIKey(c *gin.Context) {
+	// A leaked key must not be able to mint more keys
+	if c.GetString("auth_method") == "api_key" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot create API keys"})
+		return
+	}
+	// Keys inherit whether their session passed a second factor, so they must
+	// come from one where the role needs it
+	if !mfaSatisfied(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role requires two-factor authentication before creating API keys"})
+		return
+	}
+
+	var input struct {
+		Name          string       `json:"name" binding:"required,max=64"`
+		Scopes        []Permission `json:"scopes" binding:"required,min=1"`
+		ExpiresInDays int          `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role := c.GetString("role")
+	for _, s := range input.Scopes {
+		if !HasPermission(role, s) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your role does not grant scope: " + string(s)})
+			return
+		}
+	}
+	if input.ExpiresInDays == 0 {
+		input.ExpiresInDays = DefaultAPIKeyDays
+	}
+	if input.ExpiresInDays < 1 || input.ExpiresInDays > MaxAPIKeyDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and 365"})
+		return
+	}
+
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate API key"})
+		return
+	}
+	scopesJSON, _ := json.Marshal(input.Scopes)
+	now := time.Now()
+	expiresAt := now.AddDate(0, 0, input.ExpiresInDays).Unix()
+	res, err := ac.DB.Exec(`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at, mfa)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.GetString("user_id"), input.Name, prefix, hashToken(key), string(scopesJSON), now.Unix(), expiresAt, c.GetBool("mfa"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save API key"})
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         id,
+		"name":       input.Name,
+		"key":        key, // Shown once; only the hash is stored
+		"scopes":     input.Scopes,
+		"expires_at": expiresAt,
+	})
+}
+
+// DELETE /users/api-keys/:id
+func (ac *AuthController) RevokeAPIKey(c *gin.Context) {
+	res, err := ac.DB.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().Unix(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
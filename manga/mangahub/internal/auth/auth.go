@@ -9,45 +9,129 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthRequired() gin.HandlerFunc {
+// AuthRequired only lets authenticated callers through. Listing perms also
+// checks them, like RequirePermission, and is what opens the route to API
+// keys: a key only works where AuthRequired names the permissions it needs.
+// Everything else (account settings, chat, logout...) is for people.
+func AuthRequired(perms ...Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
-			tokenString = c.Query("token")
-		} else {
-			tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+		if status, msg := authenticate(c, len(perms) > 0); status != 0 {
+			c.AbortWithStatusJSON(status, gin.H{"error": msg})
+			return
 		}
-
-		if tokenString == "" {
-			c.AbortWithStatusJSON(401, gin.H{"error": "No token provided"})
+		if status, msg := checkPermissions(c, perms); status != 0 {
+			c.AbortWithStatusJSON(status, gin.H{"error": msg})
 			return
 		}
+		c.Next()
+	}
+}
 
-		// PARSING LOGIC
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the algorithm is HMAC (HS256)
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			// RETURN THE EXACT SAME KEY USED IN LOGIN
-			return []byte("MangaHub_Secret_Key_2024"), nil
-		})
-
-		if err != nil || !token.Valid {
-			fmt.Printf("❌ JWT Error: %v\n", err) // DEBUG: Check terminal for this
-			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token"})
-			return
+// authenticate identifies the caller from an API key or a JWT and records
+// who they are on the context. On failure it sets nothing and returns the
+// status and error to answer with.
+func authenticate(c *gin.Context, allowAPIKey bool) (int, string) {
+	// Scripts authenticate with an API key, limited to the key's scopes
+	if key := c.GetHeader(HeaderAPIKey); key != "" {
+		if APIKeys == nil {
+			return 401, "API keys are not enabled"
+		}
+		if !allowAPIKey {
+			return 403, "API keys cannot be used on this route"
 		}
+		principal, err := APIKeys.Authenticate(key)
+		if err != nil {
+			return 401, err.Error()
+		}
+		c.Set("user_id", principal.UserID)
+		c.Set("username", principal.Username)
+		c.Set("role", principal.Role)
+		c.Set("scopes", principal.Scopes)
+		c.Set("auth_method", "api_key")
+		c.Set("mfa", principal.MFA)
+		return 0, ""
+	}
+
+	tokenString := c.GetHeader("Authorization")
+	if tokenString == "" {
+		tokenString = c.Query("token")
+	} else {
+		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	}
+
+	if tokenString == "" {
+		return 401, "No token provided"
+	}
+
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		fmt.Printf("❌ JWT Error: %v\n", err) // DEBUG: Check terminal for this
+		return 401, "Invalid token"
+	}
+
+	// Save as strings to be safe for the WebSocket logic
+	c.Set("user_id", fmt.Sprintf("%v", claims["user_id"]))
+	c.Set("username", fmt.Sprintf("%v", claims["username"]))
+	c.Set("role", fmt.Sprintf("%v", claims["role"]))
+	c.Set("jti", fmt.Sprintf("%v", claims["jti"]))
+	c.Set("auth_method", "jwt")
+	c.Set("mfa", claims["mfa"] == true)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		c.Set("exp", exp.Time)
+	}
+	return 0, ""
+}
+
+// OptionalAuth identifies the caller like AuthRequired when valid
+// credentials are sent. Everyone else, including callers with an expired or
+// bad token, goes through as anonymous, without a user_id.
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authenticate(c, false)
+		c.Next()
+	}
+}
 
-		// RESOLVING CLAIMS
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Save as strings to be safe for the WebSocket logic
-			c.Set("user_id", fmt.Sprintf("%v", claims["user_id"]))
-			c.Set("username", fmt.Sprintf("%v", claims["username"]))
-			c.Set("role", fmt.Sprintf("%v", claims["role"]))
-			c.Next()
-		} else {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid claims"})
+// ParseToken validates a JWT issued by Login and returns its claims.
+// Used by AuthRequired and by non-HTTP servers (UDP, TCP) that receive tokens.
+func ParseToken(tokenString string) (jwt.MapClaims, error) {
+	if Verifier == nil {
+		return nil, fmt.Errorf("no token verifier configured")
+	}
+	claims, err := verifyJWT(tokenString, Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logged out or part of a compromised refresh token family
+	if jti, _ := claims["jti"].(string); jti != "" && Revocations.IsRevoked(jti) {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+	return claims, nil
+}
+
+// verifyJWT checks the signature against keys (picked by kid, and only with
+// the algorithm that key was made for) plus the standard time claims
+func verifyJWT(tokenString string, keys KeySource, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, alg, err := keys.PublicKey(kid)
+		if err != nil {
+			return nil, err
 		}
+		if token.Method.Alg() != alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid claims")
 	}
+	return claims, nil
 }
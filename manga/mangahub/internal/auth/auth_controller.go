@@ -3,61 +3,104 @@ package auth
 import (
 	"database/sql"
 	"fmt"
+	"mangahub/internal/mail"
 	"mangahub/pkg/models"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var JWTKey = []byte("MangaHub_Secret_Key_2024")
-
 type AuthController struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Mailer  mail.Mailer
+	BaseURL string // Public URL of the API, used in emailed links and OIDC redirects
+	OIDC    map[string]*OIDCProvider
 }
 
 // Register Request Structure
 type RegisterInput struct {
 	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=6"`
 }
 
+type LoginInput struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
 func (ac *AuthController) Register(c *gin.Context) {
 	var input RegisterInput
-	// ... binding ...
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := normalizeEmail(input.Email)
 
 	// 1. Hash the password before saving!
-	hashed, _ := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Registration failed"})
+		return
+	}
 
 	// 2. Insert using the CORRECT column name
-	_, err := ac.DB.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
-		input.Username, string(hashed), "user")
+	res, err := ac.DB.Exec("INSERT INTO users (username, password_hash, role, email) VALUES (?, ?, ?, ?)",
+		input.Username, string(hashed), RoleUser, email)
 
 	if err != nil {
 		// Log the ACTUAL error to your terminal so you can see it
 		fmt.Println("Registration Error:", err)
-		c.JSON(400, gin.H{"error": "Registration failed"})
+		c.JSON(400, gin.H{"error": "Registration failed, username or email already taken"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "Success"})
+
+	// 3. Ask the user to confirm the address; the account works meanwhile
+	id, _ := res.LastInsertId()
+	if err := ac.sendVerification(fmt.Sprint(id), email); err != nil {
+		fmt.Println("❌ Mail Error:", err)
+	}
+	c.JSON(200, gin.H{"message": "Success, check your email to verify your address"})
 }
 
 func (ac *AuthController) Login(c *gin.Context) {
-	var input RegisterInput // Reusing same structure for login
+	var input LoginInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		fmt.Println("❌ JSON Binding Error:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Count the attempt before checking anything, then refuse if there were
+	// too many recent failures for this account or address
+	ip := c.ClientIP()
+	attempt, wait, locked, err := reserveAttempt(ac.DB, input.Username, "", ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check login"})
+		return
+	}
+	if wait > 0 {
+		retryAfter := int(wait.Seconds()) + 1
+		c.Header("Retry-After", fmt.Sprint(retryAfter))
+		if locked {
+			c.JSON(http.StatusLocked, gin.H{"error": "Account temporarily locked after too many failed logins", "retry_after": retryAfter})
+		} else {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, slow down", "retry_after": retryAfter})
+		}
+		return
+	}
+
 	var user models.User
-	err := ac.DB.QueryRow("SELECT id, username, password_hash, role FROM users WHERE username = ?",
-		input.Username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role)
+	var mfaEnabled bool
+	err = ac.DB.QueryRow("SELECT id, username, password_hash, role, IFNULL(totp_enabled, 0) FROM users WHERE username = ?",
+		input.Username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &mfaEnabled)
 
 	if err != nil {
-		fmt.Println("❌ Database Query Error:", err)
+		// Burn the same bcrypt time as a real check, so unknown names cannot be told apart
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(input.Password))
+		finishAttempt(ac.DB, attempt, "", attemptUnknownUser)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
@@ -65,24 +108,137 @@ func (ac *AuthController) Login(c *gin.Context) {
 	// Check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password))
 	if err != nil {
+		finishAttempt(ac.DB, attempt, user.ID, attemptBadPassword)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
-	// Generate JWT
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  fmt.Sprint(user.ID), // Is this a string or int?
-		"username": user.Username,
-		"role":     user.Role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-	})
+	// With 2FA on, the password only earns a challenge for /auth/login/2fa.
+	// The attempt neither fails nor succeeds, so failed codes keep counting
+	// towards lockout.
+	if mfaEnabled {
+		finishAttempt(ac.DB, attempt, user.ID, attemptVerified)
+		challenge, err := newMFAChallenge(ac.DB, user.ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Could not start two-factor login"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"mfa_required":    true,
+			"challenge_token": challenge,
+			"expires_in":      int(MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+	finishAttempt(ac.DB, attempt, user.ID, attemptSuccess)
+
+	// Generate a short-lived access token + a refresh token (new family)
+	tokens, err := issueTokens(ac.DB, user, false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Could not generate token"})
+		return
+	}
 
-	tokenString, err := token.SignedString(JWTKey)
+	c.JSON(200, tokens)
+}
 
+// POST /auth/refresh
+// Exchanges a refresh token for a new pair. Each refresh token works once;
+// presenting a used one means it was stolen, so the whole family is revoked.
+func (ac *AuthController) Refresh(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var id int64
+	var userID, familyID string
+	var expiresAt int64
+	var usedAt, revokedAt sql.NullInt64
+	var mfa bool
+	err := ac.DB.QueryRow(`SELECT id, user_id, family_id, expires_at, used_at, revoked_at, mfa
+		FROM refresh_tokens WHERE token_hash = ?`, hashToken(input.RefreshToken)).
+		Scan(&id, &userID, &familyID, &expiresAt, &usedAt, &revokedAt, &mfa)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if revokedAt.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token revoked"})
+		return
+	}
+	if usedAt.Valid {
+		// Reuse detected: someone else holds a copy of this session
+		fmt.Printf("🚨 Refresh token reuse for user %s, revoking family %s\n", userID, familyID)
+		revokeFamily(ac.DB, familyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+		return
+	}
+	if time.Now().Unix() > expiresAt {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	// Mark as used only if nobody beat us to it (two concurrent refreshes)
+	res, err := ac.DB.Exec("UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL",
+		time.Now().Unix(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not refresh token"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		revokeFamily(ac.DB, familyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+		return
+	}
+
+	var user models.User
+	err = ac.DB.QueryRow("SELECT id, username, role FROM users WHERE id = ?", userID).
+		Scan(&user.ID, &user.Username, &user.Role)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+		return
+	}
+
+	tokens, err := issueTokens(ac.DB, user, mfa, familyID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Could not generate token"})
 		return
 	}
+	c.JSON(200, tokens)
+}
+
+// POST /auth/logout
+// Revokes the current access token and the refresh token family it belongs to.
+func (ac *AuthController) Logout(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	c.ShouldBindJSON(&input) // Optional body
+
+	jti := c.GetString("jti")
+	if exp, ok := c.Get("exp"); ok {
+		Revocations.Revoke(jti, exp.(time.Time))
+	} else {
+		Revocations.Revoke(jti, time.Now().Add(AccessTokenTTL))
+	}
+
+	// Find the session: by the refresh token if given, else by the access token
+	var familyID string
+	var err error
+	if input.RefreshToken != "" {
+		err = ac.DB.QueryRow("SELECT family_id FROM refresh_tokens WHERE token_hash = ? AND user_id = ?",
+			hashToken(input.RefreshToken), c.GetString("user_id")).Scan(&familyID)
+	} else {
+		err = ac.DB.QueryRow("SELECT family_id FROM refresh_tokens WHERE access_jti = ?", jti).Scan(&familyID)
+	}
+	if err == nil {
+		revokeFamily(ac.DB, familyID)
+	}
 
-	c.JSON(200, gin.H{"token": tokenString})
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
 }
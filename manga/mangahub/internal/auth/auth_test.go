@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOptionalAuthTreatsBadCredentialsAsAnonymous(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/optional", OptionalAuth(), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("user_id")) })
+	r.GET("/required", AuthRequired(), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("user_id")) })
+
+	for _, header := range []string{"", "Bearer not-a-jwt"} {
+		req := httptest.NewRequest("GET", "/optional", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "" {
+			t.Fatalf("optional with %q: %d %q, want anonymous", header, w.Code, w.Body)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/required", nil)
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("required with a bad token: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRevocationsAreSharedThroughTheDB(t *testing.T) {
+	db := testDB(t)
+	gateway := &RevocationList{revoked: make(map[string]time.Time)}
+	other := &RevocationList{revoked: make(map[string]time.Time)}
+	if err := gateway.Load(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Load(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if other.IsRevoked("jti-1") {
+		t.Fatal("revoked before logout")
+	}
+	// Logged out on the gateway after the other process loaded its list
+	gateway.Revoke("jti-1", time.Now().Add(AccessTokenTTL))
+	if !other.IsRevoked("jti-1") {
+		t.Fatal("revocation on another process not seen")
+	}
+	if other.IsRevoked("jti-2") {
+		t.Fatal("unrelated token revoked")
+	}
+
+	db.Close()
+	if !other.IsRevoked("jti-3") {
+		t.Fatal("token accepted while revocations could not be checked")
+	}
+}
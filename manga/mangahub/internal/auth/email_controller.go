@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"mangahub/internal/mail"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Lifetimes of the links we email
+const (
+	VerifyEmailTTL   = 24 * time.Hour
+	PasswordResetTTL = 30 * time.Minute
+)
+
+// Purposes of rows in email_tokens
+const (
+	purposeVerify = "verify"
+	purposeReset  = "reset"
+)
+
+// issueEmailToken stores a single-use token for purpose and returns it.
+// email is the address it was sent to, so a link stops working once the
+// user switches to another address.
+func issueEmailToken(db *sql.DB, userID, email, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = db.Exec(`INSERT INTO email_tokens (token_hash, user_id, email, purpose, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, hashToken(token), userID, email, purpose, now.Unix(), now.Add(ttl).Unix())
+	return token, err
+}
+
+// consumeEmailToken marks the token used and returns whom it was for. The
+// UPDATE is the check, so two concurrent uses cannot both succeed.
+func consumeEmailToken(db *sql.DB, token, purpose string) (string, string, error) {
+	now := time.Now().Unix()
+	res, err := db.Exec(`UPDATE email_tokens SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at >= ?`,
+		now, hashToken(token), purpose, now)
+	if err != nil {
+		return "", "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "", fmt.Errorf("link is invalid, expired or already used")
+	}
+	var userID, email string
+	err = db.QueryRow("SELECT user_id, email FROM email_tokens WHERE token_hash = ?", hashToken(token)).
+		Scan(&userID, &email)
+	return userID, email, err
+}
+
+func (ac *AuthController) sendVerification(userID, email string) error {
+	token, err := issueEmailToken(ac.DB, userID, email, purposeVerify, VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := ac.BaseURL + "/auth/verify-email?token=" + url.QueryEscape(token)
+	return ac.Mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Confirm your MangaHub email address",
+		Body: "Welcome to MangaHub!\n\nConfirm this address by opening:\n" + link +
+			"\n\nThe link is valid for 24 hours. If you did not sign up, ignore this email.",
+	})
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// isUniqueViolation reports whether err is SQLite refusing a duplicate in a
+// UNIQUE column or index, e.g. column "users.email"
+func isUniqueViolation(err error, column string) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: "+column)
+}
+
+// GET /auth/verify-email?token=
+func (ac *AuthController) VerifyEmail(c *gin.Context) {
+	userID, email, err := consumeEmailToken(ac.DB, c.Query("token"), purposeVerify)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := ac.DB.Exec("UPDATE users SET email_verified = 1 WHERE id = ? AND email = ?", userID, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify email"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This link is for an address you no longer use"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// POST /auth/verify-email/resend
+func (ac *AuthController) ResendVerification(c *gin.Context) {
+	var email sql.NullString
+	var verified bool
+	err := ac.DB.QueryRow("SELECT email, email_verified FROM users WHERE id = ?", c.GetString("user_id")).
+		Scan(&email, &verified)
+	if err != nil || !email.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No email address on file, set one with PUT /users/email"})
+		return
+	}
+	if verified {
+		c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+		return
+	}
+	if err := ac.sendVerification(c.GetString("user_id"), email.String); err != nil {
+		fmt.Println("❌ Mail Error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not send verification email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// PUT /users/email
+// Sets or changes the address; it counts as unverified until the link is opened.
+// The address is where password resets go, so a stolen session alone must
+// not be able to change it: the current password is required, and wrong
+// ones count towards the login lockout.
+func (ac *AuthController) UpdateEmail(c *gin.Context) {
+	var input struct {
+		Email           string `json:"email" binding:"required,email"`
+		CurrentPassword string `json:"current_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := normalizeEmail(input.Email)
+	userID := c.GetString("user_id")
+
+	var username, passwordHash string
+	err := ac.DB.QueryRow("SELECT username, IFNULL(password_hash, '') FROM users WHERE id = ?", userID).
+		Scan(&username, &passwordHash)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if passwordHash == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account signs in through single sign-on; its address comes from the provider"})
+		return
+	}
+	ip := c.ClientIP()
+	attempt, wait, _, err := reserveAttempt(ac.DB, username, userID, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check password"})
+		return
+	}
+	if wait > 0 {
+		retryAfter := int(wait.Seconds()) + 1
+		c.Header("Retry-After", fmt.Sprint(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, slow down", "retry_after": retryAfter})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(input.CurrentPassword)) != nil {
+		finishAttempt(ac.DB, attempt, "", attemptBadPassword)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	finishAttempt(ac.DB, attempt, "", attemptVerified)
+
+	_, err = ac.DB.Exec("UPDATE users SET email = ?, email_verified = 0 WHERE id = ?", email, userID)
+	if isUniqueViolation(err, "users.email") {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email address already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update email"})
+		return
+	}
+	if err := ac.sendVerification(userID, email); err != nil {
+		fmt.Println("❌ Mail Error:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email updated, check your inbox to verify it"})
+}
+
+// POST /auth/password/forgot
+// Always answers the same way, and before looking the address up, so neither
+// the reply nor its timing reveals which addresses are registered.
+func (ac *AuthController) ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	go ac.sendPasswordReset(normalizeEmail(input.Email))
+	c.JSON(http.StatusOK, gin.H{"message": "If that address belongs to a verified account, a reset link is on its way"})
+}
+
+// sendPasswordReset mails a reset token to the account with this address,
+// if there is one. It runs after the request has been answered.
+func (ac *AuthController) sendPasswordReset(email string) {
+	// Only verified addresses: an unconfirmed one may be a typo for someone else's
+	var userID string
+	err := ac.DB.QueryRow("SELECT id FROM users WHERE email = ? AND email_verified = 1", email).Scan(&userID)
+	if err != nil {
+		return
+	}
+
+	// One email per minute per account is plenty
+	var recent int
+	ac.DB.QueryRow("SELECT COUNT(*) FROM email_tokens WHERE user_id = ? AND purpose = ? AND created_at > ?",
+		userID, purposeReset, time.Now().Add(-time.Minute).Unix()).Scan(&recent)
+	if recent > 0 {
+		return
+	}
+
+	token, err := issueEmailToken(ac.DB, userID, email, purposeReset, PasswordResetTTL)
+	if err != nil {
+		fmt.Println("❌ Password reset error:", err)
+		return
+	}
+	err = ac.Mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Reset your MangaHub password",
+		Body: "Someone asked to reset the password of your MangaHub account.\n\n" +
+			"Your reset token:\n" + token + "\n\nOr open:\n" +
+			ac.BaseURL + "/?reset_token=" + url.QueryEscape(token) +
+			"\n\nIt works once and expires in 30 minutes. If this was not you, ignore this email.",
+	})
+	if err != nil {
+		fmt.Println("❌ Mail Error:", err)
+	}
+}
+
+// POST /auth/password/reset
+// Sets a new password with a token from ForgotPassword and logs out every session.
+func (ac *AuthController) ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=6"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _, err := consumeEmailToken(ac.DB, input.Token, purposeReset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+	if _, err := ac.DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hashed), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+
+	// Other outstanding reset links die with this one, and so do existing sessions
+	ac.DB.Exec("UPDATE email_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		time.Now().Unix(), userID, purposeReset)
+	revokeUserSessions(ac.DB, userID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated, please log in"})
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mangahub/internal/mail"
+	"mangahub/pkg/database"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeSMTP accepts every message on a loopback port and hands each one's
+// data to the test
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	mails := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, mails)
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func serveSMTP(conn net.Conn, mails chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 fake ESMTP")
+	var data *strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if data != nil {
+			if line == "." {
+				mails <- data.String()
+				data = nil
+				reply("250 queued")
+			} else {
+				data.WriteString(line + "\n")
+			}
+			continue
+		}
+		switch cmd := strings.ToUpper(line); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case cmd == "DATA":
+			data = &strings.Builder{}
+			reply("354 end with .")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// testDB opens a fresh database in a temporary directory
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	t.Chdir(t.TempDir()) // InitDB creates data/mangahub.db in the working directory
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// emailTestServer runs the email routes against a fresh database, mailing
+// through a fake SMTP server. Requests act as the user in the X-Test-User header.
+func emailTestServer(t *testing.T) (*gin.Engine, *sql.DB, <-chan string) {
+	t.Helper()
+	db := testDB(t)
+	addr, mails := fakeSMTP(t)
+	ac := &AuthController{DB: db, Mailer: &mail.SMTPMailer{Addr: addr, From: "MangaHub <no-reply@example.com>"}, BaseURL: "http://mangahub.test"}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/password/forgot", ac.ForgotPassword)
+	r.POST("/auth/password/reset", ac.ResetPassword)
+	r.PUT("/users/email", func(c *gin.Context) { c.Set("user_id", c.GetHeader("X-Test-User")) }, ac.UpdateEmail)
+	return r, db, mails
+}
+
+func addUser(t *testing.T, db *sql.DB, username, password, email string) string {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	res, err := db.Exec("INSERT INTO users (username, password_hash, role, email, email_verified) VALUES (?, ?, ?, ?, 1)",
+		username, string(hash), RoleUser, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return fmt.Sprint(id)
+}
+
+func call(r *gin.Engine, method, path, userID string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", userID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func awaitMail(t *testing.T, mails <-chan string) string {
+	t.Helper()
+	select {
+	case m := <-mails:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail arrived")
+		return ""
+	}
+}
+
+func TestForgotPasswordMailsResetToken(t *testing.T) {
+	r, db, mails := emailTestServer(t)
+	addUser(t, db, "alice", "secret12", "alice@example.com")
+
+	known := call(r, "POST", "/auth/password/forgot", "", gin.H{"email": "Alice@Example.com"})
+	unknown := call(r, "POST", "/auth/password/forgot", "", gin.H{"email": "nobody@example.com"})
+	if known.Code != http.StatusOK || known.Body.String() != unknown.Body.String() {
+		t.Fatalf("replies differ: %d %s / %d %s", known.Code, known.Body, unknown.Code, unknown.Body)
+	}
+
+	msg := awaitMail(t, mails)
+	if !strings.Contains(msg, "To: alice@example.com") {
+		t.Fatalf("mail not addressed to alice:\n%s", msg)
+	}
+	token := regexp.MustCompile(`reset_token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg)
+	if token == nil {
+		t.Fatalf("no reset link in mail:\n%s", msg)
+	}
+	select {
+	case m := <-mails:
+		t.Fatalf("mail sent for an unknown address:\n%s", m)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if w := call(r, "POST", "/auth/password/reset", "", gin.H{"token": token[1], "password": "newpass99"}); w.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "POST", "/auth/password/reset", "", gin.H{"token": token[1], "password": "again99"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("token reused: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUpdateEmailNeedsCurrentPassword(t *testing.T) {
+	r, db, mails := emailTestServer(t)
+	alice := addUser(t, db, "alice", "secret12", "alice@example.com")
+	addUser(t, db, "bob", "secret34", "bob@example.com")
+
+	if w := call(r, "PUT", "/users/email", alice, gin.H{"email": "new@example.com"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("without password: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "PUT", "/users/email", alice, gin.H{"email": "new@example.com", "current_password": "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "PUT", "/users/email", alice, gin.H{"email": "bob@example.com", "current_password": "secret12"}); w.Code != http.StatusConflict {
+		t.Fatalf("taken address: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "PUT", "/users/email", alice, gin.H{"email": "new@example.com", "current_password": "secret12"}); w.Code != http.StatusOK {
+		t.Fatalf("change: %d %s", w.Code, w.Body)
+	}
+	if msg := awaitMail(t, mails); !strings.Contains(msg, "To: new@example.com") || !strings.Contains(msg, "/auth/verify-email?token=") {
+		t.Fatalf("no verification mail to the new address:\n%s", msg)
+	}
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys carrying credentials on gRPC calls
+const (
+	MetadataAuthorization = "authorization"   // "Bearer <JWT>" of the end user
+	MetadataServiceToken  = "x-service-token" // Shared secret of a trusted internal caller
+	MetadataAPIKey        = "x-api-key"       // Personal API key of a script
+)
+
+// Identity is who made a gRPC call. Handlers read it with IdentityFromContext.
+type Identity struct {
+	UserID   string
+	Username string
+	Role     string
+	Service  bool         // Authenticated by the service token rather than a user JWT
+	Scopes   []Permission // Set for API keys: the call is limited to these
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the caller set by the GRPCAuth interceptors
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// GRPCAuth authenticates every call with either a user JWT or the service
+// token, and enforces the permission listed for the method.
+type GRPCAuth struct {
+	ServiceToken       string                // Empty disables service-to-service calls
+	ServicePermissions []Permission          // What a service caller may do
+	Permissions        map[string]Permission // Full method name -> required permission; unlisted methods only need authentication
+}
+
+// Unary returns the interceptor for unary RPCs
+func (ga *GRPCAuth) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := ga.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the interceptor for streaming RPCs
+func (ga *GRPCAuth) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := ga.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// identityStream swaps in the context that carries the caller's Identity
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context { return s.ctx }
+
+func (ga *GRPCAuth) authorize(ctx context.Context, method string) (context.Context, error) {
+	id, err := ga.authenticate(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	perm, ok := ga.Permissions[method]
+	if !ok && id.Scopes != nil {
+		// As over HTTP, API keys only reach methods that name a scope
+		return nil, status.Error(codes.PermissionDenied, "API keys cannot call this method")
+	}
+	if ok && !ga.allowed(id, perm) {
+		return nil, status.Errorf(codes.PermissionDenied, "missing permission: %s", perm)
+	}
+	return context.WithValue(ctx, identityKey{}, id), nil
+}
+
+// authenticate prefers the user's JWT, so calls the gateway forwards on behalf
+// of a user are checked against that user's role
+func (ga *GRPCAuth) authenticate(ctx context.Context) (Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get(MetadataAuthorization); len(values) > 0 {
+		claims, err := ParseToken(strings.TrimPrefix(values[0], "Bearer "))
+		if err != nil {
+			return Identity{}, err
+		}
+		return Identity{
+			UserID:   fmt.Sprint(claims["user_id"]),
+			Username: fmt.Sprint(claims["username"]),
+			Role:     fmt.Sprint(claims["role"]),
+		}, nil
+	}
+
+	if values := md.Get(MetadataAPIKey); len(values) > 0 && APIKeys != nil {
+		p, err := APIKeys.Authenticate(values[0])
+		if err != nil {
+			return Identity{}, err
+		}
+		return Identity{UserID: p.UserID, Username: p.Username, Role: p.Role, Scopes: p.Scopes}, nil
+	}
+
+	if values := md.Get(MetadataServiceToken); len(values) > 0 && ga.ServiceToken != "" &&
+		subtle.ConstantTimeCompare([]byte(values[0]), []byte(ga.ServiceToken)) == 1 {
+		return Identity{Username: "service", Service: true}, nil
+	}
+	return Identity{}, fmt.Errorf("no valid credentials provided")
+}
+
+func (ga *GRPCAuth) allowed(id Identity, perm Permission) bool {
+	if id.Scopes != nil && !hasScope(id.Scopes, perm) {
+		return false
+	}
+	if !id.Service {
+		return HasPermission(id.Role, perm)
+	}
+	for _, p := range ga.ServicePermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceCredentials attaches the service token to every outgoing call.
+// Use it with grpc.WithPerRPCCredentials. gRPC refuses to send it over a
+// connection without TLS unless AllowInsecure is set, which only makes sense
+// for a server on this host (see LocalAddr).
+type ServiceCredentials struct {
+	Token         string
+	AllowInsecure bool
+}
+
+func (sc ServiceCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{MetadataServiceToken: sc.Token}, nil
+}
+
+func (sc ServiceCredentials) RequireTransportSecurity() bool {
+	return !sc.AllowInsecure
+}
+
+// LocalAddr reports whether a host:port address is on this host, where
+// cleartext gRPC never leaves the machine
+func LocalAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// WithAPIKey forwards a script's API key on an outgoing gRPC call
+func WithAPIKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataAPIKey, key)
+}
+
+// WithUserToken forwards an end user's JWT on an outgoing gRPC call
+func WithUserToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataAuthorization, "Bearer "+token)
+}
+
+// ServerTLS loads mutual TLS credentials for the gRPC server: clients must
+// present a certificate signed by the CA in caFile.
+func ServerTLS(certFile, keyFile, caFile string) (credentials.TransportCredentials, error) {
+	cert, pool, err := loadTLSFiles(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+// ClientTLS loads mutual TLS credentials for calling the gRPC server
+func ClientTLS(certFile, keyFile, caFile, serverName string) (credentials.TransportCredentials, error) {
+	cert, pool, err := loadTLSFiles(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+func loadTLSFiles(certFile, keyFile, caFile string) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("load certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return cert, pool, nil
+}
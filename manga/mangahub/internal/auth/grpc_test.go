@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCAuthorize(t *testing.T) {
+	cr := newCredentials(t)
+	ga := &GRPCAuth{
+		ServiceToken:       "service-secret",
+		ServicePermissions: []Permission{PermMangaRead, PermBackplane},
+		Permissions: map[string]Permission{
+			"/manga.MangaService/GetManga":     PermMangaRead,
+			"/manga.MangaService/CreateManga":  PermMangaWrite,
+			"/backplane.Backplane/Publish":     PermBackplane,
+			"/manga.MangaService/UpdateStatus": PermProgressWrite,
+		},
+	}
+	const (
+		read     = "/manga.MangaService/GetManga"
+		write    = "/manga.MangaService/CreateManga"
+		publish  = "/backplane.Backplane/Publish"
+		progress = "/manga.MangaService/UpdateStatus"
+		unlisted = "/manga.MangaService/Whoami"
+	)
+
+	userJWT := "Bearer " + cr.token(RoleUser, false)
+	adminJWT := "Bearer " + cr.token(RoleAdmin, true)
+	readKey := cr.apiKey(RoleUser, false, PermMangaRead)
+	for _, tc := range []struct {
+		name    string
+		method  string
+		md      []string // Metadata key/value pairs
+		want    codes.Code
+		service bool   // Expected identity, when allowed
+		role    string // Expected identity, when allowed
+	}{
+		{"no credentials", read, nil, codes.Unauthenticated, false, ""},
+		{"user reads", read, []string{MetadataAuthorization, userJWT}, codes.OK, false, RoleUser},
+		{"user writes", write, []string{MetadataAuthorization, userJWT}, codes.PermissionDenied, false, ""},
+		{"user on unlisted method", unlisted, []string{MetadataAuthorization, userJWT}, codes.OK, false, RoleUser},
+		{"admin writes", write, []string{MetadataAuthorization, adminJWT}, codes.OK, false, RoleAdmin},
+		{"admin on the backplane", publish, []string{MetadataAuthorization, adminJWT}, codes.PermissionDenied, false, ""},
+		{"bad JWT", read, []string{MetadataAuthorization, "Bearer nope"}, codes.Unauthenticated, false, ""},
+
+		{"key with scope", read, []string{MetadataAPIKey, readKey}, codes.OK, false, RoleUser},
+		{"key without scope", progress, []string{MetadataAPIKey, readKey}, codes.PermissionDenied, false, ""},
+		{"key on unlisted method", unlisted, []string{MetadataAPIKey, readKey}, codes.PermissionDenied, false, ""},
+		{"unknown key", read, []string{MetadataAPIKey, "mh_nope"}, codes.Unauthenticated, false, ""},
+
+		{"service reads", read, []string{MetadataServiceToken, "service-secret"}, codes.OK, true, ""},
+		{"service publishes", publish, []string{MetadataServiceToken, "service-secret"}, codes.OK, true, ""},
+		{"service writes", write, []string{MetadataServiceToken, "service-secret"}, codes.PermissionDenied, false, ""},
+		{"wrong service token", read, []string{MetadataServiceToken, "guess"}, codes.Unauthenticated, false, ""},
+
+		// A JWT wins over an API key, which wins over the service token
+		{"JWT and service token", publish, []string{MetadataAuthorization, userJWT, MetadataServiceToken, "service-secret"}, codes.PermissionDenied, false, ""},
+		{"JWT and service token, allowed", read, []string{MetadataAuthorization, userJWT, MetadataServiceToken, "service-secret"}, codes.OK, false, RoleUser},
+		{"bad JWT and service token", read, []string{MetadataAuthorization, "Bearer nope", MetadataServiceToken, "service-secret"}, codes.Unauthenticated, false, ""},
+		{"API key and service token", publish, []string{MetadataAPIKey, readKey, MetadataServiceToken, "service-secret"}, codes.PermissionDenied, false, ""},
+		{"JWT and API key", progress, []string{MetadataAuthorization, userJWT, MetadataAPIKey, readKey}, codes.OK, false, RoleUser},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tc.md...))
+		ctx, err := ga.authorize(ctx, tc.method)
+		if code := status.Code(err); code != tc.want {
+			t.Errorf("%s: %v, want %s", tc.name, err, tc.want)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		id, _ := IdentityFromContext(ctx)
+		if id.Service != tc.service || id.Role != tc.role {
+			t.Errorf("%s: identity %+v", tc.name, id)
+		}
+	}
+
+	// Without a configured token, no service token is accepted
+	ga.ServiceToken = ""
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataServiceToken, ""))
+	if _, err := ga.authorize(ctx, read); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("empty service token: %v", err)
+	}
+}
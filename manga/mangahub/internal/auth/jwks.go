@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWKSClient verifies tokens against a remote /.well-known/jwks.json, so
+// services without access to the signing keys (gRPC, TCP, third parties) can
+// check tokens on their own.
+type JWKSClient struct {
+	URL        string
+	HTTPClient *http.Client
+	CacheTTL   time.Duration // How long a fetched key set is trusted (default 5m)
+
+	mu        sync.Mutex
+	keys      map[string]JWK
+	fetchedAt time.Time
+}
+
+func NewJWKSClient(url string) *JWKSClient {
+	return &JWKSClient{
+		URL:        url,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+		CacheTTL:   5 * time.Minute,
+	}
+}
+
+// PublicKey implements KeySource. An unknown kid triggers a refetch (at most
+// every few seconds), which is how newly rotated keys are picked up.
+func (jc *JWKSClient) PublicKey(kid string) (crypto.PublicKey, string, error) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	jwk, ok := jc.keys[kid]
+	stale := time.Since(jc.fetchedAt) > jc.CacheTTL
+	if !ok && time.Since(jc.fetchedAt) > 5*time.Second {
+		stale = true
+	}
+	if stale {
+		if err := jc.fetch(); err != nil && len(jc.keys) == 0 {
+			return nil, "", err
+		}
+		jwk, ok = jc.keys[kid]
+	}
+	if !ok && kid == "" && len(jc.keys) == 1 {
+		// Tokens may leave out kid when the issuer has a single key
+		for _, only := range jc.keys {
+			jwk, ok = only, true
+		}
+	}
+	if !ok {
+		return nil, "", fmt.Errorf("unknown key id %q", kid)
+	}
+
+	pub, err := jwk.PublicKey()
+	alg := jwk.Alg
+	if alg == "" {
+		// "alg" is optional in a JWK; other IdPs leave it to the key type
+		alg = AlgRS256
+		if jwk.Kty == "OKP" {
+			alg = AlgEdDSA
+		}
+	}
+	return pub, alg, err
+}
+
+func (jc *JWKSClient) fetch() error {
+	jc.fetchedAt = time.Now()
+
+	resp, err := jc.HTTPClient.Get(jc.URL)
+	if err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch JWKS: %s", resp.Status)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decode JWKS: %w", err)
+	}
+	keys := make(map[string]JWK, len(set.Keys))
+	for _, k := range set.Keys {
+		keys[k.Kid] = k
+	}
+	jc.keys = keys
+	return nil
+}
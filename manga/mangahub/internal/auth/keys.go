@@ -0,0 +1,479 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// DefaultRotationCheck is how often RunRotation looks for a due rotation
+const DefaultRotationCheck = time.Minute
+
+// DefaultKeyReload is how often an unknown kid or a JWKS request may make
+// the stored keys be read again, to pick up keys other gateways generated
+const DefaultKeyReload = time.Second
+
+// Stored private keys are sealed with AES-256-GCM under the key encryption
+// key, with the kid as additional data so rows cannot be swapped
+const sealedKeyType = "MANGAHUB SEALED KEY"
+
+// KeySource resolves the public key that verifies a token signed with kid
+type KeySource interface {
+	PublicKey(kid string) (crypto.PublicKey, string, error) // key, alg
+}
+
+// Keys signs the tokens this process issues. It is only set where tokens are
+// issued (the API gateway).
+var Keys *KeyManager
+
+// Verifier is what ParseToken checks signatures against: the local Keys in
+// the gateway, or a JWKSClient in services that only verify.
+var Verifier KeySource
+
+type signingKey struct {
+	Kid       string
+	Alg       string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	ExpiresAt time.Time // No longer accepted for verification after this
+}
+
+// KeyManager keeps a set of signing keys, identified by kid. The newest key
+// signs; older ones keep verifying until their tokens have expired.
+//
+// With a key encryption key the private keys are stored in the DB, sealed
+// with it, and survive restarts. Gateways sharing the DB and the key
+// encryption key share the keys too: each picks up the others' keys, so a
+// token from one verifies on all of them and in their JWKS. Without one they
+// are never written to disk: they live in memory only, a restart signs
+// everyone out, and only a single gateway can run.
+type KeyManager struct {
+	DB          *sql.DB
+	Alg         string        // Algorithm for newly generated keys
+	Rotate      time.Duration // How often a new signing key is generated
+	CheckEvery  time.Duration // RunRotation interval, defaults to DefaultRotationCheck
+	ReloadEvery time.Duration // Reload throttle, defaults to DefaultKeyReload
+
+	seal       cipher.AEAD // Nil keeps keys out of the DB
+	mu         sync.RWMutex
+	keys       []signingKey // Oldest first
+	reloadMu   sync.Mutex
+	reloadedAt time.Time
+}
+
+// NewKeyManager loads the stored keys, generating the first one if needed.
+// kek is the 32-byte key encryption key, or nil to keep keys in memory only.
+func NewKeyManager(db *sql.DB, alg string, rotate time.Duration, kek []byte) (*KeyManager, error) {
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q (use %s or %s)", alg, AlgRS256, AlgEdDSA)
+	}
+	km := &KeyManager{DB: db, Alg: alg, Rotate: rotate, CheckEvery: DefaultRotationCheck, ReloadEvery: DefaultKeyReload}
+	if kek != nil {
+		block, err := aes.NewCipher(kek)
+		if err != nil || len(kek) != 32 {
+			return nil, fmt.Errorf("key encryption key must be 32 bytes")
+		}
+		if km.seal, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if err := km.load(); err != nil {
+		return nil, err
+	}
+	if km.needsRotation() {
+		if err := km.RotateNow(); err != nil {
+			return nil, err
+		}
+	}
+	return km, nil
+}
+
+func (km *KeyManager) load() error {
+	if km.seal == nil {
+		// Plaintext keys from older versions must not stay on disk. Sealed
+		// ones are left for when the key encryption key is back.
+		res, err := km.DB.Exec("DELETE FROM signing_keys WHERE private_pem NOT LIKE ?", "%"+sealedKeyType+"%")
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("⚠️ Deleted %d plaintext signing keys: set a key encryption key to store keys", n)
+		}
+		return nil
+	}
+
+	plaintext, err := km.readStored()
+	if err != nil {
+		return err
+	}
+
+	// Keys stored in plaintext by older versions are sealed in place
+	for _, k := range plaintext {
+		pemText, err := km.sealPEM(k)
+		if err != nil {
+			return err
+		}
+		if _, err := km.DB.Exec("UPDATE signing_keys SET private_pem = ? WHERE kid = ?", pemText, k.Kid); err != nil {
+			return fmt.Errorf("seal signing key %s: %w", k.Kid, err)
+		}
+	}
+	return nil
+}
+
+// readStored adds the stored keys this process does not have yet, and
+// returns the ones that were stored in plaintext
+func (km *KeyManager) readStored() ([]signingKey, error) {
+	rows, err := km.DB.Query(`SELECT kid, alg, private_pem, created_at, expires_at
+		FROM signing_keys WHERE expires_at > ? ORDER BY created_at`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys, plaintext []signingKey
+	for rows.Next() {
+		var k signingKey
+		var pemText string
+		var created, expires int64
+		if err := rows.Scan(&k.Kid, &k.Alg, &pemText, &created, &expires); err != nil {
+			return nil, err
+		}
+		if km.has(k.Kid) {
+			continue
+		}
+		block, _ := pem.Decode([]byte(pemText))
+		if block == nil {
+			return nil, fmt.Errorf("signing key %s: invalid PEM", k.Kid)
+		}
+		der := block.Bytes
+		if block.Type == sealedKeyType {
+			if der, err = km.open(k.Kid, der); err != nil {
+				return nil, fmt.Errorf("signing key %s: wrong key encryption key?", k.Kid)
+			}
+		}
+		priv, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", k.Kid, err)
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: not a signing key", k.Kid)
+		}
+		k.Private = signer
+		k.CreatedAt = time.Unix(created, 0)
+		k.ExpiresAt = time.Unix(expires, 0)
+		keys = append(keys, k)
+		if block.Type != sealedKeyType {
+			plaintext = append(plaintext, k)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Merged rather than replaced: a key this process generated while the
+	// query ran must not be dropped
+	km.mu.Lock()
+	for _, k := range keys {
+		if !km.hasLocked(k.Kid) {
+			km.keys = append(km.keys, k)
+		}
+	}
+	sort.SliceStable(km.keys, func(i, j int) bool { return km.keys[i].CreatedAt.Before(km.keys[j].CreatedAt) })
+	km.mu.Unlock()
+	return plaintext, nil
+}
+
+// reload picks up keys other gateways stored since the last reload, at most
+// every ReloadEvery
+func (km *KeyManager) reload() {
+	if km.seal == nil {
+		return
+	}
+	km.reloadMu.Lock()
+	defer km.reloadMu.Unlock()
+	if time.Since(km.reloadedAt) < km.ReloadEvery {
+		return
+	}
+	km.reloadedAt = time.Now()
+	if _, err := km.readStored(); err != nil {
+		log.Printf("⚠️ Could not reload signing keys: %v", err)
+	}
+}
+
+func (km *KeyManager) has(kid string) bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.hasLocked(kid)
+}
+
+func (km *KeyManager) hasLocked(kid string) bool {
+	for _, k := range km.keys {
+		if k.Kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// sealPEM encrypts the private key for storage
+func (km *KeyManager) sealPEM(k signingKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, km.seal.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := km.seal.Seal(nonce, nonce, der, []byte(k.Kid))
+	return string(pem.EncodeToMemory(&pem.Block{Type: sealedKeyType, Bytes: sealed})), nil
+}
+
+// open decrypts a sealed private key
+func (km *KeyManager) open(kid string, sealed []byte) ([]byte, error) {
+	size := km.seal.NonceSize()
+	if len(sealed) < size {
+		return nil, fmt.Errorf("sealed key too short")
+	}
+	return km.seal.Open(nil, sealed[:size], sealed[size:], []byte(kid))
+}
+
+func (km *KeyManager) needsRotation() bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	if len(km.keys) == 0 {
+		return true
+	}
+	newest := km.keys[len(km.keys)-1]
+	return newest.Alg != km.Alg || time.Since(newest.CreatedAt) >= km.Rotate
+}
+
+// RotateNow generates a new signing key. Tokens signed with older keys stay
+// valid until those keys expire.
+func (km *KeyManager) RotateNow() error {
+	var priv crypto.Signer
+	var err error
+	switch km.Alg {
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return fmt.Errorf("generate signing key: %w", err)
+	}
+
+	// A key signs until the first rotation check after one rotation period,
+	// and the last token it signed lives AccessTokenTTL longer
+	now := time.Now()
+	k := signingKey{
+		Kid:       uuid.NewString(),
+		Alg:       km.Alg,
+		Private:   priv,
+		CreatedAt: now,
+		ExpiresAt: now.Add(km.Rotate + km.CheckEvery + AccessTokenTTL),
+	}
+	if km.seal != nil {
+		pemText, err := km.sealPEM(k)
+		if err != nil {
+			return err
+		}
+		_, err = km.DB.Exec(`INSERT INTO signing_keys (kid, alg, private_pem, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)`, k.Kid, k.Alg, pemText, k.CreatedAt.Unix(), k.ExpiresAt.Unix())
+		if err != nil {
+			return fmt.Errorf("store signing key: %w", err)
+		}
+	}
+
+	km.mu.Lock()
+	km.keys = append(km.keys, k)
+	km.mu.Unlock()
+	log.Printf("🔑 New %s signing key %s", k.Alg, k.Kid)
+	return nil
+}
+
+// RunRotation generates new keys on schedule and drops expired ones, every
+// CheckEvery. It blocks, so start it with `go`.
+func (km *KeyManager) RunRotation() {
+	ticker := time.NewTicker(km.CheckEvery)
+	defer ticker.Stop()
+	for range ticker.C {
+		// Another gateway may have rotated already; its key is used then
+		km.reload()
+		if km.needsRotation() {
+			if err := km.RotateNow(); err != nil {
+				log.Printf("⚠️ Key rotation failed: %v", err)
+			}
+		}
+
+		now := time.Now()
+		km.mu.Lock()
+		live := km.keys[:0]
+		for _, k := range km.keys {
+			if k.ExpiresAt.After(now) {
+				live = append(live, k)
+			}
+		}
+		km.keys = live
+		km.mu.Unlock()
+		km.DB.Exec("DELETE FROM signing_keys WHERE expires_at < ?", now.Unix())
+	}
+}
+
+// Sign signs the claims with the newest key and puts its kid in the header.
+// A key is never used for a token that would outlive it: if rotation fell
+// behind, a new key is generated first.
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	k, ok := km.current()
+	if !ok {
+		if err := km.RotateNow(); err != nil {
+			return "", fmt.Errorf("no signing key available: %w", err)
+		}
+		if k, ok = km.current(); !ok {
+			return "", fmt.Errorf("no signing key available")
+		}
+	}
+
+	token := jwt.NewWithClaims(signingMethod(k.Alg), claims)
+	token.Header["kid"] = k.Kid
+	return token.SignedString(k.Private)
+}
+
+// current returns the newest key, if it outlives a token signed now
+func (km *KeyManager) current() (signingKey, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	if len(km.keys) == 0 {
+		return signingKey{}, false
+	}
+	k := km.keys[len(km.keys)-1]
+	return k, time.Now().Add(AccessTokenTTL).Before(k.ExpiresAt)
+}
+
+// PublicKey implements KeySource. An unknown kid may be a key another
+// gateway just generated, so the stored keys are read again first.
+func (km *KeyManager) PublicKey(kid string) (crypto.PublicKey, string, error) {
+	if pub, alg, ok := km.publicKey(kid); ok {
+		return pub, alg, nil
+	}
+	km.reload()
+	if pub, alg, ok := km.publicKey(kid); ok {
+		return pub, alg, nil
+	}
+	return nil, "", fmt.Errorf("unknown key id %q", kid)
+}
+
+func (km *KeyManager) publicKey(kid string) (crypto.PublicKey, string, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	for _, k := range km.keys {
+		if k.Kid == kid && time.Now().Before(k.ExpiresAt) {
+			return k.Private.Public(), k.Alg, true
+		}
+	}
+	return nil, "", false
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public half of every key that may still verify a token,
+// including the ones other gateways generated
+func (km *KeyManager) JWKS() JWKS {
+	km.reload()
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range km.keys {
+		if jwk, err := toJWK(k.Kid, k.Alg, k.Private.Public()); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func toJWK(kid, alg string, pub crypto.PublicKey) (JWK, error) {
+	b64 := base64.RawURLEncoding
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: kid, Use: "sig", Alg: alg,
+			N: b64.EncodeToString(key.N.Bytes()),
+			E: b64.EncodeToString(big.NewInt(int64(key.E)).Bytes())}, nil
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: kid, Use: "sig", Alg: alg, Crv: "Ed25519",
+			X: b64.EncodeToString(key)}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// PublicKey decodes the JWK back into a Go public key
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch j.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := b64.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	if alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGatewaysShareStoredKeys(t *testing.T) {
+	db := testDB(t)
+	kek := make([]byte, 32)
+	a, err := NewKeyManager(db, AlgEdDSA, time.Hour, kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewKeyManager(db, AlgEdDSA, time.Hour, kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ReloadEvery = 0
+
+	// b started after a, and a rotates after b started: b must verify both keys
+	first, err := a.Sign(jwt.MapClaims{"user_id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RotateNow(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := a.Sign(jwt.MapClaims{"user_id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{first, second} {
+		if _, err := verifyJWT(token, b); err != nil {
+			t.Fatalf("token from the other gateway: %v", err)
+		}
+	}
+	if n := len(b.JWKS().Keys); n != 2 {
+		t.Fatalf("JWKS has %d keys, want both of a's", n)
+	}
+
+	// Keys kept in memory only are not shared
+	c, err := NewKeyManager(db, AlgEdDSA, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyJWT(second, c); err == nil {
+		t.Fatal("a gateway without the key encryption key verified a stored key")
+	}
+}
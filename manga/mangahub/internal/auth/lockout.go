@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Brute-force limits. Counting is per username (known or not, so lockouts do
+// not reveal which accounts exist) and per client IP.
+const (
+	FreeLoginAttempts = 3                // Failures before backoff starts
+	LockoutAttempts   = 10               // Failures that lock the account
+	LockoutDuration   = 15 * time.Minute // How long a lockout lasts
+	IPFreeAttempts    = 20               // Failures per IP before it is slowed down
+	IPWindow          = 15 * time.Minute // Failures older than this do not count for the IP
+)
+
+// Reasons recorded in login_attempts
+const (
+	attemptSuccess     = "success"
+	attemptBadPassword = "bad_password"
+	attemptUnknownUser = "unknown_user"
+	attemptBad2FA      = "bad_2fa"
+	attemptThrottled   = "throttled"
+	attemptUnlocked    = "unlocked" // Admin reset; not a login attempt
+	attemptPending     = "pending"  // Reserved, credentials still being checked; counts as a failure
+	attemptVerified    = "verified" // Right password or code, but no login yet (or none to come)
+)
+
+// noAttempt bounds the counters below when there is no reserved attempt to
+// count up to
+const noAttempt = math.MaxInt64
+
+// dummyHash is compared against when the username does not exist, so those
+// requests take as long as a wrong password does
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("mangahub-timing-equalizer"), bcrypt.DefaultCost)
+
+// recordAttempt writes the audit row that the counters below are computed from
+func recordAttempt(db *sql.DB, username, userID, ip, reason string) {
+	success := 0
+	if reason == attemptSuccess {
+		success = 1
+	}
+	db.Exec(`INSERT INTO login_attempts (username, user_id, ip, success, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, username, nullIfEmpty(userID), ip, success, reason, time.Now().Unix())
+}
+
+// reserveAttempt records a pending attempt before the credentials are
+// checked, then applies the limits to the attempts recorded before it. The
+// pending row counts as a failure until finishAttempt settles it, so parallel
+// requests see each other and cannot all slip under the limit while bcrypt
+// runs. When the caller must wait, the attempt is settled as throttled.
+func reserveAttempt(db *sql.DB, username, userID, ip string) (int64, time.Duration, bool, error) {
+	res, err := db.Exec(`INSERT INTO login_attempts (username, user_id, ip, success, reason, created_at)
+		VALUES (?, ?, ?, 0, ?, ?)`, username, nullIfEmpty(userID), ip, attemptPending, time.Now().Unix())
+	if err != nil {
+		return 0, 0, false, err
+	}
+	attempt, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	wait, locked := loginAllowed(db, username, ip, attempt)
+	if wait > 0 {
+		finishAttempt(db, attempt, "", attemptThrottled)
+	}
+	return attempt, wait, locked, nil
+}
+
+// finishAttempt settles a reserved attempt with its outcome
+func finishAttempt(db *sql.DB, attempt int64, userID, reason string) {
+	success := 0
+	if reason == attemptSuccess {
+		success = 1
+	}
+	db.Exec(`UPDATE login_attempts SET reason = ?, success = ?, user_id = IFNULL(?, user_id) WHERE id = ?`,
+		reason, success, nullIfEmpty(userID), attempt)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// accountFailures counts failed (or pending) logins since the last success or
+// unlock, among the attempts recorded before the given one
+func accountFailures(db *sql.DB, username string, before int64) (int, time.Time) {
+	var n int
+	var last sql.NullInt64
+	db.QueryRow(`SELECT COUNT(*), MAX(created_at) FROM login_attempts
+		WHERE username = ? AND reason IN (?, ?, ?, ?) AND id < ? AND id > (
+			SELECT IFNULL(MAX(id), 0) FROM login_attempts WHERE username = ? AND reason IN (?, ?) AND id < ?)`,
+		username, attemptBadPassword, attemptUnknownUser, attemptBad2FA, attemptPending, before,
+		username, attemptSuccess, attemptUnlocked, before).Scan(&n, &last)
+	return n, lastAttempt(last)
+}
+
+// ipFailures counts recent failed (or pending) logins from an address, across
+// usernames, among the attempts recorded before the given one
+func ipFailures(db *sql.DB, ip string, before int64) (int, time.Time) {
+	var n int
+	var last sql.NullInt64
+	db.QueryRow(`SELECT COUNT(*), MAX(created_at) FROM login_attempts
+		WHERE ip = ? AND reason IN (?, ?, ?, ?) AND created_at > ? AND id < ?`,
+		ip, attemptBadPassword, attemptUnknownUser, attemptBad2FA, attemptPending,
+		time.Now().Add(-IPWindow).Unix(), before).Scan(&n, &last)
+	return n, lastAttempt(last)
+}
+
+// lastAttempt turns the latest created_at into a time. It is stored in whole
+// seconds, so round up: otherwise a wait could end as soon as it starts.
+func lastAttempt(createdAt sql.NullInt64) time.Time {
+	return time.Unix(createdAt.Int64+1, 0)
+}
+
+// backoffUntil returns when the next attempt is allowed after the given number
+// of failures, the last of them at last. Past the free attempts the wait
+// doubles each time (1s, 2s, 4s, ...); at lockAt failures (0 = never) it
+// becomes a lockout.
+func backoffUntil(failures, free, lockAt int, last time.Time) (time.Time, bool) {
+	if failures < free {
+		return time.Time{}, false
+	}
+	if lockAt > 0 && failures >= lockAt {
+		return last.Add(LockoutDuration), true
+	}
+	shift := failures - free
+	if shift > 10 {
+		shift = 10
+	}
+	wait := time.Duration(1<<shift) * time.Second
+	if wait > LockoutDuration {
+		wait = LockoutDuration
+	}
+	return last.Add(wait), false
+}
+
+// loginAllowed checks both counters over the attempts recorded before the
+// given one. It returns how long to wait and whether the account is locked
+// (as opposed to merely slowed down).
+func loginAllowed(db *sql.DB, username, ip string, before int64) (time.Duration, bool) {
+	now := time.Now()
+	var wait time.Duration
+	locked := false
+
+	n, last := accountFailures(db, username, before)
+	if until, lock := backoffUntil(n, FreeLoginAttempts, LockoutAttempts, last); until.After(now) {
+		wait, locked = until.Sub(now), lock
+	}
+	n, last = ipFailures(db, ip, before)
+	if until, _ := backoffUntil(n, IPFreeAttempts, 0, last); until.Sub(now) > wait {
+		wait = until.Sub(now)
+	}
+	return wait, locked
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /admin/login-attempts?username=&ip=&failed=true&limit=
+// The login audit trail, newest first.
+func (ac *AuthController) ListLoginAttempts(c *gin.Context) {
+	query := "SELECT id, username, IFNULL(user_id, ''), ip, success, reason, created_at FROM login_attempts WHERE 1 = 1"
+	var args []interface{}
+	if u := c.Query("username"); u != "" {
+		query += " AND username = ?"
+		args = append(args, u)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query += " AND ip = ?"
+		args = append(args, ip)
+	}
+	if c.Query("failed") == "true" {
+		query += " AND success = 0"
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := ac.DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+		return
+	}
+	defer rows.Close()
+
+	attempts := []gin.H{}
+	for rows.Next() {
+		var id, createdAt int64
+		var username, userID, ip, reason string
+		var success bool
+		if err := rows.Scan(&id, &username, &userID, &ip, &success, &reason, &createdAt); err == nil {
+			attempts = append(attempts, gin.H{
+				"id": id, "username": username, "user_id": userID, "ip": ip,
+				"success": success, "reason": reason, "created_at": createdAt,
+			})
+		}
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// GET /admin/users/:id/lockout
+func (ac *AuthController) LockoutStatus(c *gin.Context) {
+	var username string
+	if err := ac.DB.QueryRow("SELECT username FROM users WHERE id = ?", c.Param("id")).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	failures, last := accountFailures(ac.DB, username, noAttempt)
+	until, locked := backoffUntil(failures, FreeLoginAttempts, LockoutAttempts, last)
+	active := until.After(time.Now())
+	c.JSON(http.StatusOK, gin.H{
+		"username":        username,
+		"failed_attempts": failures,
+		"locked":          locked && active,
+		"throttled":       active,
+		"until":           until.Unix(),
+	})
+}
+
+// POST /admin/users/:id/unlock
+// Clears the account's failure count; the audit trail is kept.
+func (ac *AuthController) UnlockUser(c *gin.Context) {
+	var username string
+	if err := ac.DB.QueryRow("SELECT username FROM users WHERE id = ?", c.Param("id")).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	recordAttempt(ac.DB, username, c.Param("id"), c.ClientIP(), attemptUnlocked)
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked", "username": username})
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginTestServer serves /auth/login from a fresh database holding alice,
+// whose password is secret12
+func loginTestServer(t *testing.T) (*gin.Engine, *sql.DB) {
+	t.Helper()
+	db := testDB(t)
+	keys, err := NewKeyManager(db, AlgEdDSA, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeys := Keys
+	Keys = keys
+	t.Cleanup(func() { Keys = oldKeys })
+	addUser(t, db, "alice", "secret12", "alice@example.com")
+
+	ac := &AuthController{DB: db}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/login", ac.Login)
+	return r, db
+}
+
+func login(r *gin.Engine, ip, username, password string) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(LoginInput{Username: username, Password: password})
+	req := httptest.NewRequest("POST", "/auth/login", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":4000"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// age moves every recorded attempt back in time
+func age(db *sql.DB, d time.Duration) {
+	db.Exec("UPDATE login_attempts SET created_at = created_at - ?", int64(d.Seconds()))
+}
+
+func TestLoginBackoff(t *testing.T) {
+	r, db := loginTestServer(t)
+	for i := 0; i < FreeLoginAttempts; i++ {
+		if w := login(r, "10.0.0.1", "alice", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: %d %s", i+1, w.Code, w.Body)
+		}
+	}
+	// Even the right password has to wait now
+	w := login(r, "10.0.0.1", "alice", "secret12")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("past the free attempts: %d %s (Retry-After %q)", w.Code, w.Body, w.Header().Get("Retry-After"))
+	}
+
+	// After the wait, one more failure doubles it
+	age(db, 2*time.Second)
+	if w := login(r, "10.0.0.1", "alice", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("after the wait: %d %s", w.Code, w.Body)
+	}
+	if w := login(r, "10.0.0.1", "alice", "secret12"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3" {
+		t.Fatalf("second wait: %d (Retry-After %q)", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// A success resets the count
+	age(db, 3*time.Second)
+	if w := login(r, "10.0.0.1", "alice", "secret12"); w.Code != http.StatusOK {
+		t.Fatalf("right password after the wait: %d %s", w.Code, w.Body)
+	}
+	if w := login(r, "10.0.0.1", "alice", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("failure after a success: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	r, db := loginTestServer(t)
+	for i := 0; i < LockoutAttempts; i++ {
+		age(db, LockoutDuration/2) // Past every backoff, but not past a lockout
+		if w := login(r, "10.0.0.1", "alice", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: %d %s", i+1, w.Code, w.Body)
+		}
+	}
+	age(db, time.Minute)
+	if w := login(r, "10.0.0.2", "alice", "secret12"); w.Code != http.StatusLocked {
+		t.Fatalf("locked account from another address: %d %s", w.Code, w.Body)
+	}
+	age(db, LockoutDuration)
+	if w := login(r, "10.0.0.1", "alice", "secret12"); w.Code != http.StatusOK {
+		t.Fatalf("after the lockout: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLoginLimitsEachAddress(t *testing.T) {
+	r, _ := loginTestServer(t)
+	// Unknown usernames, so no account counter gets in the way
+	for i := 0; i < IPFreeAttempts; i++ {
+		if w := login(r, "10.0.0.1", randomName(t), "guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: %d %s", i+1, w.Code, w.Body)
+		}
+	}
+	if w := login(r, "10.0.0.1", "alice", "secret12"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("from the guessing address: %d %s", w.Code, w.Body)
+	}
+	if w := login(r, "10.0.0.2", "alice", "secret12"); w.Code != http.StatusOK {
+		t.Fatalf("from another address: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestParallelLoginsCannotExceedTheLimit(t *testing.T) {
+	r, db := loginTestServer(t)
+	// A real cost keeps every guess inside bcrypt at the same time
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret12"), bcrypt.DefaultCost)
+	db.Exec("UPDATE users SET password_hash = ? WHERE username = 'alice'", string(hash))
+	const guesses = 10
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- login(r, "10.0.0.1", "alice", "wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	checked := 0
+	for code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			checked++
+		case http.StatusTooManyRequests:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if checked != FreeLoginAttempts {
+		t.Fatalf("%d of %d parallel guesses had their password checked, want %d", checked, guesses, FreeLoginAttempts)
+	}
+}
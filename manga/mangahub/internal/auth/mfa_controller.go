@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"mangahub/pkg/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Second login step limits
+const (
+	MFAChallengeTTL      = 5 * time.Minute
+	MFAChallengeAttempts = 5
+	RecoveryCodeCount    = 10
+)
+
+// MFARequiredRoles lists roles that must sign in with a second factor before
+// a permission check lets them through. Set from MANGAHUB_2FA_REQUIRED_ROLES.
+var MFARequiredRoles = map[string]bool{RoleAdmin: true}
+
+// mfaSatisfied reports whether the request meets the 2FA policy for its role
+func mfaSatisfied(c *gin.Context) bool {
+	return !MFARequiredRoles[c.GetString("role")] || c.GetBool("mfa")
+}
+
+// newMFAChallenge stores the password step of a login and returns the token
+// that /auth/login/2fa exchanges, together with a code, for real tokens
+func newMFAChallenge(db *sql.DB, userID string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	db.Exec("DELETE FROM mfa_challenges WHERE expires_at < ?", now.Unix())
+	_, err = db.Exec("INSERT INTO mfa_challenges (token_hash, user_id, attempts, expires_at) VALUES (?, ?, 0, ?)",
+		hashToken(token), userID, now.Add(MFAChallengeTTL).Unix())
+	return token, err
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code. Both are consumed atomically, so each works only once.
+func checkSecondFactor(db *sql.DB, userID, code, recoveryCode string) bool {
+	now := time.Now()
+	if recoveryCode != "" {
+		res, err := db.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+			now.Unix(), userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return false
+		}
+		n, _ := res.RowsAffected()
+		return n == 1
+	}
+
+	var secret sql.NullString
+	var lastStep int64
+	err := db.QueryRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ?", userID).Scan(&secret, &lastStep)
+	if err != nil || !secret.Valid {
+		return false
+	}
+	step, ok := verifyTOTP(secret.String, code, lastStep, now)
+	if !ok {
+		return false
+	}
+	res, err := db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// sessionSecondFactor checks a code sent to one of the /users/2fa routes.
+// Wrong codes count towards the login lockout like those at /auth/login/2fa,
+// so a stolen session cannot guess its way through them either. On failure
+// the response has been written.
+func (ac *AuthController) sessionSecondFactor(c *gin.Context, code, recoveryCode string) bool {
+	userID := c.GetString("user_id")
+	var username string
+	if err := ac.DB.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return false
+	}
+	ip := c.ClientIP()
+	attempt, wait, locked, err := reserveAttempt(ac.DB, username, userID, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check code"})
+		return false
+	}
+	if wait > 0 {
+		retryAfter := int(wait.Seconds()) + 1
+		c.Header("Retry-After", fmt.Sprint(retryAfter))
+		if locked {
+			c.JSON(http.StatusLocked, gin.H{"error": "Account temporarily locked after too many failed attempts", "retry_after": retryAfter})
+		} else {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, slow down", "retry_after": retryAfter})
+		}
+		return false
+	}
+	if !checkSecondFactor(ac.DB, userID, code, recoveryCode) {
+		finishAttempt(ac.DB, attempt, "", attemptBad2FA)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return false
+	}
+	finishAttempt(ac.DB, attempt, "", attemptVerified)
+	return true
+}
+
+// requireMFASession refuses sessions that did not sign in with a second
+// factor: changing 2FA itself must take more than a password
+func requireMFASession(c *gin.Context) bool {
+	if !c.GetBool("mfa") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Log in with your second factor first"})
+		return false
+	}
+	return true
+}
+
+// replaceRecoveryCodes invalidates old codes and returns a fresh set (shown once)
+func replaceRecoveryCodes(db *sql.DB, userID string) ([]string, error) {
+	if _, err := db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// POST /auth/login/2fa
+// Second login step: trades the challenge token plus a TOTP or recovery code for tokens.
+func (ac *AuthController) LoginSecondFactor(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Count the attempt before checking, so parallel guesses cannot exceed the limit
+	challenge := hashToken(input.ChallengeToken)
+	res, err := ac.DB.Exec(`UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = ? AND attempts < ? AND expires_at >= ?`,
+		challenge, MFAChallengeAttempts, time.Now().Unix())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check login challenge"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired or invalid, please log in again"})
+		return
+	}
+
+	var user models.User
+	err = ac.DB.QueryRow(`SELECT u.id, u.username, IFNULL(u.role, '') FROM mfa_challenges m
+		JOIN users u ON u.id = m.user_id WHERE m.token_hash = ?`, challenge).
+		Scan(&user.ID, &user.Username, &user.Role)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired or invalid, please log in again"})
+		return
+	}
+
+	ip := c.ClientIP()
+	if !checkSecondFactor(ac.DB, user.ID, input.Code, input.RecoveryCode) {
+		recordAttempt(ac.DB, user.Username, user.ID, ip, attemptBad2FA)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+	ac.DB.Exec("DELETE FROM mfa_challenges WHERE token_hash = ?", challenge)
+	recordAttempt(ac.DB, user.Username, user.ID, ip, attemptSuccess)
+
+	tokens, err := issueTokens(ac.DB, user, true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Could not generate token"})
+		return
+	}
+	c.JSON(200, tokens)
+}
+
+// GET /users/2fa
+func (ac *AuthController) MFAStatus(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var enabled bool
+	var remaining int
+	ac.DB.QueryRow("SELECT IFNULL(totp_enabled, 0) FROM users WHERE id = ?", userID).Scan(&enabled)
+	ac.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&remaining)
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  enabled,
+		"required":                 MFARequiredRoles[c.GetString("role")],
+		"session_verified":         c.GetBool("mfa"),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// POST /users/2fa/enroll
+// Creates a new secret; 2FA is only switched on once a code from it is verified.
+func (ac *AuthController) EnrollMFA(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var enabled bool
+	ac.DB.QueryRow("SELECT IFNULL(totp_enabled, 0) FROM users WHERE id = ?", userID).Scan(&enabled)
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create secret"})
+		return
+	}
+	if _, err := ac.DB.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save secret"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, c.GetString("username")), // Render as a QR code
+		"message":     "Scan the QR code, then confirm with POST /users/2fa/verify",
+	})
+}
+
+// POST /users/2fa/verify
+// Confirms enrollment with a first code and hands out the recovery codes.
+func (ac *AuthController) VerifyMFA(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.GetString("user_id")
+
+	var secret sql.NullString
+	var enabled bool
+	ac.DB.QueryRow("SELECT totp_secret, IFNULL(totp_enabled, 0) FROM users WHERE id = ?", userID).Scan(&secret, &enabled)
+	if !secret.Valid || enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start with POST /users/2fa/enroll"})
+		return
+	}
+	if !ac.sessionSecondFactor(c, input.Code, "") {
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(ac.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create recovery codes"})
+		return
+	}
+	ac.DB.Exec("UPDATE users SET totp_enabled = 1 WHERE id = ?", userID)
+
+	// Sessions from before 2FA was on were not verified; make everyone log in again
+	revokeUserSessions(ac.DB, userID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled, please log in again",
+		"recovery_codes": codes, // Shown once
+	})
+}
+
+// POST /users/2fa/recovery-codes
+func (ac *AuthController) RegenerateRecoveryCodes(c *gin.Context) {
+	if !requireMFASession(c) {
+		return
+	}
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ac.sessionSecondFactor(c, input.Code, "") {
+		return
+	}
+	codes, err := replaceRecoveryCodes(ac.DB, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DELETE /users/2fa
+// Turns 2FA off, which needs a session that passed 2FA plus a valid code, and
+// is refused where policy requires 2FA.
+func (ac *AuthController) DisableMFA(c *gin.Context) {
+	if MFARequiredRoles[c.GetString("role")] {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Two-factor authentication is required for the %s role", c.GetString("role"))})
+		return
+	}
+	if !requireMFASession(c) {
+		return
+	}
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	c.ShouldBindJSON(&input)
+	if !ac.sessionSecondFactor(c, input.Code, input.RecoveryCode) {
+		return
+	}
+	userID := c.GetString("user_id")
+
+	ac.DB.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?", userID)
+	ac.DB.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
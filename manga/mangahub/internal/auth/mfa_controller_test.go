@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mfaTestServer runs the 2FA management routes for a user with 2FA on.
+// Requests act as the user in the X-Test-User header, with a session that
+// passed 2FA when mfa is set.
+func mfaTestServer(t *testing.T, mfa bool) (*gin.Engine, string) {
+	t.Helper()
+	db := testDB(t)
+	ac := &AuthController{DB: db}
+	user := addUser(t, db, "alice", "secret12", "alice@example.com")
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 1 WHERE id = ?", secret, user)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	session := func(c *gin.Context) {
+		c.Set("user_id", c.GetHeader("X-Test-User"))
+		c.Set("role", RoleUser)
+		c.Set("mfa", mfa)
+	}
+	r.POST("/users/2fa/recovery-codes", session, ac.RegenerateRecoveryCodes)
+	r.DELETE("/users/2fa", session, ac.DisableMFA)
+	return r, user
+}
+
+func TestMFAChangesNeedSecondFactorSession(t *testing.T) {
+	r, user := mfaTestServer(t, false)
+	if w := call(r, "DELETE", "/users/2fa", user, gin.H{"code": "123456"}); w.Code != http.StatusForbidden {
+		t.Fatalf("disable from a password-only session: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "POST", "/users/2fa/recovery-codes", user, gin.H{"code": "123456"}); w.Code != http.StatusForbidden {
+		t.Fatalf("new recovery codes from a password-only session: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestMFAChangesCountWrongCodes(t *testing.T) {
+	r, user := mfaTestServer(t, true)
+	for i := 0; i < FreeLoginAttempts; i++ {
+		if w := call(r, "DELETE", "/users/2fa", user, gin.H{"recovery_code": "wrong-code"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: %d %s", i+1, w.Code, w.Body)
+		}
+	}
+	if w := call(r, "POST", "/users/2fa/recovery-codes", user, gin.H{"code": "000000"}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("guess past the free attempts: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCProvider is one external identity provider, configured in the JSON file
+// named by MANGAHUB_OIDC_CONFIG (a list of these).
+type OIDCProvider struct {
+	Name         string   `json:"name"` // Used in the URLs: /auth/oidc/<name>/login
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"` // Empty for public clients (PKCE only)
+	RedirectURL  string   `json:"redirect_url"`  // Defaults to <BaseURL>/auth/oidc/<name>/callback
+	Scopes       []string `json:"scopes"`        // Defaults to openid, profile, email
+
+	// LinkByEmail signs a new identity into the existing account with the
+	// same verified email. Only turn it on for providers trusted to verify
+	// addresses: whoever controls the provider controls those accounts.
+	LinkByEmail bool `json:"link_by_email"`
+
+	// GroupsClaim names the ID token claim listing the user's groups, and
+	// GroupRoles maps group names to MangaHub roles. When set, the role of
+	// accounts this provider created is synced from the groups on every
+	// login; accounts that existed before keep their MangaHub role.
+	GroupsClaim string            `json:"groups_claim"`
+	GroupRoles  map[string]string `json:"group_roles"`
+	DefaultRole string            `json:"default_role"` // For users in no mapped group (default "user")
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      *JWKSClient
+}
+
+// oidcDiscovery is the part of /.well-known/openid-configuration we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// LoadOIDCProviders reads the provider list from a JSON file
+func LoadOIDCProviders(path string) (map[string]*OIDCProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*OIDCProvider
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	providers := make(map[string]*OIDCProvider, len(list))
+	for _, p := range list {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider needs name, issuer and client_id")
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "profile", "email"}
+		}
+		if p.DefaultRole == "" {
+			p.DefaultRole = RoleUser
+		}
+		for group, role := range p.GroupRoles {
+			if !ValidRole(role) {
+				return nil, fmt.Errorf("provider %s maps group %q to unknown role %q", p.Name, group, role)
+			}
+		}
+		providers[p.Name] = p
+	}
+	return providers, nil
+}
+
+// discover fetches the provider metadata once and keeps it
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery: %s", resp.Status)
+	}
+	var d oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("OIDC discovery: issuer %q does not match configured %q", d.Issuer, p.Issuer)
+	}
+
+	p.discovery = &d
+	p.keys = NewJWKSClient(d.JWKSURI)
+	return p.discovery, nil
+}
+
+// pkceChallenge is the S256 code_challenge for a code_verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the browser is sent to log in at the provider
+func (p *OIDCProvider) AuthCodeURL(redirectURL, state, nonce, verifier string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the verified ID token claims
+func (p *OIDCProvider) Exchange(code, redirectURL, verifier, nonce string) (jwt.MapClaims, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("token exchange failed: %s %s", body.Error, body.ErrorDescription)
+	}
+
+	// The ID token must come from this issuer, for us, and for this login attempt
+	claims, err := verifyJWT(body.IDToken, p.keys,
+		jwt.WithIssuer(p.Issuer), jwt.WithAudience(p.ClientID), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("invalid ID token: nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("invalid ID token: no subject")
+	}
+	return claims, nil
+}
+
+// RoleFor maps the groups in the ID token to a role. ok is false when the
+// provider does not manage roles, in which case the user's role is left alone.
+// A user in several mapped groups gets the highest of their roles.
+func (p *OIDCProvider) RoleFor(claims jwt.MapClaims) (string, bool) {
+	if p.GroupsClaim == "" || len(p.GroupRoles) == 0 {
+		return "", false
+	}
+	role := p.DefaultRole
+	groups, _ := claims[p.GroupsClaim].([]interface{})
+	for _, g := range groups {
+		name, _ := g.(string)
+		if mapped, ok := p.GroupRoles[name]; ok && RoleRank(mapped) > RoleRank(role) {
+			role = mapped
+		}
+	}
+	return role, true
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"mangahub/pkg/models"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCStateTTL is how long a user has to finish logging in at the provider
+const OIDCStateTTL = 10 * time.Minute
+
+// GET /auth/oidc/providers
+func (ac *AuthController) ListOIDCProviders(c *gin.Context) {
+	names := []string{}
+	for name := range ac.OIDC {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, gin.H{"providers": names})
+}
+
+func (ac *AuthController) oidcRedirectURL(p *OIDCProvider) string {
+	if p.RedirectURL != "" {
+		return p.RedirectURL
+	}
+	return ac.BaseURL + "/auth/oidc/" + p.Name + "/callback"
+}
+
+// GET /auth/oidc/:provider/login
+// Starts the authorization code flow with PKCE and redirects to the provider.
+func (ac *AuthController) OIDCLogin(c *gin.Context) {
+	p, ok := ac.OIDC[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	// state ties the callback to this request, nonce ties the ID token to it,
+	// and the verifier proves the code is redeemed by whoever started the flow
+	state, err1 := randomToken()
+	nonce, err2 := randomToken()
+	verifier, err3 := randomToken()
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start login"})
+		return
+	}
+	_, err := ac.DB.Exec("INSERT INTO oidc_states (state_hash, provider, nonce, verifier, created_at) VALUES (?, ?, ?, ?, ?)",
+		hashToken(state), p.Name, nonce, verifier, time.Now().Unix())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start login"})
+		return
+	}
+	ac.DB.Exec("DELETE FROM oidc_states WHERE created_at < ?", time.Now().Add(-OIDCStateTTL).Unix())
+
+	target, err := p.AuthCodeURL(ac.oidcRedirectURL(p), state, nonce, verifier)
+	if err != nil {
+		fmt.Println("❌ OIDC Error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// GET /auth/oidc/:provider/callback?code=&state=
+// Finishes the flow: verifies the ID token, finds or creates the linked user
+// and returns a MangaHub token pair like /auth/login does.
+func (ac *AuthController) OIDCCallback(c *gin.Context) {
+	p, ok := ac.OIDC[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login refused by provider: " + e})
+		return
+	}
+
+	// Each state works once
+	var nonce, verifier string
+	var createdAt int64
+	err := ac.DB.QueryRow("SELECT nonce, verifier, created_at FROM oidc_states WHERE state_hash = ? AND provider = ?",
+		hashToken(c.Query("state")), p.Name).Scan(&nonce, &verifier, &createdAt)
+	if err == nil {
+		res, _ := ac.DB.Exec("DELETE FROM oidc_states WHERE state_hash = ?", hashToken(c.Query("state")))
+		if n, _ := res.RowsAffected(); n == 0 {
+			err = sql.ErrNoRows
+		}
+	}
+	if err != nil || time.Since(time.Unix(createdAt, 0)) > OIDCStateTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login session expired or invalid, please start again"})
+		return
+	}
+
+	claims, err := p.Exchange(c.Query("code"), ac.oidcRedirectURL(p), verifier, nonce)
+	if err != nil {
+		fmt.Println("❌ OIDC Error:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Could not verify identity"})
+		return
+	}
+
+	user, err := ac.oidcUser(p, claims)
+	if err != nil {
+		fmt.Println("❌ OIDC Error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in"})
+		return
+	}
+	recordAttempt(ac.DB, user.Username, user.ID, c.ClientIP(), attemptSuccess)
+
+	// The provider decides how strong its login was; trust it when it says MFA
+	tokens, err := issueTokens(ac.DB, user, oidcUsedMFA(claims))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Could not generate token"})
+		return
+	}
+	c.JSON(200, tokens)
+}
+
+// oidcUser returns the MangaHub user for a verified identity: the linked one,
+// with LinkByEmail an existing account with the same verified email, or a
+// new account.
+func (ac *AuthController) oidcUser(p *OIDCProvider, claims jwt.MapClaims) (models.User, error) {
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	email = normalizeEmail(email)
+	emailVerified, _ := claims["email_verified"].(bool)
+	now := time.Now().Unix()
+
+	var userID string
+	var created bool
+	err := ac.DB.QueryRow("SELECT user_id, created_user FROM user_identities WHERE provider = ? AND subject = ?",
+		p.Name, subject).Scan(&userID, &created)
+
+	if err == sql.ErrNoRows && p.LinkByEmail && email != "" && emailVerified {
+		// Both sides vouch for the address, and the provider is trusted to
+		err = ac.DB.QueryRow("SELECT id FROM users WHERE email = ? AND email_verified = 1", email).Scan(&userID)
+	}
+	if err == sql.ErrNoRows {
+		userID, err = ac.createOIDCUser(p, claims, email, emailVerified)
+		created = true
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	_, err = ac.DB.Exec(`INSERT INTO user_identities (provider, subject, user_id, email, created_at, last_login_at, created_user)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(provider, subject) DO UPDATE SET email = excluded.email, last_login_at = excluded.last_login_at`,
+		p.Name, subject, userID, email, now, now, created)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The IdP is the source of truth for the roles of the accounts it made.
+	// Linked accounts keep theirs: a group change at the provider must not
+	// demote (or promote) a MangaHub admin behind their back.
+	if role, ok := p.RoleFor(claims); ok && created {
+		res, err := ac.DB.Exec("UPDATE users SET role = ? WHERE id = ? AND IFNULL(role, '') != ?", role, userID, role)
+		if err != nil {
+			return models.User{}, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			fmt.Printf("👤 Role of user %s set to %s by %s groups\n", userID, role, p.Name)
+		}
+	}
+
+	var user models.User
+	err = ac.DB.QueryRow("SELECT id, username, role FROM users WHERE id = ?", userID).
+		Scan(&user.ID, &user.Username, &user.Role)
+	return user, err
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// createOIDCUser makes an account without a password; it can only sign in
+// through the provider (or after a password reset)
+func (ac *AuthController) createOIDCUser(p *OIDCProvider, claims jwt.MapClaims, email string, emailVerified bool) (string, error) {
+	base, _ := claims["preferred_username"].(string)
+	if base == "" && email != "" {
+		base = strings.SplitN(email, "@", 2)[0]
+	}
+	base = usernameUnsafe.ReplaceAllString(base, "")
+	if base == "" {
+		base = p.Name + "-user"
+	}
+
+	role := RoleUser
+	if mapped, ok := p.RoleFor(claims); ok {
+		role = mapped
+	}
+	var emailValue interface{}
+	if email != "" && emailVerified {
+		var taken int
+		ac.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&taken)
+		if taken == 0 {
+			emailValue = email
+		}
+	}
+
+	// Find a free username: alice, alice-2, alice-3, ...
+	for i := 1; i <= 100; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s-%d", base, i)
+		}
+		res, err := ac.DB.Exec(`INSERT INTO users (username, password_hash, role, email, email_verified)
+			VALUES (?, '', ?, ?, ?)`, username, role, emailValue, emailValue != nil)
+		if isUniqueViolation(err, "users.username") {
+			continue
+		}
+		if isUniqueViolation(err, "users.email") {
+			// Taken since we looked: create the account without it
+			emailValue = nil
+			i--
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		id, _ := res.LastInsertId()
+		fmt.Printf("👤 Created user %s from %s login\n", username, p.Name)
+		return fmt.Sprint(id), nil
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
+
+// oidcUsedMFA reads the ID token's "amr" (authentication methods) claim
+func oidcUsedMFA(claims jwt.MapClaims) bool {
+	methods, _ := claims["amr"].([]interface{})
+	for _, m := range methods {
+		switch m {
+		case "mfa", "otp", "hwk", "swk":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"mangahub/internal/oidcdev"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcTest runs a gateway with one provider, "dev", backed by the stand-in
+// identity provider
+type oidcTest struct {
+	t        *testing.T
+	db       *sql.DB
+	gateway  *httptest.Server
+	provider *OIDCProvider
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	db := testDB(t)
+	keys, err := NewKeyManager(db, AlgEdDSA, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeys := Keys
+	Keys = keys
+	t.Cleanup(func() { Keys = oldKeys })
+
+	idp, err := oidcdev.New("", "mangahub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idpServer := httptest.NewServer(idp)
+	t.Cleanup(idpServer.Close)
+	idp.Issuer = idpServer.URL
+
+	provider := &OIDCProvider{
+		Name: "dev", Issuer: idpServer.URL, ClientID: "mangahub", Scopes: []string{"openid"},
+		GroupsClaim: "groups", GroupRoles: map[string]string{"mods": RoleModerator}, DefaultRole: RoleUser,
+	}
+	ac := &AuthController{DB: db, OIDC: map[string]*OIDCProvider{"dev": provider}}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/auth/oidc/:provider/login", ac.OIDCLogin)
+	r.GET("/auth/oidc/:provider/callback", ac.OIDCCallback)
+	gateway := httptest.NewServer(r)
+	t.Cleanup(gateway.Close)
+	ac.BaseURL = gateway.URL
+
+	return &oidcTest{t: t, db: db, gateway: gateway, provider: provider}
+}
+
+// login runs the whole code flow as username in groups and returns the
+// callback's response
+func (o *oidcTest) login(username, groups string) *http.Response {
+	o.t.Helper()
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	// The gateway sends the browser to the provider with state, nonce and the PKCE challenge
+	resp, err := noFollow.Get(o.gateway.URL + "/auth/oidc/dev/login")
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	resp.Body.Close()
+	authorize, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		o.t.Fatalf("login: %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	q := authorize.Query()
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		o.t.Fatalf("no PKCE challenge in %s", authorize)
+	}
+
+	// The provider signs the user in (login_hint skips its form) and redirects to the callback
+	q.Set("login_hint", username)
+	q.Set("groups", groups)
+	authorize.RawQuery = q.Encode()
+	resp, err = noFollow.Get(authorize.String())
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound {
+		o.t.Fatalf("authorize: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(callback)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	o.t.Cleanup(func() { resp.Body.Close() })
+
+	// A callback works once
+	if replay, err := http.Get(callback); err != nil || replay.StatusCode != http.StatusBadRequest {
+		o.t.Fatalf("replayed callback: %v %v", replay.StatusCode, err)
+	}
+	return resp
+}
+
+// signIn logs in and returns the account the identity belongs to
+func (o *oidcTest) signIn(username, groups string) (id, name, role string) {
+	o.t.Helper()
+	resp := o.login(username, groups)
+	var tokens TokenPair
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || resp.StatusCode != http.StatusOK || tokens.AccessToken == "" {
+		o.t.Fatalf("callback: %d %v", resp.StatusCode, err)
+	}
+	err := o.db.QueryRow(`SELECT u.id, u.username, u.role FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider = 'dev' AND i.subject = ?`, "dev|"+username).Scan(&id, &name, &role)
+	if err != nil {
+		o.t.Fatalf("no linked account for %s: %v", username, err)
+	}
+	return id, name, role
+}
+
+func TestOIDCCodeFlowCreatesAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	id, name, role := o.signIn("carol", "mods")
+	if name != "carol" || role != RoleModerator {
+		t.Fatalf("created %s with role %s", name, role)
+	}
+
+	// The provider manages the roles of accounts it created
+	again, _, role := o.signIn("carol", "")
+	if again != id || role != RoleUser {
+		t.Fatalf("second login: account %s (was %s), role %s", again, id, role)
+	}
+}
+
+func TestOIDCDoesNotLinkByEmailUnlessEnabled(t *testing.T) {
+	o := newOIDCTest(t)
+	admin := addUser(t, o.db, "dave", "secret12", "dave@dev-idp.local")
+	o.db.Exec("UPDATE users SET role = ? WHERE id = ?", RoleAdmin, admin)
+
+	// Not linked: a new account without the address, which is taken
+	id, name, role := o.signIn("dave", "")
+	if id == admin || name != "dave-2" || role != RoleUser {
+		t.Fatalf("signed into %s (%s, %s), want a new account", id, name, role)
+	}
+}
+
+func TestOIDCLinkedAccountKeepsItsRole(t *testing.T) {
+	o := newOIDCTest(t)
+	o.provider.LinkByEmail = true
+	admin := addUser(t, o.db, "erin", "secret12", "erin@dev-idp.local")
+	o.db.Exec("UPDATE users SET role = ? WHERE id = ?", RoleAdmin, admin)
+
+	id, _, role := o.signIn("erin", "")
+	if id != admin || role != RoleAdmin {
+		t.Fatalf("signed into %s with role %s, want %s as admin", id, role, admin)
+	}
+}
+
+func TestOIDCRoleForPicksHighestKnownRole(t *testing.T) {
+	p := &OIDCProvider{GroupsClaim: "groups", DefaultRole: RoleUser, GroupRoles: map[string]string{
+		"mods": RoleModerator, "admins": RoleAdmin, "typo": "adminn",
+	}}
+	for _, tc := range []struct {
+		groups []interface{}
+		want   string
+	}{
+		{nil, RoleUser},
+		{[]interface{}{"mods"}, RoleModerator},
+		{[]interface{}{"admins", "mods"}, RoleAdmin},
+		{[]interface{}{"mods", "admins"}, RoleAdmin},
+		{[]interface{}{"typo"}, RoleUser}, // Unknown roles rank below every known one
+		{[]interface{}{"typo", "mods"}, RoleModerator},
+	} {
+		if role, _ := p.RoleFor(map[string]interface{}{"groups": tc.groups}); role != tc.want {
+			t.Errorf("groups %v: role %s, want %s", tc.groups, role, tc.want)
+		}
+	}
+}
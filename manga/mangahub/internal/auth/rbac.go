@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+// Permissions guarding the API. Keep them coarse: one per kind of action.
+const (
+	PermMangaRead     Permission = "manga:read"
+	PermMangaWrite    Permission = "manga:write"
+	PermProgressWrite Permission = "progress:write"
+	PermChatModerate  Permission = "chat:moderate"
+	PermUsersManage   Permission = "users:manage"
+	PermStatsRead     Permission = "stats:read"
+
+	// PermBackplane lets API gateways exchange chat events through the gRPC
+	// server. No role grants it; only the service token can.
+	PermBackplane Permission = "internal:backplane"
+)
+
+// Roles stored in users.role
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// RolePermissions is the permission model. Each role includes everything the
+// role before it can do.
+var RolePermissions = map[string][]Permission{
+	RoleUser:      {PermMangaRead, PermProgressWrite},
+	RoleModerator: {PermMangaRead, PermProgressWrite, PermChatModerate},
+	RoleAdmin: {PermMangaRead, PermProgressWrite, PermMangaWrite, PermChatModerate,
+		PermUsersManage, PermStatsRead},
+}
+
+// roleOrder ranks the roles, lowest first
+var roleOrder = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// RoleRank places a role in the hierarchy: higher ranks can do more.
+// Unknown roles rank below every known one.
+func RoleRank(role string) int {
+	for i, r := range roleOrder {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// HasPermission reports whether the role grants perm. Unknown roles grant nothing.
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range RolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole reports whether role is part of the permission model
+func ValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// Roles lists the known role names, sorted
+func Roles() []string {
+	roles := make([]string, 0, len(RolePermissions))
+	for r := range RolePermissions {
+		roles = append(roles, r)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// RequirePermission only lets the request through if the caller's role grants
+// every listed permission (and, for API keys, the key's scopes include them).
+// It must run after AuthRequired; on routes open to API keys, give the
+// permissions to AuthRequired instead.
+func RequirePermission(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if status, msg := checkPermissions(c, perms); status != 0 {
+			c.AbortWithStatusJSON(status, gin.H{"error": msg})
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkPermissions returns the status and error to answer with when the
+// authenticated caller lacks one of perms, or 0 when they have them all
+func checkPermissions(c *gin.Context, perms []Permission) (int, string) {
+	if len(perms) == 0 {
+		return 0, ""
+	}
+	role := c.GetString("role")
+	if role == "" {
+		return 401, "Authentication required"
+	}
+	for _, p := range perms {
+		if !HasPermission(role, p) {
+			return 403, "Missing permission: " + string(p)
+		}
+		if scopes, ok := c.Get("scopes"); ok && !hasScope(scopes.([]Permission), p) {
+			return 403, "API key lacks scope: " + string(p)
+		}
+	}
+	if !mfaSatisfied(c) {
+		return 403, "Your role requires two-factor authentication: enroll at /users/2fa, then log in again"
+	}
+	return 0, ""
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mangahub/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testCredentials sets up signing keys and API keys on a fresh database, and
+// hands out access tokens and API keys for users of any role
+type testCredentials struct {
+	t  *testing.T
+	db *sql.DB
+}
+
+func newCredentials(t *testing.T) *testCredentials {
+	t.Helper()
+	db := testDB(t)
+	keys, err := NewKeyManager(db, AlgEdDSA, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeys, oldVerifier, oldAPIKeys := Keys, Verifier, APIKeys
+	Keys, Verifier, APIKeys = keys, keys, &APIKeyStore{DB: db}
+	t.Cleanup(func() { Keys, Verifier, APIKeys = oldKeys, oldVerifier, oldAPIKeys })
+	return &testCredentials{t: t, db: db}
+}
+
+// user adds a user with the role and returns their ID
+func (cr *testCredentials) user(role string) string {
+	cr.t.Helper()
+	name := role + "-" + randomName(cr.t)
+	id := addUser(cr.t, cr.db, name, "secret12", name+"@example.com")
+	cr.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	return id
+}
+
+// token returns an access token for a new user with the role
+func (cr *testCredentials) token(role string, mfa bool) string {
+	cr.t.Helper()
+	token, _, _, err := signAccessToken(models.User{ID: cr.user(role), Username: role, Role: role}, mfa)
+	if err != nil {
+		cr.t.Fatal(err)
+	}
+	return token
+}
+
+// apiKey returns an API key for a new user with the role, limited to scopes
+func (cr *testCredentials) apiKey(role string, mfa bool, scopes ...Permission) string {
+	cr.t.Helper()
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		cr.t.Fatal(err)
+	}
+	scopesJSON, _ := json.Marshal(scopes)
+	_, err = cr.db.Exec(`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at, mfa)
+		VALUES (?, 'test', ?, ?, ?, ?, ?, ?)`,
+		cr.user(role), prefix, hashToken(key), string(scopesJSON), time.Now().Unix(), time.Now().Add(time.Hour).Unix(), mfa)
+	if err != nil {
+		cr.t.Fatal(err)
+	}
+	return key
+}
+
+func randomName(t *testing.T) string {
+	t.Helper()
+	name, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name[:8]
+}
+
+func TestRolePermissions(t *testing.T) {
+	all := []Permission{PermMangaRead, PermMangaWrite, PermProgressWrite, PermChatModerate,
+		PermUsersManage, PermStatsRead, PermBackplane}
+	granted := map[string][]Permission{
+		RoleUser:      {PermMangaRead, PermProgressWrite},
+		RoleModerator: {PermMangaRead, PermProgressWrite, PermChatModerate},
+		RoleAdmin:     {PermMangaRead, PermMangaWrite, PermProgressWrite, PermChatModerate, PermUsersManage, PermStatsRead},
+		"":            nil,
+		"superuser":   nil,
+	}
+	for role, perms := range granted {
+		for _, perm := range all {
+			if got, want := HasPermission(role, perm), hasScope(perms, perm); got != want {
+				t.Errorf("HasPermission(%q, %s) = %v, want %v", role, perm, got, want)
+			}
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	cr := newCredentials(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/manga", AuthRequired(PermMangaRead), ok)
+	r.POST("/manga", AuthRequired(PermMangaWrite), ok)
+	r.POST("/chat/mute", AuthRequired(PermChatModerate), ok)
+	r.GET("/account", AuthRequired(), ok) // Declares no permission
+	r.GET("/stats", AuthRequired(), RequirePermission(PermMangaRead), ok)
+
+	bearer := func(token string) map[string]string { return map[string]string{"Authorization": "Bearer " + token} }
+	apiKey := func(key string) map[string]string { return map[string]string{HeaderAPIKey: key} }
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"no credentials", "GET", "/manga", nil, http.StatusUnauthorized},
+		{"user reads", "GET", "/manga", bearer(cr.token(RoleUser, false)), http.StatusOK},
+		{"user writes", "POST", "/manga", bearer(cr.token(RoleUser, false)), http.StatusForbidden},
+		{"user moderates", "POST", "/chat/mute", bearer(cr.token(RoleUser, false)), http.StatusForbidden},
+		{"moderator moderates", "POST", "/chat/mute", bearer(cr.token(RoleModerator, false)), http.StatusOK},
+		{"moderator writes", "POST", "/manga", bearer(cr.token(RoleModerator, false)), http.StatusForbidden},
+		{"admin without 2FA writes", "POST", "/manga", bearer(cr.token(RoleAdmin, false)), http.StatusForbidden},
+		{"admin with 2FA writes", "POST", "/manga", bearer(cr.token(RoleAdmin, true)), http.StatusOK},
+		{"user on unscoped route", "GET", "/account", bearer(cr.token(RoleUser, false)), http.StatusOK},
+
+		{"key with scope", "GET", "/manga", apiKey(cr.apiKey(RoleUser, false, PermMangaRead)), http.StatusOK},
+		{"key without scope", "GET", "/manga", apiKey(cr.apiKey(RoleUser, false, PermProgressWrite)), http.StatusForbidden},
+		{"key scope beyond role", "POST", "/manga", apiKey(cr.apiKey(RoleUser, false, PermMangaWrite)), http.StatusForbidden},
+		{"admin key without 2FA", "POST", "/manga", apiKey(cr.apiKey(RoleAdmin, false, PermMangaWrite)), http.StatusForbidden},
+		{"admin key with 2FA", "POST", "/manga", apiKey(cr.apiKey(RoleAdmin, true, PermMangaWrite)), http.StatusOK},
+		{"key on unscoped route", "GET", "/account", apiKey(cr.apiKey(RoleUser, false, PermMangaRead)), http.StatusForbidden},
+		{"key past a bare AuthRequired", "GET", "/stats", apiKey(cr.apiKey(RoleUser, false, PermMangaRead)), http.StatusForbidden},
+		{"user past a bare AuthRequired", "GET", "/stats", bearer(cr.token(RoleUser, false)), http.StatusOK},
+		{"unknown key", "GET", "/manga", apiKey("mh_nope"), http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: %d %s, want %d", tc.name, w.Code, w.Body, tc.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /admin/roles
+// Lists every role with the permissions it grants.
+func (ac *AuthController) ListRoles(c *gin.Context) {
+	c.JSON(http.StatusOK, RolePermissions)
+}
+
+// GET /admin/users
+func (ac *AuthController) ListUsers(c *gin.Context) {
+	rows, err := ac.DB.Query("SELECT id, username, IFNULL(role, '') FROM users ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	defer rows.Close()
+
+	users := []gin.H{}
+	for rows.Next() {
+		var id, username, role string
+		if err := rows.Scan(&id, &username, &role); err == nil {
+			users = append(users, gin.H{"id": id, "username": username, "role": role})
+		}
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// PUT /admin/users/:id/role
+// Changes a user's role and logs them out everywhere, so the new role applies
+// from their next login instead of when their current tokens expire.
+func (ac *AuthController) AssignRole(c *gin.Context) {
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": Roles()})
+		return
+	}
+
+	userID := c.Param("id")
+	if userID == c.GetString("user_id") && !HasPermission(input.Role, PermUsersManage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove your own users:manage permission"})
+		return
+	}
+
+	res, err := ac.DB.Exec("UPDATE users SET role = ? WHERE id = ?", input.Role, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := revokeUserSessions(ac.DB, userID); err != nil {
+		fmt.Printf("⚠️ Could not revoke sessions of user %s: %v\n", userID, err)
+	}
+	fmt.Printf("🛡️ %s set role of user %s to %s\n", c.GetString("username"), userID, input.Role)
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "role": input.Role})
+}
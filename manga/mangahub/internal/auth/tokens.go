@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mangahub/pkg/models"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Token lifetimes
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// TokenPair is what Login and Refresh return to the client
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// RevocationList holds the jti of access tokens that were revoked before they
+// expired. Lookups are in memory first; the DB copy survives restarts and is
+// shared with the other processes that check tokens (other gateways, the
+// gRPC and TCP servers), so tokens not in memory are looked up there.
+type RevocationList struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time // jti -> token expiry
+	db      *sql.DB
+}
+
+// Revocations is checked by ParseToken (and so by AuthRequired) on every request
+var Revocations = &RevocationList{revoked: make(map[string]time.Time)}
+
+// Load reads still-relevant revocations from the DB and keeps writing new ones there
+func (rl *RevocationList) Load(db *sql.DB) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.db = db
+
+	if _, err := db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().Unix()); err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT jti, expires_at FROM revoked_tokens")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jti string
+		var exp int64
+		if err := rows.Scan(&jti, &exp); err != nil {
+			return err
+		}
+		rl.revoked[jti] = time.Unix(exp, 0)
+	}
+	return rows.Err()
+}
+
+// Revoke blocks an access token until it would have expired anyway
+func (rl *RevocationList) Revoke(jti string, expiresAt time.Time) {
+	if jti == "" {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.revoked[jti] = expiresAt
+	if rl.db != nil {
+		rl.db.Exec("INSERT OR REPLACE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)",
+			jti, expiresAt.Unix())
+	}
+}
+
+// IsRevoked reports whether the token was revoked, by this process or any
+// other sharing the DB. If the DB cannot be read the token counts as revoked.
+func (rl *RevocationList) IsRevoked(jti string) bool {
+	rl.mu.RLock()
+	_, ok := rl.revoked[jti]
+	db := rl.db
+	rl.mu.RUnlock()
+	if ok || db == nil {
+		return ok
+	}
+
+	var exp int64
+	err := db.QueryRow("SELECT expires_at FROM revoked_tokens WHERE jti = ?", jti).Scan(&exp)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		log.Printf("⚠️ Could not check token revocation: %v", err)
+		return true
+	}
+	rl.mu.Lock()
+	rl.revoked[jti] = time.Unix(exp, 0)
+	rl.mu.Unlock()
+	return true
+}
+
+// Prune forgets revocations of tokens that have expired on their own.
+// It blocks, so start it with `go`.
+func (rl *RevocationList) Prune(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		rl.mu.Lock()
+		for jti, exp := range rl.revoked {
+			if now.After(exp) {
+				delete(rl.revoked, jti)
+			}
+		}
+		if rl.db != nil {
+			rl.db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", now.Unix())
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// hashToken is how refresh tokens are stored: only the SHA-256, never the token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// signAccessToken creates a short-lived JWT with a unique jti. mfa records
+// whether the login included a second factor.
+func signAccessToken(user models.User, mfa bool) (string, string, time.Time, error) {
+	jti := uuid.NewString()
+	expiresAt := time.Now().Add(AccessTokenTTL)
+
+	if Keys == nil {
+		return "", "", time.Time{}, fmt.Errorf("no signing keys configured")
+	}
+	signed, err := Keys.Sign(jwt.MapClaims{
+		"user_id":  fmt.Sprint(user.ID),
+		"username": user.Username,
+		"role":     user.Role,
+		"jti":      jti,
+		"mfa":      mfa,
+		"iat":      time.Now().Unix(),
+		"exp":      expiresAt.Unix(),
+	})
+	return signed, jti, expiresAt, err
+}
+
+// issueTokens creates an access token plus a refresh token. Pass the familyID
+// when rotating; leave it out to start a new family (a new login). Refreshed
+// tokens keep the mfa flag of the login that started the family.
+func issueTokens(db *sql.DB, user models.User, mfa bool, familyID ...string) (TokenPair, error) {
+	access, jti, _, err := signAccessToken(user, mfa)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	family := uuid.NewString()
+	if len(familyID) > 0 {
+		family = familyID[0]
+	}
+
+	now := time.Now()
+	_, err = db.Exec(`INSERT INTO refresh_tokens
+		(user_id, family_id, token_hash, access_jti, mfa, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		fmt.Sprint(user.ID), family, hashToken(refresh), jti, mfa,
+		now.Add(RefreshTokenTTL).Unix(), now.Unix())
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeFamily ends a login session: every refresh token of the family stops
+// working and the access tokens issued with them are put on the revocation list
+func revokeFamily(db *sql.DB, familyID string) error {
+	now := time.Now()
+	rows, err := db.Query("SELECT access_jti, created_at FROM refresh_tokens WHERE family_id = ?", familyID)
+	if err != nil {
+		return err
+	}
+	type issued struct {
+		jti       string
+		createdAt int64
+	}
+	var tokens []issued
+	for rows.Next() {
+		var t issued
+		var jti sql.NullString
+		if err := rows.Scan(&jti, &t.createdAt); err == nil && jti.Valid {
+			t.jti = jti.String
+			tokens = append(tokens, t)
+		}
+	}
+	rows.Close()
+
+	for _, t := range tokens {
+		expiresAt := time.Unix(t.createdAt, 0).Add(AccessTokenTTL)
+		if expiresAt.After(now) {
+			Revocations.Revoke(t.jti, expiresAt)
+		}
+	}
+
+	_, err = db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL",
+		now.Unix(), familyID)
+	return err
+}
+
+// revokeUserSessions logs a user out everywhere, e.g. after their role changed
+// so that no token keeps carrying the old role
+func revokeUserSessions(db *sql.DB, userID string) error {
+	rows, err := db.Query("SELECT DISTINCT family_id FROM refresh_tokens WHERE user_id = ? AND revoked_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+	var families []string
+	for rows.Next() {
+		var f string
+		if rows.Scan(&f) == nil {
+			families = append(families, f)
+		}
+	}
+	rows.Close()
+
+	for _, f := range families {
+		if err := revokeFamily(db, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Package backplane carries chat, presence and notification events between
+// API gateway instances, so users on different instances still share rooms
+// and inboxes.
+package backplane
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// Topics the gateways publish on
+const (
+	TopicChat         = "chat"         // socket.Envelope for a room
+	TopicPresence     = "presence"     // Who is in which room on one instance
+	TopicDirect       = "direct"       // socket.Envelope for some users' connections
+	TopicModeration   = "moderation"   // socket.Moderation to apply everywhere
+	TopicNotification = "notification" // notification.Event for one user
+)
+
+// SubscriberBuffer is how many messages may wait for a subscriber before
+// new ones are dropped
+const SubscriberBuffer = 1024
+
+// Message is one event on the backplane
+type Message struct {
+	Topic  string          `json:"topic"`
+	Origin string          `json:"origin"` // Instance that published it; it ignores its own messages
+	Data   json.RawMessage `json:"data"`
+}
+
+// NewMessage encodes v as the payload of a message
+func NewMessage(topic, origin string, v interface{}) (Message, error) {
+	data, err := json.Marshal(v)
+	return Message{Topic: topic, Origin: origin, Data: data}, err
+}
+
+// Backplane is a pub/sub bus shared by all gateway instances. Publish must
+// not block for long; Subscribe is called before publishing starts.
+type Backplane interface {
+	Publish(msg Message) error
+	Subscribe(topic string) <-chan Message
+	Close() error
+}
+
+// fanout hands messages to local subscribers; both implementations use it
+type fanout struct {
+	mu   sync.RWMutex
+	subs map[string][]chan Message
+}
+
+func (f *fanout) Subscribe(topic string) <-chan Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[string][]chan Message)
+	}
+	ch := make(chan Message, SubscriberBuffer)
+	f.subs[topic] = append(f.subs[topic], ch)
+	return ch
+}
+
+// dispatch never blocks: a subscriber that has fallen this far behind loses
+// the message rather than stalling the bus
+func (f *fanout) dispatch(msg Message) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, ch := range f.subs[msg.Topic] {
+		select {
+		case ch <- msg:
+		default:
+			log.Printf("⚠️ Backplane subscriber for %q is full, dropping a message", msg.Topic)
+		}
+	}
+}
+
+func (f *fanout) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, list := range f.subs {
+		for _, ch := range list {
+			close(ch)
+		}
+	}
+	f.subs = nil
+}
+
+// Local is the in-process backplane: for a single gateway, or several hubs
+// in one process
+type Local struct {
+	fanout
+}
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+func (l *Local) Publish(msg Message) error {
+	l.dispatch(msg)
+	return nil
+}
+
+func (l *Local) Close() error {
+	l.closeAll()
+	return nil
+}
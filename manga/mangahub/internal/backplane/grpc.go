@@ -0,0 +1,182 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mangahub/proto"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// OutboxSize is how many messages a gateway buffers while the broker is unreachable
+const OutboxSize = 1024
+
+// ErrOutboxFull is returned by Publish when the broker has been unreachable
+// for too long to queue more
+var ErrOutboxFull = errors.New("backplane: outbox full")
+
+// Broker relays every message from one gateway to all the others. It runs
+// inside the gRPC server as the Backplane service.
+type Broker struct {
+	proto.UnimplementedBackplaneServer
+	mu    sync.Mutex
+	peers map[chan *proto.BackplaneMessage]bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{peers: make(map[chan *proto.BackplaneMessage]bool)}
+}
+
+// RegisterBroker adds the broker service to a gRPC server
+func RegisterBroker(s *grpc.Server, b *Broker) {
+	proto.RegisterBackplaneServer(s, b)
+}
+
+// Connect is one gateway's stream
+func (b *Broker) Connect(stream proto.Backplane_ConnectServer) error {
+	out := make(chan *proto.BackplaneMessage, OutboxSize)
+	b.mu.Lock()
+	b.peers[out] = true
+	log.Printf("🔗 Backplane peer connected (%d total)", len(b.peers))
+	b.mu.Unlock()
+
+	// Only this goroutine sends on the stream
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		for msg := range out {
+			if err == nil {
+				err = stream.Send(msg)
+			}
+		}
+	}()
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		b.mu.Lock()
+		for peer := range b.peers {
+			if peer == out {
+				continue
+			}
+			select {
+			case peer <- msg:
+			default:
+				log.Println("⚠️ Backplane peer is not keeping up, dropping a message")
+			}
+		}
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	delete(b.peers, out)
+	close(out)
+	log.Printf("🔌 Backplane peer disconnected (%d left)", len(b.peers))
+	b.mu.Unlock()
+	<-done
+	return nil
+}
+
+// GRPC is the backplane client used by gateways. It keeps one stream open to
+// the broker and reconnects with backoff when it drops.
+type GRPC struct {
+	fanout
+	conn   *grpc.ClientConn
+	outbox chan Message
+	cancel context.CancelFunc
+}
+
+// DialGRPC starts a backplane over an existing connection to the gRPC server.
+// The connection's credentials must carry the service token.
+func DialGRPC(conn *grpc.ClientConn) *GRPC {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &GRPC{conn: conn, outbox: make(chan Message, OutboxSize), cancel: cancel}
+	go g.run(ctx)
+	return g
+}
+
+func (g *GRPC) Publish(msg Message) error {
+	select {
+	case g.outbox <- msg:
+		return nil
+	default:
+		return ErrOutboxFull
+	}
+}
+
+func (g *GRPC) Close() error {
+	g.cancel()
+	g.closeAll()
+	return nil
+}
+
+func (g *GRPC) run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := g.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second // It was up for a while; retry quickly
+		}
+		log.Printf("⚠️ Backplane connection lost (%v), retrying in %v", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// session runs one stream until it fails
+func (g *GRPC) session(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := proto.NewBackplaneClient(g.conn).Connect(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	log.Println("🔗 Backplane connected to broker")
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			if !json.Valid(frame.Data) {
+				log.Printf("⚠️ Backplane: bad %s message from %s", frame.Topic, frame.Origin)
+				continue
+			}
+			g.dispatch(Message{Topic: frame.Topic, Origin: frame.Origin, Data: frame.Data})
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-g.outbox:
+			frame := &proto.BackplaneMessage{Topic: msg.Topic, Origin: msg.Origin, Data: msg.Data}
+			if err := stream.Send(frame); err != nil {
+				return err // This message is lost with the stream
+			}
+		case err := <-recvErr:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
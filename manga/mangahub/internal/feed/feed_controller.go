@@ -0,0 +1,298 @@
+package feed
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxEntries caps how many items a single feed document carries
+const maxEntries = 50
+
+type FeedController struct {
+	DB      *sql.DB
+	BaseURL string // Public address of the gateway, used for links (e.g. http://localhost:8080)
+}
+
+// GET /feeds/manga?format=atom|rss
+func (fc *FeedController) NewManga(c *gin.Context) {
+	rows, err := fc.DB.Query(`
+		SELECT id, title, author, genres, description, created_at
+		FROM manga
+		WHERE created_at > 0
+		ORDER BY created_at DESC
+		LIMIT ?`, maxEntries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+		return
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var id, title string
+		var author, genres, description sql.NullString
+		var createdAt int64
+		if err := rows.Scan(&id, &title, &author, &genres, &description, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+			return
+		}
+		entries = append(entries, Entry{
+			ID:         "urn:mangahub:manga:" + id,
+			Title:      "New manga: " + title,
+			Link:       fc.BaseURL + "/manga/" + id,
+			Summary:    description.String,
+			Author:     author.String,
+			Categories: parseGenres(genres.String),
+			Updated:    time.Unix(createdAt, 0),
+		})
+	}
+
+	fc.serve(c, Feed{
+		ID:    "urn:mangahub:feeds:manga",
+		Title: "MangaHub - New Manga",
+		Link:  fc.BaseURL + "/",
+	}, entries)
+}
+
+// GET /feeds/manga/:id/chapters?format=atom|rss
+func (fc *FeedController) MangaChapters(c *gin.Context) {
+	id := c.Param("id")
+
+	var title string
+	err := fc.DB.QueryRow("SELECT title FROM manga WHERE id = ?", id).Scan(&title)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Manga not found"})
+		return
+	}
+
+	entries, err := fc.chapterEntries("WHERE c.manga_id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+		return
+	}
+
+	fc.serve(c, Feed{
+		ID:    "urn:mangahub:feeds:manga:" + id + ":chapters",
+		Title: "MangaHub - New chapters of " + title,
+		Link:  fc.BaseURL + "/manga/" + id,
+	}, entries)
+}
+
+// GET /feeds/genres/:genre/chapters?format=atom|rss
+func (fc *FeedController) GenreChapters(c *gin.Context) {
+	genre := c.Param("genre")
+
+	// Genres are stored as a JSON array of strings, e.g. ["Shounen","Action"],
+	// so the genre is looked for as a quoted JSON string
+	quoted, _ := json.Marshal(strings.ToLower(genre))
+	pattern := "%" + likeEscaper.Replace(string(quoted)) + "%"
+	entries, err := fc.chapterEntries(`WHERE LOWER(m.genres) LIKE ? ESCAPE '\'`, pattern)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+		return
+	}
+
+	fc.serve(c, Feed{
+		ID:    "urn:mangahub:feeds:genre:" + strings.ToLower(genre) + ":chapters",
+		Title: "MangaHub - New " + genre + " chapters",
+		Link:  fc.BaseURL + "/",
+	}, entries)
+}
+
+// GET /feeds/library/:token?format=atom|rss
+// Private feed of new chapters for everything in the token owner's library.
+func (fc *FeedController) Library(c *gin.Context) {
+	var userID string
+	err := fc.DB.QueryRow("SELECT user_id FROM feed_tokens WHERE token = ?", c.Param("token")).Scan(&userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown feed"})
+		return
+	}
+
+	entries, err := fc.chapterEntries(
+		"WHERE c.manga_id IN (SELECT manga_id FROM user_progress WHERE user_id = ?)", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+		return
+	}
+
+	// Personal feeds must not be stored by shared caches
+	c.Header("Cache-Control", "private, no-cache")
+	fc.serve(c, Feed{
+		ID:    "urn:mangahub:feeds:library:" + userID,
+		Title: "MangaHub - Updates in your library",
+		Link:  fc.BaseURL + "/",
+	}, entries)
+}
+
+// GET /users/feed-token
+// Returns the caller's private library feed URL, creating the token on first use.
+func (fc *FeedController) GetFeedToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var token string
+	err := fc.DB.QueryRow("SELECT token FROM feed_tokens WHERE user_id = ?", userID).Scan(&token)
+	if err == sql.ErrNoRows {
+		fc.issueFeedToken(c, fmt.Sprintf("%v", userID))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "url": fc.libraryURL(token)})
+}
+
+// POST /users/feed-token
+// Replaces the caller's token, invalidating any previously shared feed URL.
+func (fc *FeedController) RotateFeedToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	fc.issueFeedToken(c, fmt.Sprintf("%v", userID))
+}
+
+func (fc *FeedController) issueFeedToken(c *gin.Context, userID string) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+	token := hex.EncodeToString(buf)
+
+	_, err := fc.DB.Exec(`
+		INSERT INTO feed_tokens (token, user_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET token=excluded.token, created_at=excluded.created_at`,
+		token, userID, time.Now().Unix())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feed token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "url": fc.libraryURL(token)})
+}
+
+func (fc *FeedController) libraryURL(token string) string {
+	return fc.BaseURL + "/feeds/library/" + token
+}
+
+// chapterEntries loads the newest chapters matching the given WHERE clause
+func (fc *FeedController) chapterEntries(where string, args ...interface{}) ([]Entry, error) {
+	rows, err := fc.DB.Query(`
+		SELECT c.manga_id, c.number, c.title, c.created_at, m.title, m.author, m.genres
+		FROM chapters c
+		JOIN manga m ON m.id = c.manga_id
+		`+where+`
+		ORDER BY c.created_at DESC, c.id DESC
+		LIMIT ?`, append(args, maxEntries)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var mangaID, mangaTitle string
+		var number int
+		var chapterTitle, author, genres sql.NullString
+		var createdAt int64
+		if err := rows.Scan(&mangaID, &number, &chapterTitle, &createdAt, &mangaTitle, &author, &genres); err != nil {
+			return nil, err
+		}
+
+		title := fmt.Sprintf("%s - Chapter %d", mangaTitle, number)
+		if chapterTitle.String != "" {
+			title += ": " + chapterTitle.String
+		}
+		entries = append(entries, Entry{
+			ID:         fmt.Sprintf("urn:mangahub:manga:%s:chapter:%d", mangaID, number),
+			Title:      title,
+			Link:       fc.BaseURL + "/manga/" + mangaID,
+			Author:     author.String,
+			Categories: parseGenres(genres.String),
+			Updated:    time.Unix(createdAt, 0),
+		})
+	}
+	return entries, rows.Err()
+}
+
+// serve renders the feed in the requested format and answers conditional requests
+func (fc *FeedController) serve(c *gin.Context, f Feed, entries []Entry) {
+	f.Entries = entries
+	f.Updated = time.Unix(0, 0)
+	for _, e := range entries {
+		if e.Updated.After(f.Updated) {
+			f.Updated = e.Updated
+		}
+	}
+
+	var body []byte
+	var contentType string
+	var err error
+	switch c.DefaultQuery("format", "atom") {
+	case "atom":
+		self := fc.BaseURL + c.Request.URL.RequestURI()
+		body, err = RenderAtom(f, self)
+		contentType = "application/atom+xml; charset=utf-8"
+	case "rss":
+		body, err = RenderRSS(f)
+		contentType = "application/rss+xml; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be atom or rss"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render feed"})
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+	lastModified := f.Updated.UTC().Truncate(time.Second)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// notModified implements the If-None-Match / If-Modified-Since precedence of RFC 9110
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err == nil && !lastModified.After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// likeEscaper escapes the LIKE wildcards, for patterns using ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// parseGenres turns the stored ["A","B"] text back into a slice
+func parseGenres(raw string) []string {
+	var genres []string
+	json.Unmarshal([]byte(raw), &genres)
+	return genres
+}
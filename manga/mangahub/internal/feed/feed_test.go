@@ -0,0 +1,150 @@
+package feed
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"mangahub/pkg/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// feedTestServer serves the public feeds from a fresh database holding two
+// manga with one chapter each
+func feedTestServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	t.Chdir(t.TempDir()) // InitDB creates data/mangahub.db in the working directory
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mustExec(t, db, `INSERT INTO manga (id, title, author, genres, created_at) VALUES
+		('one-piece', 'One Piece', 'Oda', '["Shounen","Action"]', 1000),
+		('dr-stone', 'Dr. Stone', 'Inagaki', '["Sci-Fi"]', 2000)`)
+	mustExec(t, db, `INSERT INTO chapters (manga_id, number, title, created_at) VALUES
+		('one-piece', 1100, 'Back', 3000),
+		('dr-stone', 232, '', 4000)`)
+
+	fc := &FeedController{DB: db, BaseURL: "http://mangahub.test"}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/feeds/manga", fc.NewManga)
+	r.GET("/feeds/genres/:genre/chapters", fc.GenreChapters)
+	return r
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string) {
+	t.Helper()
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(r *gin.Engine, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAtomAndRSSOutput(t *testing.T) {
+	r := feedTestServer(t)
+
+	w := get(r, "/feeds/manga", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("atom: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if len(atom.Entries) != 2 || atom.Entries[0].Title != "New manga: Dr. Stone" {
+		t.Fatalf("atom entries: %+v", atom.Entries)
+	}
+	if e := atom.Entries[1]; e.Author == nil || e.Author.Name != "Oda" || len(e.Categories) != 2 || e.Categories[1].Term != "Action" {
+		t.Fatalf("atom entry: %+v", e)
+	}
+	if atom.Updated != time.Unix(2000, 0).UTC().Format(time.RFC3339) {
+		t.Fatalf("atom updated %s", atom.Updated)
+	}
+
+	w = get(r, "/feeds/manga?format=rss", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" {
+		t.Fatalf("rss: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var rss rssDoc
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatal(err)
+	}
+	if items := rss.Channel.Items; len(items) != 2 || items[1].GUID.Value != "urn:mangahub:manga:one-piece" || items[1].Categories[0] != "Shounen" {
+		t.Fatalf("rss items: %+v", items)
+	}
+
+	if w := get(r, "/feeds/manga?format=json", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: %d", w.Code)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	r := feedTestServer(t)
+	first := get(r, "/feeds/manga", nil)
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || lastModified != time.Unix(2000, 0).UTC().Format(http.TimeFormat) {
+		t.Fatalf("validators: %q %q", etag, lastModified)
+	}
+	later := time.Unix(5000, 0).UTC().Format(http.TimeFormat)
+	earlier := time.Unix(1000, 0).UTC().Format(http.TimeFormat)
+
+	for _, tc := range []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"weak etag in a list", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified},
+		{"any etag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": earlier}, http.StatusOK},
+		// If-None-Match wins over If-Modified-Since, whichever way each points
+		{"other etag, not modified since", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": later}, http.StatusOK},
+		{"matching etag, modified since", map[string]string{"If-None-Match": etag, "If-Modified-Since": earlier}, http.StatusNotModified},
+	} {
+		if w := get(r, "/feeds/manga", tc.header); w.Code != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	// The RSS rendering is a different representation, with its own ETag
+	if w := get(r, "/feeds/manga?format=rss", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Fatalf("rss with the atom etag: %d", w.Code)
+	}
+}
+
+func TestGenreFeedMatchesWholeGenres(t *testing.T) {
+	r := feedTestServer(t)
+	for genre, want := range map[string]int{
+		"shounen": 1,
+		"sci-fi":  1,
+		"Sci_Fi":  0, // _ is not a wildcard
+		"%25":     0, // Nor is %
+		"Shou":    0,
+	} {
+		w := get(r, "/feeds/genres/"+genre+"/chapters?format=rss", nil)
+		var rss rssDoc
+		if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+			t.Fatalf("%s: %d %v", genre, w.Code, err)
+		}
+		if len(rss.Channel.Items) != want {
+			t.Errorf("genre %q: %d items, want %d", genre, len(rss.Channel.Items), want)
+		}
+	}
+}
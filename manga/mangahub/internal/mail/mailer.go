@@ -0,0 +1,115 @@
+// Package mail sends the few emails MangaHub needs (address verification,
+// password reset) through a pluggable Mailer.
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // Plain text
+}
+
+// Mailer delivers a message. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends through an SMTP server. Authentication is only attempted
+// when Username is set; net/smtp refuses to send credentials over plain text
+// connections except to localhost.
+type SMTPMailer struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	// The envelope sender is the bare address, without a display name
+	sender := m.From
+	if addr, err := netmail.ParseAddress(m.From); err == nil {
+		sender = addr.Address
+	}
+	return smtp.SendMail(m.Addr, auth, sender, []string{msg.To}, format(m.From, msg))
+}
+
+// LogMailer prints messages instead of sending them (development default)
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("📧 Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes each message as an .eml file into Dir
+type FileMailer struct {
+	Dir  string
+	From string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.n++
+	name := fmt.Sprintf("%d-%03d.eml", time.Now().UnixNano(), m.n)
+	m.mu.Unlock()
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o644)
+}
+
+// FromEnv picks the mailer from the environment: SMTP when MANGAHUB_SMTP_ADDR
+// is set, .eml files when MANGAHUB_MAIL_DIR is set, else the log.
+func FromEnv() Mailer {
+	from := os.Getenv("MANGAHUB_MAIL_FROM")
+	if from == "" {
+		from = "MangaHub <no-reply@mangahub.local>"
+	}
+	if addr := os.Getenv("MANGAHUB_SMTP_ADDR"); addr != "" {
+		return &SMTPMailer{
+			Addr:     addr,
+			Username: os.Getenv("MANGAHUB_SMTP_USER"),
+			Password: os.Getenv("MANGAHUB_SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+	if dir := os.Getenv("MANGAHUB_MAIL_DIR"); dir != "" {
+		return &FileMailer{Dir: dir, From: from}
+	}
+	return LogMailer{}
+}
+
+// format renders an RFC 5322 message. Header values come from our own code
+// and the user's validated address, but strip line breaks regardless.
+func format(from string, msg Message) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	b.WriteString("From: " + clean.Replace(from) + "\r\n")
+	b.WriteString("To: " + clean.Replace(msg.To) + "\r\n")
+	b.WriteString("Subject: " + clean.Replace(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
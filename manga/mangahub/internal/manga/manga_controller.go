@@ -2,9 +2,10 @@ package manga
 
 import (
 	"context"
+	"mangahub/internal/auth"
 	"mangahub/proto"
-	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,6 +23,20 @@ func (mc *MangaController) GetMangaDetails(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
+	// Signed-in callers are checked by the gRPC server under their own role.
+	// Anonymous ones, and those whose credentials are stale or invalid (the
+	// route is public, so they may still read), fall back to the gateway's
+	// service token.
+	if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		if _, err := auth.ParseToken(token); err == nil {
+			ctx = auth.WithUserToken(ctx, token)
+		}
+	} else if key := c.GetHeader(auth.HeaderAPIKey); key != "" && auth.APIKeys != nil {
+		if _, err := auth.APIKeys.Authenticate(key); err == nil {
+			ctx = auth.WithAPIKey(ctx, key)
+		}
+	}
+
 	// Calling the gRPC Internal Service (Requirement 5)
 	println("📡 API Gateway: Calling gRPC server...")
 	resp, err := mc.GRPCClient.GetManga(ctx, &proto.GetMangaRequest{Id: id})
@@ -34,22 +49,3 @@ func (mc *MangaController) GetMangaDetails(c *gin.Context) {
 	println("✅ API Gateway: Found manga:", resp.Title)
 	c.JSON(http.StatusOK, resp)
 }
-
-func (mc *MangaController) AddManga(c *gin.Context) {
-	// 1. Verify Admin Role (from JWT middleware)
-	role, _ := c.Get("role")
-	if role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
-		return
-	}
-
-	// 2. Add to DB/gRPC... (logic here)
-
-	// 3. BROADCAST via UDP
-	udpAddr, _ := net.ResolveUDPAddr("udp", "255.255.255.255:12345")
-	conn, _ := net.DialUDP("udp", nil, udpAddr)
-	defer conn.Close()
-
-	msg := []byte("New Manga Added: One Piece Vol 100!")
-	conn.Write(msg)
-}
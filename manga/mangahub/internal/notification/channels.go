@@ -0,0 +1,124 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mangahub/pkg/models"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Channel pushes a stored notification to a user through one transport.
+// The inbox is always written first; channels only handle live delivery.
+type Channel interface {
+	Deliver(prefs models.NotificationPrefs, n models.Notification) error
+}
+
+// ChannelFunc adapts a plain function to the Channel interface
+type ChannelFunc func(prefs models.NotificationPrefs, n models.Notification) error
+
+func (f ChannelFunc) Deliver(prefs models.NotificationPrefs, n models.Notification) error {
+	return f(prefs, n)
+}
+
+// websocketChannel pushes to the user's open /ws/notifications sockets
+type websocketChannel struct {
+	service *Service
+}
+
+func (w websocketChannel) Deliver(prefs models.NotificationPrefs, n models.Notification) error {
+	w.service.push(prefs.UserID, n, -1)
+	return nil
+}
+
+// WebhookChannel POSTs the notification as JSON to the URL in the user's
+// preferences. The default client only connects to public addresses, so a
+// webhook cannot be pointed at the server's own network.
+type WebhookChannel struct {
+	Client *http.Client
+}
+
+// ErrPrivateAddress is returned when a webhook resolves to a loopback,
+// private, link-local or otherwise internal address
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+var webhookClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil, // A proxy would connect on our behalf, past the address check
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			// Checked after DNS resolution, on the address actually dialled,
+			// so rebinding and redirects cannot get around it
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return ErrPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)} // Carrier-grade NAT
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// validWebhookURL accepts absolute https URLs. Whether the host is public is
+// checked when connecting, since DNS can change in between.
+func validWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("webhook_url must be an https URL")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+type webhookPayload struct {
+	UserID       string              `json:"user_id"`
+	Notification models.Notification `json:"notification"`
+}
+
+func (w WebhookChannel) Deliver(prefs models.NotificationPrefs, n models.Notification) error {
+	if prefs.WebhookURL == "" {
+		return fmt.Errorf("no webhook url configured")
+	}
+	if err := validWebhookURL(prefs.WebhookURL); err != nil {
+		return err // Saved before URLs were checked
+	}
+	body, err := json.Marshal(webhookPayload{UserID: prefs.UserID, Notification: n})
+	if err != nil {
+		return err
+	}
+
+	client := w.Client
+	if client == nil {
+		client = webhookClient
+	}
+	resp, err := client.Post(prefs.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
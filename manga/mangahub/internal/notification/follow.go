@@ -0,0 +1,265 @@
+package notification
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"mangahub/pkg/models"
+	"strings"
+	"time"
+)
+
+// Follow target types
+const (
+	FollowManga  = "manga"
+	FollowAuthor = "author"
+	FollowGenre  = "genre"
+)
+
+// Subject describes the manga an alert is about, so followers of the title,
+// its author or any of its genres can be found
+type Subject struct {
+	MangaID string
+	Author  string
+	Genres  []string
+}
+
+// normalizeTarget makes author/genre follows case-insensitive; manga IDs are kept as-is
+func normalizeTarget(targetType, target string) string {
+	target = strings.TrimSpace(target)
+	if targetType == FollowManga {
+		return target
+	}
+	return strings.ToLower(target)
+}
+
+func (s *Service) Follow(userID, targetType, target string) error {
+	switch targetType {
+	case FollowManga, FollowAuthor, FollowGenre:
+	default:
+		return fmt.Errorf("unknown follow type %q", targetType)
+	}
+	target = normalizeTarget(targetType, target)
+	if target == "" {
+		return fmt.Errorf("follow target is empty")
+	}
+
+	_, err := s.DB.Exec(`INSERT OR IGNORE INTO follows (user_id, target_type, target, created_at)
+		VALUES (?, ?, ?, ?)`, userID, targetType, target, time.Now().Unix())
+	return err
+}
+
+func (s *Service) Unfollow(userID, targetType, target string) (bool, error) {
+	res, err := s.DB.Exec("DELETE FROM follows WHERE user_id = ? AND target_type = ? AND target = ?",
+		userID, targetType, normalizeTarget(targetType, target))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *Service) Follows(userID string) ([]models.Follow, error) {
+	rows, err := s.DB.Query(`SELECT user_id, target_type, target, created_at
+		FROM follows WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Follow{}
+	for rows.Next() {
+		var f models.Follow
+		if err := rows.Scan(&f.UserID, &f.TargetType, &f.Target, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+// Followers returns every user following the manga, its author or one of its genres
+func (s *Service) Followers(subject Subject) ([]string, error) {
+	query := "SELECT DISTINCT user_id FROM follows WHERE (target_type = ? AND target = ?)"
+	args := []interface{}{FollowManga, subject.MangaID}
+	if subject.Author != "" {
+		query += " OR (target_type = ? AND target = ?)"
+		args = append(args, FollowAuthor, normalizeTarget(FollowAuthor, subject.Author))
+	}
+	for _, genre := range subject.Genres {
+		query += " OR (target_type = ? AND target = ?)"
+		args = append(args, FollowGenre, normalizeTarget(FollowGenre, genre))
+	}
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// NotifyFollowers sends the notification only to users who follow the
+// subject. Like Broadcast it returns at once and logs failures: a manga with
+// many followers must not hold up the request that announced it.
+func (s *Service) NotifyFollowers(subject Subject, n models.Notification) {
+	go func() {
+		userIDs, err := s.Followers(subject)
+		if err != nil {
+			log.Printf("⚠️ Could not load followers of manga %s: %v", subject.MangaID, err)
+			return
+		}
+		if err := s.notifyMany(userIDs, n); err != nil {
+			log.Printf("⚠️ Notifying %d followers of manga %s failed: %v", len(userIDs), subject.MangaID, err)
+		}
+	}()
+}
+
+func defaultPrefs(userID string) models.NotificationPrefs {
+	return models.NotificationPrefs{UserID: userID, Channels: []string{ChannelWebSocket}}
+}
+
+const prefsColumns = `user_id, channels, webhook_url, muted, digest`
+
+func scanPrefs(row interface{ Scan(...interface{}) error }) (models.NotificationPrefs, error) {
+	var prefs models.NotificationPrefs
+	var channels string
+	var webhook sql.NullString
+	err := row.Scan(&prefs.UserID, &channels, &webhook, &prefs.Muted, &prefs.Digest)
+	prefs.Channels = splitChannels(channels)
+	prefs.WebhookURL = webhook.String
+	return prefs, err
+}
+
+// Prefs loads the user's delivery preferences, falling back to WebSocket-only
+func (s *Service) Prefs(userID string) (models.NotificationPrefs, error) {
+	prefs, err := scanPrefs(s.DB.QueryRow(`SELECT `+prefsColumns+` FROM notification_prefs WHERE user_id = ?`, userID))
+	if err == sql.ErrNoRows {
+		return defaultPrefs(userID), nil
+	}
+	return prefs, err
+}
+
+// prefsOf loads the stored preferences of the given users, by user ID.
+// Users without a row use defaultPrefs.
+func (s *Service) prefsOf(userIDs []string) (map[string]models.NotificationPrefs, error) {
+	all := make(map[string]models.NotificationPrefs)
+	err := inBatches(userIDs, func(in string, args []interface{}) error {
+		rows, err := s.DB.Query(`SELECT `+prefsColumns+` FROM notification_prefs WHERE user_id IN (`+in+`)`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			prefs, err := scanPrefs(rows)
+			if err != nil {
+				return err
+			}
+			all[prefs.UserID] = prefs
+		}
+		return rows.Err()
+	})
+	return all, err
+}
+
+// SavePrefs validates and stores the user's delivery preferences
+func (s *Service) SavePrefs(prefs models.NotificationPrefs) error {
+	for _, name := range prefs.Channels {
+		if _, ok := s.Channels[name]; !ok {
+			return fmt.Errorf("unknown channel %q", name)
+		}
+		if name == ChannelWebhook && prefs.WebhookURL == "" {
+			return fmt.Errorf("webhook channel needs a webhook_url")
+		}
+	}
+	if prefs.WebhookURL != "" {
+		if err := validWebhookURL(prefs.WebhookURL); err != nil {
+			return err
+		}
+	}
+
+	// The first digest only covers what arrives after digests are switched on
+	_, err := s.DB.Exec(`
+		INSERT INTO notification_prefs (user_id, channels, webhook_url, muted, digest, digest_sent_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET channels=excluded.channels,
+			webhook_url=excluded.webhook_url, muted=excluded.muted, digest=excluded.digest,
+			digest_sent_at=CASE WHEN excluded.digest AND NOT notification_prefs.digest
+				THEN excluded.digest_sent_at ELSE notification_prefs.digest_sent_at END`,
+		prefs.UserID, strings.Join(prefs.Channels, ","), prefs.WebhookURL, prefs.Muted, prefs.Digest, time.Now().Unix())
+	return err
+}
+
+func splitChannels(raw string) []string {
+	channels := []string{}
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			channels = append(channels, name)
+		}
+	}
+	return channels
+}
+
+// RunDigest periodically sends digest users one summary of what arrived in their
+// inbox since the last digest. It blocks, so start it with `go`.
+func (s *Service) RunDigest(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.sendDigests()
+	}
+}
+
+func (s *Service) sendDigests() {
+	rows, err := s.DB.Query(`SELECT user_id, digest_sent_at FROM notification_prefs
+		WHERE digest = 1 AND muted = 0`)
+	if err != nil {
+		log.Printf("⚠️ Digest query failed: %v", err)
+		return
+	}
+	type pending struct {
+		userID string
+		since  int64
+	}
+	var users []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.userID, &p.since); err == nil {
+			users = append(users, p)
+		}
+	}
+	rows.Close()
+
+	now := time.Now().Unix()
+	for _, u := range users {
+		var count int
+		err := s.DB.QueryRow(`SELECT COUNT(*) FROM notifications
+			WHERE user_id = ? AND read_at IS NULL AND created_at > ?`, u.userID, u.since).Scan(&count)
+		if err != nil || count == 0 {
+			continue
+		}
+
+		prefs, err := s.Prefs(u.userID)
+		if err != nil {
+			continue
+		}
+		// The summary is delivery-only; the individual items are already in the inbox
+		s.deliver(prefs, models.Notification{
+			UserID:    u.userID,
+			Type:      TypeSystem,
+			Title:     fmt.Sprintf("You have %d new updates", count),
+			Link:      "/users/notifications?unread=true",
+			CreatedAt: now,
+		}, -1)
+		s.DB.Exec("UPDATE notification_prefs SET digest_sent_at = ? WHERE user_id = ?", now, u.userID)
+	}
+}
@@ -0,0 +1,205 @@
+package notification
+
+import (
+	"encoding/json"
+	"log"
+	"mangahub/internal/backplane"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Connection timing, as for chat sockets
+const (
+	writeWait  = 10 * time.Second    // Time allowed to write one frame
+	pongWait   = 60 * time.Second    // Time allowed between pongs before the peer counts as gone
+	pingPeriod = (pongWait * 9) / 10 // Pings go out a little more often than pongWait
+
+	// SendBuffer is how many events may wait for a socket before the hub
+	// gives up on it as a slow consumer
+	SendBuffer = 64
+)
+
+// Client is one open /ws/notifications connection. A user may have several (tabs, devices).
+type Client struct {
+	Conn   *websocket.Conn
+	UserID string
+
+	send chan []byte // Encoded events; only the hub sends to or closes it
+}
+
+func NewClient(conn *websocket.Conn, userID string) *Client {
+	return &Client{Conn: conn, UserID: userID, send: make(chan []byte, SendBuffer)}
+}
+
+// Event is the JSON frame pushed to notification sockets
+type Event struct {
+	Event        string      `json:"event"` // "notification" or "unread_count"
+	Notification interface{} `json:"notification,omitempty"`
+	UnreadCount  int         `json:"unread_count"`
+}
+
+type delivery struct {
+	UserID string `json:"user_id"`
+	Event  Event  `json:"event"`
+}
+
+// Hub tracks notification sockets per user. It is separate from the chat hub so
+// that inbox updates never mix with chat traffic. With a Backplane set, events
+// also reach the user's sockets on other instances.
+type Hub struct {
+	ID         string // Identifies this instance on the backplane
+	Backplane  backplane.Backplane
+	Clients    map[string]map[*Client]bool
+	Register   chan *Client
+	Unregister chan *Client
+	deliver    chan delivery
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		ID:         uuid.NewString(),
+		Clients:    make(map[string]map[*Client]bool),
+		Register:   make(chan *Client),
+		Unregister: make(chan *Client),
+		deliver:    make(chan delivery, 64),
+	}
+}
+
+// Send queues an event for every socket the user has open, on any instance
+func (h *Hub) Send(userID string, event Event) {
+	d := delivery{UserID: userID, Event: event}
+	h.deliver <- d
+	if h.Backplane != nil {
+		msg, err := backplane.NewMessage(backplane.TopicNotification, h.ID, d)
+		if err == nil {
+			err = h.Backplane.Publish(msg)
+		}
+		if err != nil {
+			log.Printf("Notification backplane error: %v", err)
+		}
+	}
+}
+
+// Serve registers the client and starts its pumps, which own the connection
+// from here on
+func (h *Hub) Serve(client *Client) {
+	h.Register <- client
+	go client.writePump()
+	go client.readPump(h)
+}
+
+// Run owns the socket list. Set Backplane before starting it.
+func (h *Hub) Run() {
+	var remote <-chan backplane.Message
+	if h.Backplane != nil {
+		remote = h.Backplane.Subscribe(backplane.TopicNotification)
+	}
+	for {
+		select {
+		case msg, ok := <-remote:
+			if !ok {
+				remote = nil
+				continue
+			}
+			var d delivery
+			if msg.Origin != h.ID && json.Unmarshal(msg.Data, &d) == nil {
+				h.push(d)
+			}
+		case client := <-h.Register:
+			if h.Clients[client.UserID] == nil {
+				h.Clients[client.UserID] = make(map[*Client]bool)
+			}
+			h.Clients[client.UserID][client] = true
+		case client := <-h.Unregister:
+			h.remove(client)
+		case d := <-h.deliver:
+			h.push(d)
+		}
+	}
+}
+
+// push queues an event for the user's sockets. It never blocks: a socket
+// whose buffer is full is dropped, so one slow browser cannot hold up
+// everyone else's notifications.
+func (h *Hub) push(d delivery) {
+	conns := h.Clients[d.UserID]
+	if len(conns) == 0 {
+		return
+	}
+	data, err := json.Marshal(d.Event)
+	if err != nil {
+		log.Printf("Notification encode error: %v", err)
+		return
+	}
+	for client := range conns {
+		select {
+		case client.send <- data:
+		default:
+			log.Printf("🐢 Dropping slow notification socket of user %s", client.UserID)
+			h.remove(client)
+		}
+	}
+}
+
+func (h *Hub) remove(client *Client) {
+	conns, ok := h.Clients[client.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := conns[client]; ok {
+		delete(conns, client)
+		close(client.send) // The write pump closes the connection
+	}
+	if len(conns) == 0 {
+		delete(h.Clients, client.UserID)
+	}
+}
+
+// readPump discards what the client sends (the socket is push-only) and
+// notices when the peer goes away or stops answering pings
+func (c *Client) readPump(h *Hub) {
+	defer func() {
+		h.Unregister <- c
+		c.Conn.Close()
+	}()
+	c.Conn.SetReadLimit(512)
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		if _, _, err := c.Conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// writePump is the only writer on the connection
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package notification
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"mangahub/pkg/models"
+	"strings"
+	"time"
+)
+
+// Notification types
+const (
+	TypeNewManga   = "new_manga"
+	TypeNewChapter = "new_chapter"
+	TypeReply      = "reply"
+	TypeSystem     = "system"
+)
+
+// Channel names users can pick in their preferences
+const (
+	ChannelWebSocket = "websocket"
+	ChannelWebhook   = "webhook"
+	ChannelUDP       = "udp" // Registered by the UDP server, see udp.NotificationServer
+)
+
+// Service stores notifications in each user's inbox and pushes them through
+// the channels the user picked (WebSocket by default)
+type Service struct {
+	DB       *sql.DB
+	Hub      *Hub
+	Channels map[string]Channel
+}
+
+func NewService(db *sql.DB) *Service {
+	s := &Service{DB: db, Hub: NewHub()}
+	s.Channels = map[string]Channel{
+		ChannelWebSocket: websocketChannel{service: s},
+		ChannelWebhook:   WebhookChannel{},
+	}
+	return s
+}
+
+// Notify saves a notification for one user and pushes it according to their preferences
+func (s *Service) Notify(userID string, n models.Notification) (models.Notification, error) {
+	n.UserID = userID
+	n.Read = false
+	if n.CreatedAt == 0 {
+		n.CreatedAt = time.Now().Unix()
+	}
+
+	res, err := s.DB.Exec(`
+		INSERT INTO notifications (user_id, type, title, body, link, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		n.UserID, n.Type, n.Title, n.Body, n.Link, n.CreatedAt)
+	if err != nil {
+		return n, fmt.Errorf("failed to save notification: %w", err)
+	}
+	n.ID, _ = res.LastInsertId()
+
+	s.dispatch(userID, n)
+	return n, nil
+}
+
+// dispatch pushes a stored notification through the user's channels.
+// Muted users and digest users get nothing now; digests are sent by RunDigest.
+func (s *Service) dispatch(userID string, n models.Notification) {
+	prefs, err := s.Prefs(userID)
+	if err != nil {
+		log.Printf("⚠️ Could not load notification prefs for user %s: %v", userID, err)
+		return
+	}
+	if prefs.Muted || prefs.Digest {
+		return
+	}
+	s.deliver(prefs, n, -1)
+}
+
+// deliver pushes n through the user's channels. unread is their unread count
+// for the WebSocket badge, or -1 to look it up.
+func (s *Service) deliver(prefs models.NotificationPrefs, n models.Notification, unread int) {
+	for _, name := range prefs.Channels {
+		ch, ok := s.Channels[name]
+		if !ok {
+			continue
+		}
+		if name == ChannelWebSocket {
+			s.push(prefs.UserID, n, unread)
+			continue
+		}
+		// External transports may be slow; never hold up the caller
+		go func(name string, ch Channel) {
+			if err := ch.Deliver(prefs, n); err != nil {
+				log.Printf("⚠️ %s delivery to user %s failed: %v", name, prefs.UserID, err)
+			}
+		}(name, ch)
+	}
+}
+
+// push sends a notification to the user's open sockets
+func (s *Service) push(userID string, n models.Notification, unread int) {
+	if unread < 0 {
+		unread, _ = s.UnreadCount(userID)
+	}
+	s.Hub.Send(userID, Event{Event: "notification", Notification: n, UnreadCount: unread})
+}
+
+// Broadcast sends the same notification to every registered user. It returns
+// at once: storing and pushing happen in the background, so HTTP and UDP
+// handlers are not held up by the size of the user table.
+func (s *Service) Broadcast(n models.Notification) {
+	go func() {
+		rows, err := s.DB.Query("SELECT id FROM users")
+		if err != nil {
+			log.Printf("⚠️ Broadcast failed to list users: %v", err)
+			return
+		}
+		var userIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err == nil {
+				userIDs = append(userIDs, id)
+			}
+		}
+		rows.Close()
+
+		if err := s.notifyMany(userIDs, n); err != nil {
+			log.Printf("⚠️ Broadcast failed: %v", err)
+		}
+	}()
+}
+
+// notifyMany saves one notification per user in a single transaction, then
+// pushes them. Preferences and unread counts are loaded once for everyone.
+func (s *Service) notifyMany(userIDs []string, n models.Notification) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	n.Read = false
+	if n.CreatedAt == 0 {
+		n.CreatedAt = time.Now().Unix()
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO notifications (user_id, type, title, body, link, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	saved := make([]models.Notification, 0, len(userIDs))
+	for _, id := range userIDs {
+		res, err := stmt.Exec(id, n.Type, n.Title, n.Body, n.Link, n.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save notification: %w", err)
+		}
+		m := n
+		m.UserID = id
+		m.ID, _ = res.LastInsertId()
+		saved = append(saved, m)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save notifications: %w", err)
+	}
+
+	prefs, err := s.prefsOf(userIDs)
+	if err != nil {
+		return fmt.Errorf("failed to load notification prefs: %w", err)
+	}
+	unread, err := s.unreadCounts(userIDs)
+	if err != nil {
+		return fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	for _, m := range saved {
+		p, ok := prefs[m.UserID]
+		if !ok {
+			p = defaultPrefs(m.UserID)
+		}
+		if !p.Muted && !p.Digest {
+			s.deliver(p, m, unread[m.UserID])
+		}
+	}
+	return nil
+}
+
+// unreadCounts returns the unread counts of the given users
+func (s *Service) unreadCounts(userIDs []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := inBatches(userIDs, func(in string, args []interface{}) error {
+		rows, err := s.DB.Query(`SELECT user_id, COUNT(*) FROM notifications
+			WHERE read_at IS NULL AND user_id IN (`+in+`) GROUP BY user_id`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			var n int
+			if err := rows.Scan(&id, &n); err != nil {
+				return err
+			}
+			counts[id] = n
+		}
+		return rows.Err()
+	})
+	return counts, err
+}
+
+// queryBatch is how many IDs go in one IN (...) list, well under SQLite's
+// limit on bound parameters
+const queryBatch = 500
+
+// inBatches calls query with the placeholders and arguments of an IN list
+// for each batch of ids
+func inBatches(ids []string, query func(in string, args []interface{}) error) error {
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), queryBatch)]
+		ids = ids[len(batch):]
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		if err := query(strings.TrimSuffix(strings.Repeat("?,", len(batch)), ","), args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the newest notifications for a user, optionally only unread ones.
+// before is an exclusive notification ID cursor (0 = start from the newest).
+func (s *Service) List(userID string, unreadOnly bool, before int64, limit int) ([]models.Notification, error) {
+	query := `SELECT id, user_id, type, title, body, link, read_at, created_at
+		FROM notifications WHERE user_id = ?`
+	args := []interface{}{userID}
+	if unreadOnly {
+		query += " AND read_at IS NULL"
+	}
+	if before > 0 {
+		query += " AND id < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		var body, link sql.NullString
+		var readAt sql.NullInt64
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &body, &link, &readAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		n.Body = body.String
+		n.Link = link.String
+		n.Read = readAt.Valid
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func (s *Service) UnreadCount(userID string) (int, error) {
+	var count int
+	err := s.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL",
+		userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks a single notification as read. It reports false if the
+// notification does not exist or belongs to someone else.
+func (s *Service) MarkRead(userID string, id int64) (bool, error) {
+	res, err := s.DB.Exec(`UPDATE notifications SET read_at = COALESCE(read_at, ?)
+		WHERE id = ? AND user_id = ?`, time.Now().Unix(), id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		s.pushUnreadCount(userID)
+	}
+	return n > 0, nil
+}
+
+// MarkAllRead marks every unread notification of the user as read
+func (s *Service) MarkAllRead(userID string) (int64, error) {
+	res, err := s.DB.Exec("UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL",
+		time.Now().Unix(), userID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	s.pushUnreadCount(userID)
+	return n, nil
+}
+
+// pushUnreadCount keeps the badge in sync across the user's other tabs
+func (s *Service) pushUnreadCount(userID string) {
+	unread, err := s.UnreadCount(userID)
+	if err != nil {
+		return
+	}
+	s.Hub.Send(userID, Event{Event: "unread_count", UnreadCount: unread})
+}
@@ -0,0 +1,176 @@
+package notification
+
+import (
+	"fmt"
+	"log"
+	"mangahub/pkg/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+type NotificationController struct {
+	Service *Service
+}
+
+// GET /users/notifications?unread=true&before=<id>&limit=20
+func (nc *NotificationController) List(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	before, _ := strconv.ParseInt(c.Query("before"), 10, 64)
+	unreadOnly := c.Query("unread") == "true"
+
+	list, err := nc.Service.List(userID, unreadOnly, before, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notifications"})
+		return
+	}
+	unread, err := nc.Service.UnreadCount(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": list, "unread_count": unread})
+}
+
+// POST /users/notifications/:id/read
+func (nc *NotificationController) MarkRead(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification id"})
+		return
+	}
+
+	found, err := nc.Service.MarkRead(userID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// POST /users/notifications/read-all
+func (nc *NotificationController) MarkAllRead(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	n, err := nc.Service.MarkAllRead(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All notifications marked as read", "updated": n})
+}
+
+// GET /ws/notifications
+// Live inbox updates. The socket is push-only; anything the client sends is ignored.
+func (nc *NotificationController) Connect(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WS Upgrade Error: %v", err)
+		return
+	}
+
+	nc.Service.Hub.Serve(NewClient(conn, userID))
+
+	// Tell the new socket where the badge stands right away
+	if unread, err := nc.Service.UnreadCount(userID); err == nil {
+		nc.Service.Hub.Send(userID, Event{Event: "unread_count", UnreadCount: unread})
+	}
+}
+
+// GET /users/follows
+func (nc *NotificationController) ListFollows(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	follows, err := nc.Service.Follows(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load follows"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"follows": follows})
+}
+
+// POST /users/follows  {"type": "manga|author|genre", "target": "..."}
+func (nc *NotificationController) Follow(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	var input struct {
+		Type   string `json:"type" binding:"required"`
+		Target string `json:"target" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := nc.Service.Follow(userID, input.Type, input.Target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Following " + input.Type + " " + input.Target})
+}
+
+// DELETE /users/follows/:type/:target
+func (nc *NotificationController) Unfollow(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	found, err := nc.Service.Unfollow(userID, c.Param("type"), c.Param("target"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unfollow"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not following"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unfollowed"})
+}
+
+// GET /users/notification-prefs
+func (nc *NotificationController) GetPrefs(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	prefs, err := nc.Service.Prefs(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// PUT /users/notification-prefs
+func (nc *NotificationController) UpdatePrefs(c *gin.Context) {
+	var prefs models.NotificationPrefs
+	if err := c.ShouldBindJSON(&prefs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	prefs.UserID = fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	if err := nc.Service.SavePrefs(prefs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
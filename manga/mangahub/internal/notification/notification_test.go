@@ -0,0 +1,82 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"mangahub/pkg/database"
+	"mangahub/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestNotifyFollowersUsesEachRecipientsPrefs(t *testing.T) {
+	t.Chdir(t.TempDir()) // InitDB creates data/mangahub.db in the working directory
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := NewService(db)
+	go s.Hub.Run()
+	delivered := make(chan string, 10)
+	s.Channels["test"] = ChannelFunc(func(prefs models.NotificationPrefs, n models.Notification) error {
+		delivered <- prefs.UserID
+		return nil
+	})
+
+	// More followers than fit in one IN list, so prefs and counts come from several batches
+	followers := queryBatch + 100
+	for i := 1; i <= followers; i++ {
+		if err := s.Follow(fmt.Sprint(i), FollowManga, "m1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := fmt.Sprint(followers)
+	if err := s.SavePrefs(models.NotificationPrefs{UserID: last, Channels: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrefs(models.NotificationPrefs{UserID: "2", Channels: []string{"test"}, Muted: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Notify("nobody", models.Notification{Type: TypeSystem, Title: "unrelated"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO notifications (user_id, type, title, created_at) VALUES ('1', ?, 'earlier', 1)", TypeSystem); err != nil {
+		t.Fatal(err)
+	}
+	socket := &Client{UserID: "1", send: make(chan []byte, SendBuffer)}
+	s.Hub.Register <- socket
+
+	s.NotifyFollowers(Subject{MangaID: "m1"}, models.Notification{Type: TypeNewChapter, Title: "New Chapter"})
+
+	select {
+	case id := <-delivered:
+		if id != last {
+			t.Fatalf("delivered to user %s, want %s", id, last)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing delivered to the custom channel")
+	}
+	select {
+	case data := <-socket.send:
+		var e Event
+		json.Unmarshal(data, &e)
+		if e.Event != "notification" || e.UnreadCount != 2 {
+			t.Fatalf("socket got %s, want a notification with 2 unread", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing pushed to the follower's socket")
+	}
+	select {
+	case id := <-delivered:
+		t.Fatalf("muted user %s got a delivery", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	var stored int
+	db.QueryRow("SELECT COUNT(*) FROM notifications WHERE type = ?", TypeNewChapter).Scan(&stored)
+	if stored != followers {
+		t.Fatalf("%d notifications stored, want %d", stored, followers)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"mangahub/internal/auth"
 	"mangahub/pkg/models"
 	"net"
 )
@@ -42,6 +43,15 @@ func (s *ProgressSyncServer) handleSync(conn net.Conn) {
 	var update models.ProgressUpdate // Using shared model
 
 	if err := decoder.Decode(&update); err == nil {
+		// With a verifier configured, only updates carrying a valid token count
+		if auth.Verifier != nil {
+			claims, err := auth.ParseToken(update.Token)
+			if err != nil {
+				log.Printf("⚠️ [TCP SYNC] Rejected update: %v", err)
+				return
+			}
+			update.Username = fmt.Sprintf("%v", claims["username"])
+		}
 		fmt.Printf("🔄 [TCP SYNC] User %s is on Chapter %s\n", update.Username, update.Chapter)
 	}
 }
@@ -0,0 +1,56 @@
+package udp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CookieLifetime is how long a registration cookie may be echoed back
+const CookieLifetime = 30 * time.Second
+
+// A REGISTER or MREGISTER without a cookie is answered with COOKIE <cookie>,
+// and nothing is stored. The subscription starts when the cookie comes back
+// from the same address, which proves the client can receive there: a spoofed
+// source address never sees the cookie, so it cannot be subscribed to the
+// stream. The cookie is "<unix time>:<mac>", which a JWT (base64url and
+// dots) can never look like.
+
+// cookie returns a registration cookie for a command sent from addr
+func (s *NotificationServer) cookie(cmd string, from net.Addr, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return ts + ":" + s.cookieMAC(cmd, from, ts)
+}
+
+// validCookie reports whether cookie was issued to from, for cmd, recently
+func (s *NotificationServer) validCookie(cmd string, from net.Addr, cookie string, now time.Time) bool {
+	ts, mac, ok := strings.Cut(cookie, ":")
+	if !ok {
+		return false
+	}
+	issued, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(issued, 0))
+	if age < 0 || age > CookieLifetime {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(s.cookieMAC(cmd, from, ts)))
+}
+
+func (s *NotificationServer) cookieMAC(cmd string, from net.Addr, ts string) string {
+	m := hmac.New(sha256.New, s.cookieKey)
+	fmt.Fprintf(m, "%s|%s|%s", cmd, from, ts)
+	return hex.EncodeToString(m.Sum(nil)[:12])
+}
+
+// isCookie tells a cookie from a JWT among REGISTER's arguments
+func isCookie(arg string) bool {
+	return strings.Contains(arg, ":")
+}
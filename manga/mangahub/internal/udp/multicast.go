@@ -0,0 +1,121 @@
+package udp
+
+import (
+	"fmt"
+	"mangahub/pkg/models"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultMulticastTTL keeps multicast datagrams on the local network
+const DefaultMulticastTTL = 1
+
+// multicastPublisher sends announcements once to a multicast group instead of
+// once per subscriber. It has its own sequence so LAN listeners can MNACK gaps.
+type multicastPublisher struct {
+	conn  *ipv4.PacketConn
+	group *net.UDPAddr
+	stream
+}
+
+func (s *NotificationServer) startMulticast() error {
+	if len(s.MulticastKey) == 0 {
+		return fmt.Errorf("no multicast key: group packets could not be signed")
+	}
+	group, err := net.ResolveUDPAddr("udp4", s.MulticastGroup)
+	if err != nil {
+		return fmt.Errorf("invalid multicast group: %w", err)
+	}
+	if !group.IP.IsMulticast() {
+		return fmt.Errorf("%s is not a multicast address", group.IP)
+	}
+
+	c, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return err
+	}
+	conn := ipv4.NewPacketConn(c)
+
+	ttl := s.MulticastTTL
+	if ttl == 0 {
+		ttl = DefaultMulticastTTL
+	}
+	if err := conn.SetMulticastTTL(ttl); err != nil {
+		c.Close()
+		return fmt.Errorf("set multicast TTL: %w", err)
+	}
+	if s.MulticastInterface != "" {
+		iface, err := net.InterfaceByName(s.MulticastInterface)
+		if err != nil {
+			c.Close()
+			return err
+		}
+		if err := conn.SetMulticastInterface(iface); err != nil {
+			c.Close()
+			return fmt.Errorf("set multicast interface: %w", err)
+		}
+	}
+	// Listeners on this host (and loopback tests) should hear us too
+	conn.SetMulticastLoopback(true)
+
+	s.mu.Lock()
+	s.mcast = &multicastPublisher{conn: conn, group: group, stream: stream{key: s.MulticastKey}}
+	s.mu.Unlock()
+	fmt.Printf("📡 UDP multicast publishing to %s (ttl %d)\n", group, ttl)
+	return nil
+}
+
+// multicast sends the notification to the group, if multicast is enabled
+func (s *NotificationServer) multicast(n models.Notification) {
+	s.mu.Lock()
+	m := s.mcast
+	var payload []byte
+	var err error
+	if m != nil {
+		payload, err = m.stamp(n, s.ReplayBuffer)
+	}
+	s.mu.Unlock()
+
+	if m == nil || err != nil {
+		return
+	}
+	if _, err := m.conn.WriteTo(payload, nil, m.group); err != nil {
+		fmt.Printf("⚠️ UDP multicast send failed: %v\n", err)
+	}
+}
+
+// multicastNack retransmits group packets by unicast to the listener that
+// missed them. Only listeners registered with MREGISTER may ask.
+func (s *NotificationServer) multicastNack(from net.Addr, args []string, size int) {
+	first, last, valid := parseRange(args)
+	if !valid {
+		s.reply(from, "ERROR usage: MNACK <from> <to>")
+		return
+	}
+
+	s.mu.Lock()
+	m := s.mcast
+	var sub *subscriber
+	var ok bool
+	var resend [][]byte
+	var lost string
+	var short bool
+	if m != nil {
+		sub, ok = s.nackingSubscriber(from, true)
+		if sub != nil {
+			resend, lost, short = m.lookup(first, last, size*NackAmplification)
+		}
+	}
+	s.mu.Unlock()
+
+	if m == nil {
+		s.reply(from, "ERROR multicast disabled")
+		return
+	}
+	if !ok {
+		s.reply(from, "ERROR not registered")
+		return
+	}
+	s.resend(from, resend, lost, short)
+}
@@ -0,0 +1,235 @@
+package udp_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"mangahub/internal/udp"
+	"mangahub/pkg/models"
+	"mangahub/pkg/notifyclient"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var multicastKey = []byte("test-multicast-key")
+
+// startMulticast runs a server publishing to a multicast group on loopback,
+// or skips the test where the host cannot do multicast
+func startMulticast(t *testing.T) (*udp.NotificationServer, string) {
+	t.Helper()
+	lo := loopback(t)
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// A port of our own, so parallel runs do not hear each other
+	probe, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	group := fmt.Sprintf("239.255.42.99:%d", port)
+	// A replay buffer longer than MaxNackRange, so the cap is what trims MNACKs
+	s := &udp.NotificationServer{
+		Conn:               conn,
+		ReplayBuffer:       1000,
+		MulticastGroup:     group,
+		MulticastKey:       multicastKey,
+		MulticastInterface: lo.Name,
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	return s, group
+}
+
+func loopback(t *testing.T) *net.Interface {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("no interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return &iface
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+// listen joins the group, registering with the server to MNACK gaps
+func listen(t *testing.T, s *udp.NotificationServer, group string, key []byte) *notifyclient.Client {
+	t.Helper()
+	c, err := notifyclient.ListenMulticast(group, loopback(t).Name, s.Conn.LocalAddr().String(), key)
+	if err != nil {
+		t.Skipf("cannot join %s on loopback: %v", group, err)
+	}
+	// The publisher starts in the background; register once it is up
+	var regErr error
+	for i := 0; i < 20; i++ {
+		if regErr = c.Register(); regErr == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if regErr != nil {
+		c.Close()
+		if strings.Contains(regErr.Error(), "multicast disabled") {
+			t.Skip("cannot publish multicast on loopback")
+		}
+		t.Fatal(regErr)
+	}
+	c.NACKDelay = 20 * time.Millisecond
+	return c
+}
+
+// deliveries runs the client and returns the titles it delivers
+func deliveries(t *testing.T, c *notifyclient.Client) <-chan string {
+	t.Helper()
+	got := make(chan string, 64)
+	go c.Run(func(seq uint64, n models.Notification) { got <- n.Title })
+	t.Cleanup(func() { c.Close() })
+	return got
+}
+
+func TestMulticastDeliversSignedPackets(t *testing.T) {
+	s, group := startMulticast(t)
+	got := deliveries(t, listen(t, s, group, multicastKey))
+
+	for i := 1; i <= 3; i++ {
+		s.Publish(models.Notification{Title: fmt.Sprintf("n%d", i)})
+	}
+	for i := 1; i <= 3; i++ {
+		select {
+		case title := <-got:
+			if want := fmt.Sprintf("n%d", i); title != want {
+				t.Fatalf("delivered %q, want %q", title, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Skip("no multicast delivery on loopback")
+		}
+	}
+}
+
+func TestMulticastDropsForgedPackets(t *testing.T) {
+	s, group := startMulticast(t)
+	c := listen(t, s, group, multicastKey)
+	got := deliveries(t, c)
+
+	// Someone else on the LAN sends to the group, signed with the wrong key
+	addr, _ := net.ResolveUDPAddr("udp4", group)
+	forged, _ := notifyclient.SignPacket([]byte("wrong"), models.NotificationPacket{
+		Seq: 1, Notification: models.Notification{Title: "forged"},
+	})
+	unsigned, _ := json.Marshal(models.NotificationPacket{Seq: 1, Notification: models.Notification{Title: "unsigned"}})
+	sender, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		t.Skipf("cannot send to %s: %v", group, err)
+	}
+	defer sender.Close()
+	sender.Write(forged)
+	sender.Write(unsigned)
+
+	s.Publish(models.Notification{Title: "real"})
+	select {
+	case title := <-got:
+		if title != "real" {
+			t.Fatalf("delivered %q", title)
+		}
+	case <-time.After(2 * time.Second):
+		t.Skip("no multicast delivery on loopback")
+	}
+}
+
+// mnack sends an MNACK from conn and returns the server's first reply
+func mnack(t *testing.T, conn net.PacketConn, server net.Addr) string {
+	t.Helper()
+	conn.WriteTo([]byte("MNACK 1 1000"), server)
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no reply to MNACK: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestMulticastNackNeedsRegistration(t *testing.T) {
+	s, _ := startMulticast(t)
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond) // Let the publisher start
+
+	if reply := mnack(t, conn, s.Conn.LocalAddr()); !strings.HasPrefix(reply, "ERROR") {
+		t.Fatalf("unregistered MNACK answered with %q", reply)
+	}
+}
+
+func TestMulticastNackIsCapped(t *testing.T) {
+	s, _ := startMulticast(t)
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	if reply := handshake(t, conn, s.Conn.LocalAddr(), "MREGISTER"); strings.Contains(reply, "multicast disabled") {
+		t.Skip("cannot publish multicast on loopback")
+	} else if !strings.HasPrefix(reply, "REGISTERED") {
+		t.Fatalf("MREGISTER answered with %q", reply)
+	}
+	for i := 0; i < udp.MaxNackRange*2; i++ {
+		s.Publish(models.Notification{Title: "n"})
+	}
+
+	// Padded to near the largest datagram, so only the range cap trims the reply
+	nack := "MNACK 1 1000"
+	conn.WriteTo([]byte(nack+strings.Repeat(" ", 4000-len(nack))), s.Conn.LocalAddr())
+	buf := make([]byte, 4096)
+	packets := 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if buf[0] == '{' {
+			packets++
+		} else {
+			t.Fatalf("unexpected reply %q", buf[:n])
+		}
+	}
+	if packets != udp.MaxNackRange {
+		t.Fatalf("MNACK answered with %d packets, want %d", packets, udp.MaxNackRange)
+	}
+
+	// Past the burst, MNACKs are ignored
+	answered := 0
+	for i := 0; i < udp.DefaultNackBurst*2; i++ {
+		conn.WriteTo([]byte(fmt.Sprintf("MNACK %d %d", udp.MaxNackRange*2, udp.MaxNackRange*2)), s.Conn.LocalAddr())
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			break
+		}
+		answered++
+	}
+	if answered >= udp.DefaultNackBurst*2 {
+		t.Fatalf("all %d MNACKs answered, want rate limiting", answered)
+	}
+	if s.Rejections()[udp.RejectRateLimited] == 0 {
+		t.Fatal("rate-limited MNACKs not counted")
+	}
+}
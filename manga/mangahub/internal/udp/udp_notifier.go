@@ -1,48 +1,698 @@
 package udp
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
-	socket "mangahub/internal/websocket"
+	"mangahub/internal/auth"
+	"mangahub/internal/notification"
+	"mangahub/pkg/models"
+	"mangahub/pkg/notifyclient"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-type NotificationServer struct {
-	Port string
-	Hub  *socket.Hub // Add reference to the Chat Hub
+// DefaultSubscriberTTL is how long a subscriber stays registered without a PING
+const DefaultSubscriberTTL = 60 * time.Second
+
+// DefaultReplayBuffer is how many recent packets are kept per subscriber for NACKs
+const DefaultReplayBuffer = 64
+
+// DefaultMaxSubscribers bounds the subscriber table. REGISTER needs no token,
+// so without a bound anyone could grow it without limit.
+const DefaultMaxSubscribers = 10000
+
+// MaxNackRange is the most packets one NACK or MNACK is answered with. Longer
+// ranges are trimmed; the client NACKs the rest once those arrive.
+const MaxNackRange = 64
+
+// NackAmplification caps the bytes a NACK or MNACK is answered with, as a
+// multiple of the NACK's own size, so a NACK sent from a forged address cannot
+// make the server flood that address. Clients pad their NACKs (see
+// notifyclient.NackSize) to get more than one packet back per request.
+const NackAmplification = 3
+
+// NACKs are rate-limited per subscriber, since each one is answered with up
+// to MaxNackRange packets
+const (
+	DefaultNackRate  = 20 // NACKs per second
+	DefaultNackBurst = 20
+)
+
+// DefaultMaxSkew is how far an announcement's timestamp may be from the server clock
+const DefaultMaxSkew = 30 * time.Second
+
+// Rejection reasons for incoming datagrams, see Rejections
+const (
+	RejectMalformed    = "malformed"
+	RejectUnsigned     = "unsigned"
+	RejectBadSignature = "bad_signature"
+	RejectStale        = "stale"
+	RejectReplayed     = "replayed"
+	RejectFull         = "subscribers_full"
+	RejectBadType      = "bad_type"
+	RejectRateLimited  = "rate_limited"
+	RejectBadCookie    = "bad_cookie"
+	RejectShortNack    = "short_nack"
+)
+
+// announcementTypes are the notification types admins may announce
+var announcementTypes = map[string]bool{
+	notification.TypeSystem:     true,
+	notification.TypeNewManga:   true,
+	notification.TypeNewChapter: true,
 }
 
-func (s *NotificationServer) Start() {
-	addr, err := net.ResolveUDPAddr("udp", ":"+s.Port)
-	if err != nil {
-		fmt.Println("UDP Resolve Error:", err)
-		return
+// Datagrams starting with '{' are signed admin announcements (see
+// notifyclient.Announcement). Everything else must be one of these commands:
+//
+//	REGISTER [jwt]    -> COOKIE <cookie>
+//	REGISTER <cookie> [jwt]
+//	                  -> REGISTERED <ttl-seconds> <last-seq>
+//	MREGISTER         -> COOKIE <cookie>
+//	MREGISTER <cookie>
+//	                  -> REGISTERED <ttl-seconds> <last-group-seq>, for a
+//	                     multicast listener that wants to MNACK; it gets no
+//	                     unicast copies and its PONGs carry the group sequence
+//	PING              -> PONG <last-seq>
+//	NACK <from> <to>  -> the missing packets again, and LOST <from> <to> for
+//	                     any that already fell out of the replay buffer
+//	MNACK <from> <to> -> same as NACK, for the multicast group's sequence
+//	UNREGISTER        -> UNREGISTERED
+//
+// Registering takes two round trips: the subscription only starts once the
+// cookie comes back from the address it was sent to (see cookie.go).
+// NACK and MNACK need a registration of the matching kind, are answered with
+// at most MaxNackRange packets and NackAmplification times their own size,
+// and are rate-limited per subscriber.
+const (
+	cmdRegister   = "REGISTER"
+	cmdMRegister  = "MREGISTER"
+	cmdPing       = "PING"
+	cmdNack       = "NACK"
+	cmdMNack      = "MNACK"
+	cmdUnregister = "UNREGISTER"
+)
+
+type sentPacket struct {
+	seq     uint64
+	payload []byte
+}
+
+// stream is one sequenced packet stream (a unicast subscriber or the
+// multicast group) together with its replay buffer
+type stream struct {
+	seq    uint64       // Last sequence number sent
+	replay []sentPacket // Most recent packets, oldest first
+	key    []byte       // Signs the packets when set (multicast)
+}
+
+// stamp assigns the next sequence number, encodes the packet and remembers it
+func (st *stream) stamp(n models.Notification, keep int) ([]byte, error) {
+	pkt := models.NotificationPacket{Seq: st.seq + 1, Notification: n}
+	var payload []byte
+	var err error
+	if st.key != nil {
+		payload, err = notifyclient.SignPacket(st.key, pkt)
+	} else {
+		payload, err = json.Marshal(pkt)
 	}
-	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
+		return nil, err
+	}
+	st.seq++
+	st.replay = append(st.replay, sentPacket{seq: st.seq, payload: payload})
+	if len(st.replay) > keep {
+		st.replay = st.replay[len(st.replay)-keep:]
+	}
+	return payload, nil
+}
+
+// lookup returns the buffered packets in [first, last], as many as fit in
+// budget bytes, and, if part of the range already fell out of the buffer,
+// the LOST reply for it. short reports that packets were left out for lack
+// of budget.
+func (st *stream) lookup(first, last uint64, budget int) (resend [][]byte, lost string, short bool) {
+	if last-first >= MaxNackRange {
+		last = first + MaxNackRange - 1
+	}
+	if last > st.seq {
+		last = st.seq
+	}
+	oldest := st.seq + 1
+	for _, p := range st.replay {
+		if p.seq < oldest {
+			oldest = p.seq
+		}
+		if p.seq >= first && p.seq <= last && !short {
+			if len(p.payload) > budget {
+				short = true
+				continue
+			}
+			budget -= len(p.payload)
+			resend = append(resend, p.payload)
+		}
+	}
+	if first < oldest {
+		lostTo := last
+		if oldest-1 < lostTo {
+			lostTo = oldest - 1
+		}
+		lost = fmt.Sprintf("LOST %d %d", first, lostTo)
+	}
+	return resend, lost, short
+}
+
+type subscriber struct {
+	Addr      net.Addr
+	UserID    string // Empty for anonymous subscribers
+	LastSeen  time.Time
+	Multicast bool // Registered with MREGISTER: MNACKs only, no unicast packets
+
+	nackTokens float64 // NACK rate limit bucket
+	nackAt     time.Time
+
+	stream
+}
+
+// allowNack takes a token from the subscriber's NACK bucket
+func (sub *subscriber) allowNack(now time.Time) bool {
+	if sub.nackAt.IsZero() {
+		sub.nackTokens = DefaultNackBurst
+	} else {
+		sub.nackTokens += now.Sub(sub.nackAt).Seconds() * DefaultNackRate
+		if sub.nackTokens > DefaultNackBurst {
+			sub.nackTokens = DefaultNackBurst
+		}
+	}
+	sub.nackAt = now
+	if sub.nackTokens < 1 {
+		return false
+	}
+	sub.nackTokens--
+	return true
+}
+
+type NotificationServer struct {
+	Port           string
+	Notifications  *notification.Service // Inbox + /ws/notifications delivery
+	SubscriberTTL  time.Duration         // Defaults to DefaultSubscriberTTL
+	ReplayBuffer   int                   // Defaults to DefaultReplayBuffer
+	MaxSubscribers int                   // Defaults to DefaultMaxSubscribers
+
+	// Conn is optional; when nil, Start listens on Port. Tests can inject a
+	// lossy or reordering net.PacketConn here.
+	Conn net.PacketConn
+
+	// SigningKey is the HMAC key shared with admin senders. Without it every
+	// announcement is rejected.
+	SigningKey []byte
+	MaxSkew    time.Duration // Defaults to DefaultMaxSkew
+
+	// Optional LAN delivery: announcements are also sent once to this
+	// multicast group (e.g. "239.255.42.99:12346"), in addition to unicast.
+	// Group packets are signed with MulticastKey, which listeners need to
+	// verify them; multicast stays off without it.
+	MulticastGroup     string
+	MulticastKey       []byte
+	MulticastTTL       int    // Defaults to DefaultMulticastTTL
+	MulticastInterface string // e.g. "eth0"; empty uses the system default
+
+	cookieKey []byte // Signs registration cookies; random per process
+
+	mu          sync.Mutex
+	subscribers map[string]*subscriber // Keyed by addr.String()
+	seenNonces  map[string]time.Time   // Nonce -> when it can be forgotten
+	rejections  map[string]uint64
+	mcast       *multicastPublisher
+	listening   bool
+}
+
+// Listen opens the socket and fills in the defaults. Call it before the
+// server is shared with other goroutines, e.g. registered as a notification
+// channel: the fields it sets are read without the lock afterwards. Start
+// calls it if it has not been called.
+func (s *NotificationServer) Listen() error {
+	if s.listening {
+		return nil
+	}
+	if s.Conn == nil {
+		conn, err := net.ListenPacket("udp", ":"+s.Port)
+		if err != nil {
+			return err
+		}
+		s.Conn = conn
+	}
+	if s.SubscriberTTL == 0 {
+		s.SubscriberTTL = DefaultSubscriberTTL
+	}
+	if s.ReplayBuffer == 0 {
+		s.ReplayBuffer = DefaultReplayBuffer
+	}
+	if s.MaxSubscribers == 0 {
+		s.MaxSubscribers = DefaultMaxSubscribers
+	}
+	if s.MaxSkew == 0 {
+		s.MaxSkew = DefaultMaxSkew
+	}
+	s.cookieKey = make([]byte, 32)
+	if _, err := rand.Read(s.cookieKey); err != nil {
+		return err
+	}
+	s.subscribers = make(map[string]*subscriber)
+	s.seenNonces = make(map[string]time.Time)
+	s.rejections = make(map[string]uint64)
+	s.listening = true
+	return nil
+}
+
+func (s *NotificationServer) Start() {
+	if err := s.Listen(); err != nil {
 		fmt.Println("UDP Listen Error:", err)
 		return
 	}
-	fmt.Println("📣 UDP Notification Server listening on port", s.Port)
+	fmt.Println("📣 UDP Notification Server listening on", s.Conn.LocalAddr())
+
+	if s.MulticastGroup != "" {
+		if err := s.startMulticast(); err != nil {
+			fmt.Println("UDP Multicast Error:", err)
+		}
+	}
+
+	go s.expireSubscribers()
 
-	buf := make([]byte, 1024)
+	buf := make([]byte, 4096)
 	for {
-		n, _, err := conn.ReadFromUDP(buf)
+		n, from, err := s.Conn.ReadFrom(buf)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Println("UDP Read Error:", err)
 			continue
 		}
 
-		// Convert UDP byte data to string message
-		receivedMsg := string(buf[:n])
-		fmt.Printf("☁️ UDP Received: %s\n", receivedMsg)
+		if n > 0 && buf[0] == '{' {
+			s.handleAnnouncement(buf[:n], from)
+			continue
+		}
+
+		receivedMsg := strings.TrimSpace(string(buf[:n]))
+		fields := strings.Fields(receivedMsg)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case cmdRegister:
+			s.register(from, fields[1:])
+		case cmdMRegister:
+			s.registerMulticast(from, fields[1:])
+		case cmdPing:
+			s.ping(from)
+		case cmdNack:
+			s.nack(from, fields[1:], n)
+		case cmdMNack:
+			s.multicastNack(from, fields[1:], n)
+		case cmdUnregister:
+			s.unregister(from)
+		default:
+			s.reject(from, RejectMalformed)
+		}
+	}
+}
+
+func (s *NotificationServer) register(from net.Addr, args []string) {
+	if !s.checkCookie(cmdRegister, from, &args) {
+		return
+	}
+	userID := ""
+	if len(args) > 0 {
+		claims, err := auth.ParseToken(args[0])
+		if err != nil {
+			s.reply(from, "ERROR invalid token")
+			return
+		}
+		userID = fmt.Sprintf("%v", claims["user_id"])
+	}
+
+	s.mu.Lock()
+	sub := s.subscriber(from)
+	if sub == nil {
+		s.mu.Unlock()
+		s.reply(from, "ERROR too many subscribers, try again later")
+		return
+	}
+	sub.UserID = userID
+	sub.Multicast = false
+	sub.LastSeen = time.Now()
+	lastSeq := sub.seq
+	s.mu.Unlock()
+
+	fmt.Printf("📡 UDP subscriber registered: %s (user %q)\n", from, userID)
+	s.reply(from, fmt.Sprintf("REGISTERED %d %d", int(s.SubscriberTTL.Seconds()), lastSeq))
+}
+
+// registerMulticast lets a multicast listener MNACK the gaps it sees
+func (s *NotificationServer) registerMulticast(from net.Addr, args []string) {
+	s.mu.Lock()
+	enabled := s.mcast != nil
+	s.mu.Unlock()
+	if !enabled {
+		s.reply(from, "ERROR multicast disabled")
+		return
+	}
+	if !s.checkCookie(cmdMRegister, from, &args) {
+		return
+	}
+
+	s.mu.Lock()
+	sub := s.subscriber(from)
+	if sub == nil {
+		s.mu.Unlock()
+		s.reply(from, "ERROR too many subscribers, try again later")
+		return
+	}
+	sub.UserID = ""
+	sub.Multicast = true
+	sub.LastSeen = time.Now()
+	lastSeq := s.mcast.seq
+	s.mu.Unlock()
+
+	fmt.Printf("📡 UDP multicast listener registered: %s\n", from)
+	s.reply(from, fmt.Sprintf("REGISTERED %d %d", int(s.SubscriberTTL.Seconds()), lastSeq))
+}
+
+// checkCookie takes the cookie off the front of a registration's arguments.
+// Without a valid one it answers with a fresh cookie and reports false: the
+// client registers again with it, from the address it was sent to.
+func (s *NotificationServer) checkCookie(cmd string, from net.Addr, args *[]string) bool {
+	now := time.Now()
+	if len(*args) > 0 && isCookie((*args)[0]) {
+		cookie := (*args)[0]
+		*args = (*args)[1:]
+		if s.validCookie(cmd, from, cookie, now) {
+			return true
+		}
+		s.mu.Lock()
+		s.rejections[RejectBadCookie]++
+		s.mu.Unlock()
+	}
+	s.reply(from, "COOKIE "+s.cookie(cmd, from, now))
+	return false
+}
+
+// subscriber returns the subscriber at from, adding it if there is room.
+// Re-registering from the same address keeps the sequence and replay
+// buffer, so a client recovering from expiry can still NACK what it missed.
+// The caller must hold s.mu.
+func (s *NotificationServer) subscriber(from net.Addr) *subscriber {
+	sub, ok := s.subscribers[from.String()]
+	if !ok {
+		if len(s.subscribers) >= s.MaxSubscribers {
+			s.rejections[RejectFull]++
+			return nil
+		}
+		sub = &subscriber{Addr: from}
+		s.subscribers[from.String()] = sub
+	}
+	return sub
+}
+
+func (s *NotificationServer) ping(from net.Addr) {
+	s.mu.Lock()
+	sub, ok := s.subscribers[from.String()]
+	var lastSeq uint64
+	if ok {
+		sub.LastSeen = time.Now()
+		lastSeq = sub.seq
+		if sub.Multicast && s.mcast != nil {
+			lastSeq = s.mcast.seq
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		// Expired or never registered: tell the client to REGISTER again
+		s.reply(from, "ERROR not registered")
+		return
+	}
+	// The last sequence lets clients notice lost packets at the tail
+	s.reply(from, fmt.Sprintf("PONG %d", lastSeq))
+}
+
+// parseRange reads the <from> <to> arguments of NACK and MNACK
+func parseRange(args []string) (first, last uint64, ok bool) {
+	if len(args) != 2 {
+		return 0, 0, false
+	}
+	first, err1 := strconv.ParseUint(args[0], 10, 64)
+	last, err2 := strconv.ParseUint(args[1], 10, 64)
+	if err1 != nil || err2 != nil || first > last {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// nack retransmits a range of packets the subscriber reported missing. size
+// is the NACK datagram's length, which bounds the reply.
+func (s *NotificationServer) nack(from net.Addr, args []string, size int) {
+	first, last, valid := parseRange(args)
+	if !valid {
+		s.reply(from, "ERROR usage: NACK <from> <to>")
+		return
+	}
+
+	s.mu.Lock()
+	sub, ok := s.nackingSubscriber(from, false)
+	var resend [][]byte
+	var lost string
+	var short bool
+	if sub != nil {
+		resend, lost, short = sub.lookup(first, last, size*NackAmplification)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.reply(from, "ERROR not registered")
+		return
+	}
+	s.resend(from, resend, lost, short)
+}
+
+// nackingSubscriber finds the subscriber a NACK (or, for multicast, an MNACK)
+// came from. ok is false if there is no such registration; sub is also nil if
+// the NACK is over the rate limit and should be ignored. The caller must
+// hold s.mu.
+func (s *NotificationServer) nackingSubscriber(from net.Addr, multicast bool) (sub *subscriber, ok bool) {
+	sub, ok = s.subscribers[from.String()]
+	if !ok || sub.Multicast != multicast {
+		return nil, false
+	}
+	now := time.Now()
+	sub.LastSeen = now
+	if !sub.allowNack(now) {
+		s.rejections[RejectRateLimited]++
+		return nil, true
+	}
+	return sub, true
+}
+
+// resend answers a NACK: LOST first for packets that are gone for good, so
+// the client stops waiting for them, then the ones still buffered. A NACK
+// too small to carry even the first missing packet back is refused, so the
+// client does not wait for nothing.
+func (s *NotificationServer) resend(to net.Addr, packets [][]byte, lost string, short bool) {
+	if lost != "" {
+		s.reply(to, lost)
+	}
+	if short && len(packets) == 0 {
+		s.reject(to, RejectShortNack)
+		s.reply(to, "ERROR NACK too short")
+		return
+	}
+	for _, payload := range packets {
+		s.Conn.WriteTo(payload, to)
+	}
+}
+
+func (s *NotificationServer) unregister(from net.Addr) {
+	s.mu.Lock()
+	delete(s.subscribers, from.String())
+	s.mu.Unlock()
+	s.reply(from, "UNREGISTERED")
+}
+
+// handleAnnouncement verifies a signed admin announcement and publishes it
+func (s *NotificationServer) handleAnnouncement(data []byte, from net.Addr) {
+	var a notifyclient.Announcement
+	if err := json.Unmarshal(data, &a); err != nil || a.Title == "" {
+		s.reject(from, RejectMalformed)
+		return
+	}
+	if len(s.SigningKey) == 0 {
+		s.reject(from, RejectUnsigned)
+		return
+	}
+	switch err := a.Verify(s.SigningKey); err {
+	case nil:
+	case notifyclient.ErrUnsigned:
+		s.reject(from, RejectUnsigned)
+		return
+	default:
+		s.reject(from, RejectBadSignature)
+		return
+	}
+
+	sent := time.Unix(a.Timestamp, 0)
+	if time.Since(sent) > s.MaxSkew || time.Until(sent) > s.MaxSkew {
+		s.reject(from, RejectStale)
+		return
+	}
+
+	// A valid nonce is only accepted once while its timestamp is fresh
+	s.mu.Lock()
+	_, replayed := s.seenNonces[a.Nonce]
+	if !replayed {
+		s.seenNonces[a.Nonce] = sent.Add(s.MaxSkew)
+	}
+	s.mu.Unlock()
+	if replayed {
+		s.reject(from, RejectReplayed)
+		return
+	}
+
+	if a.Type == "" {
+		a.Type = notification.TypeSystem
+	}
+	if !announcementTypes[a.Type] {
+		s.reject(from, RejectBadType)
+		return
+	}
+	s.announce(models.Notification{
+		Type:      a.Type,
+		Title:     a.Title,
+		Body:      a.Payload,
+		CreatedAt: a.Timestamp,
+	})
+}
+
+func (s *NotificationServer) reject(from net.Addr, reason string) {
+	s.mu.Lock()
+	s.rejections[reason]++
+	s.mu.Unlock()
+	fmt.Printf("🚫 UDP packet from %s rejected: %s\n", from, reason)
+}
+
+// Rejections returns how many datagrams were refused, by reason
+func (s *NotificationServer) Rejections() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]uint64, len(s.rejections))
+	for reason, n := range s.rejections {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// announce publishes a verified admin announcement
+func (s *NotificationServer) announce(n models.Notification) {
+	fmt.Printf("☁️ UDP Announcement: [%s] %s\n", n.Type, n.Title)
+
+	// Store for every user; users who picked the "udp" channel get it through
+	// Deliver below
+	if s.Notifications != nil {
+		s.Notifications.Broadcast(n)
+	}
+
+	// Anonymous subscribers have no preferences, so they get every announcement
+	s.fanOut(n, func(sub *subscriber) bool { return sub.UserID == "" })
+	s.multicast(n)
+}
+
+// Publish sends the notification to every registered subscriber and the multicast group
+func (s *NotificationServer) Publish(n models.Notification) int {
+	s.multicast(n)
+	return s.fanOut(n, func(*subscriber) bool { return true })
+}
+
+// SendToUser sends the notification to every endpoint registered with the user's token
+func (s *NotificationServer) SendToUser(userID string, n models.Notification) int {
+	return s.fanOut(n, func(sub *subscriber) bool { return sub.UserID == userID })
+}
+
+// Deliver makes the server usable as the notification "udp" channel
+func (s *NotificationServer) Deliver(prefs models.NotificationPrefs, n models.Notification) error {
+	if s.SendToUser(prefs.UserID, n) == 0 {
+		return fmt.Errorf("no UDP endpoint registered")
+	}
+	return nil
+}
+
+// fanOut stamps the notification with each matching subscriber's next
+// sequence number, records it for NACKs and sends it
+func (s *NotificationServer) fanOut(n models.Notification, match func(*subscriber) bool) int {
+	type outgoing struct {
+		addr    net.Addr
+		payload []byte
+	}
+	var batch []outgoing
+
+	s.mu.Lock()
+	for _, sub := range s.subscribers {
+		if sub.Multicast || !match(sub) {
+			continue
+		}
+		payload, err := sub.stamp(n, s.ReplayBuffer)
+		if err != nil {
+			continue
+		}
+		batch = append(batch, outgoing{addr: sub.Addr, payload: payload})
+	}
+	s.mu.Unlock()
+
+	if s.Conn == nil {
+		return 0
+	}
+	sent := 0
+	for _, out := range batch {
+		if _, err := s.Conn.WriteTo(out.payload, out.addr); err != nil {
+			fmt.Printf("⚠️ UDP send to %s failed: %v\n", out.addr, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+func (s *NotificationServer) reply(to net.Addr, msg string) {
+	s.Conn.WriteTo([]byte(msg), to)
+}
 
-		// BROADCAST TO WEBSOCKET USERS
-		// We send this to the Hub so it appears in the browser chat
-		if s.Hub != nil {
-			s.Hub.Broadcast <- socket.ChatMessage{
-				Username: "SYSTEM-BROADCAST",
-				Message:  receivedMsg,
+// expireSubscribers drops endpoints that stopped sending PINGs, and nonces
+// old enough that their announcements would be rejected as stale anyway
+func (s *NotificationServer) expireSubscribers() {
+	ticker := time.NewTicker(s.SubscriberTTL / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		cutoff := now.Add(-s.SubscriberTTL)
+		s.mu.Lock()
+		for key, sub := range s.subscribers {
+			if sub.LastSeen.Before(cutoff) {
+				delete(s.subscribers, key)
+				fmt.Printf("⌛ UDP subscriber expired: %s\n", key)
+			}
+		}
+		for nonce, forgetAt := range s.seenNonces {
+			if now.After(forgetAt) {
+				delete(s.seenNonces, nonce)
 			}
 		}
+		s.mu.Unlock()
 	}
 }
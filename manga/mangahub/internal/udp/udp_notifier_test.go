@@ -0,0 +1,133 @@
+package udp_test
+
+import (
+	"mangahub/internal/udp"
+	"mangahub/pkg/models"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startUnicast runs a server without multicast on a loopback port
+func startUnicast(t *testing.T) *udp.NotificationServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &udp.NotificationServer{Conn: conn}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	return s
+}
+
+func socket(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// ask sends msg to the server and returns its first reply
+func ask(t *testing.T, conn net.PacketConn, server net.Addr, msg string) string {
+	t.Helper()
+	conn.WriteTo([]byte(msg), server)
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no reply to %q: %v", msg, err)
+	}
+	return string(buf[:n])
+}
+
+// handshake registers conn with cmd (REGISTER or MREGISTER), echoing the
+// server's cookie, and returns the final reply
+func handshake(t *testing.T, conn net.PacketConn, server net.Addr, cmd string) string {
+	t.Helper()
+	reply := ask(t, conn, server, cmd)
+	cookie, ok := strings.CutPrefix(reply, "COOKIE ")
+	if !ok {
+		return reply
+	}
+	return ask(t, conn, server, cmd+" "+cookie)
+}
+
+func TestRegisterNeedsCookieFromSameAddress(t *testing.T) {
+	s := startUnicast(t)
+	victim, attacker := socket(t), socket(t)
+
+	// The attacker can only see cookies sent to its own address
+	reply := ask(t, attacker, s.Conn.LocalAddr(), "REGISTER")
+	cookie, ok := strings.CutPrefix(reply, "COOKIE ")
+	if !ok {
+		t.Fatalf("REGISTER answered with %q", reply)
+	}
+	if n := s.Publish(models.Notification{Title: "n"}); n != 0 {
+		t.Fatalf("published to %d subscribers before any cookie came back", n)
+	}
+	// ...so a cookie replayed for the victim's address is refused
+	if reply := ask(t, victim, s.Conn.LocalAddr(), "REGISTER "+cookie); !strings.HasPrefix(reply, "COOKIE ") {
+		t.Fatalf("borrowed cookie answered with %q", reply)
+	}
+	if n := s.Publish(models.Notification{Title: "n"}); n != 0 {
+		t.Fatalf("published to %d subscribers after a borrowed cookie", n)
+	}
+	if s.Rejections()[udp.RejectBadCookie] != 1 {
+		t.Fatalf("rejections %v", s.Rejections())
+	}
+
+	if reply := ask(t, attacker, s.Conn.LocalAddr(), "REGISTER "+cookie); !strings.HasPrefix(reply, "REGISTERED ") {
+		t.Fatalf("own cookie answered with %q", reply)
+	}
+	if n := s.Publish(models.Notification{Title: "n"}); n != 1 {
+		t.Fatalf("published to %d subscribers, want 1", n)
+	}
+}
+
+func TestNackReplyIsBoundedBySize(t *testing.T) {
+	s := startUnicast(t)
+	conn := socket(t)
+	if reply := handshake(t, conn, s.Conn.LocalAddr(), "REGISTER"); !strings.HasPrefix(reply, "REGISTERED ") {
+		t.Fatalf("REGISTER answered with %q", reply)
+	}
+	const published = 10
+	for i := 0; i < published; i++ {
+		s.Publish(models.Notification{Title: "n", Body: strings.Repeat("x", 500)})
+	}
+	buf := make([]byte, 4096)
+	for i := 0; i < published; i++ {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reply := ask(t, conn, s.Conn.LocalAddr(), "NACK 1 10"); reply != "ERROR NACK too short" {
+		t.Fatalf("unpadded NACK answered with %q", reply)
+	}
+
+	nack := "NACK 1 10"
+	nack += strings.Repeat(" ", 1200-len(nack))
+	conn.WriteTo([]byte(nack), s.Conn.LocalAddr())
+	packets, bytes := 0, 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		packets++
+		bytes += n
+	}
+	if packets == 0 || packets >= published || bytes > udp.NackAmplification*len(nack) {
+		t.Fatalf("%d-byte NACK answered with %d packets, %d bytes", len(nack), packets, bytes)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"mangahub/pkg/models"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -45,6 +48,21 @@ func (uc *UserController) AddToLibrary(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Library updated"})
 }
 
+// chapterPattern matches chapters as readers write them: "12", "12.5",
+// "Ch 5", "ch.5" or "Chapter 7"
+var chapterPattern = regexp.MustCompile(`(?i)^(?:ch(?:apter)?\.?\s*)?(\d+)(?:\.\d+)?$`)
+
+// parseChapter returns the whole chapter a progress update reaches. Extras
+// such as 12.5 count as 12: spoiler protection compares whole chapters.
+func parseChapter(s string) (int, bool) {
+	m := chapterPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	chapter, err := strconv.Atoi(m[1])
+	return chapter, err == nil
+}
+
 // UpdateProgress handles the PUT request and triggers the TCP broadcast
 func (uc *UserController) UpdateProgress(c *gin.Context) {
 	var input models.ProgressUpdate
@@ -53,9 +71,28 @@ func (uc *UserController) UpdateProgress(c *gin.Context) {
 		return
 	}
 
+	// Chat spoiler protection reads current_chapter, so it is stored here
+	// rather than left to the sync server
+	chapter, ok := parseChapter(input.Chapter)
+	if !ok || input.MangaID == "" {
+		c.JSON(400, gin.H{"error": "manga_id and a chapter number are required"})
+		return
+	}
+	userID, _ := c.Get("user_id")
+	_, err := uc.DB.Exec(`
+		INSERT INTO user_progress (user_id, manga_id, current_chapter, status)
+		VALUES (?, ?, ?, 'reading')
+		ON CONFLICT(user_id, manga_id) DO UPDATE SET current_chapter=excluded.current_chapter`,
+		fmt.Sprintf("%v", userID), input.MangaID, chapter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update progress"})
+		return
+	}
+
 	// Ensure we are assigning to Chapter
 	uname, _ := c.Get("username")
 	input.Username = fmt.Sprintf("%v", uname)
+	input.Token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
 
 	// Dial TCP and send...
 	conn, err := net.Dial("tcp", uc.TCPServerAddr)
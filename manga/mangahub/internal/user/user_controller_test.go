@@ -0,0 +1,25 @@
+package user
+
+import "testing"
+
+func TestParseChapter(t *testing.T) {
+	for in, want := range map[string]int{
+		"12":         12,
+		" 7 ":        7,
+		"12.5":       12,
+		"Ch 5":       5,
+		"ch.5":       5,
+		"Chapter 7":  7,
+		"chapter100": 100,
+		"0":          0,
+	} {
+		if got, ok := parseChapter(in); !ok || got != want {
+			t.Errorf("parseChapter(%q) = %d, %v; want %d", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "-3", "abc", "12.", "1e3", "Ch", "99999999999999999999"} {
+		if got, ok := parseChapter(in); ok {
+			t.Errorf("parseChapter(%q) = %d, want refused", in, got)
+		}
+	}
+}
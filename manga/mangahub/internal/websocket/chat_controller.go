@@ -0,0 +1,216 @@
+package socket
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChatController struct {
+	Hub *Hub
+}
+
+// GET /chat/rooms
+// Active rooms and how many people are in each.
+func (cc *ChatController) ListRooms(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"rooms": cc.Hub.ListRooms()})
+}
+
+// GET /chat/presence?room=manga:one-piece
+// Signed-in users online on any instance in the rooms the caller is in,
+// optionally only those in one of them.
+func (cc *ChatController) Presence(c *gin.Context) {
+	room := c.Query("room")
+	if room != "" && !ValidRoom(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown room name"})
+		return
+	}
+	online, ok := cc.Hub.Online(fmt.Sprintf("%v", c.MustGet("user_id")), room)
+	if !ok && room == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Join a chat room to see who is online"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Join the room in chat to see who is in it"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"online": online, "count": len(online)})
+}
+
+// GET /chat/rooms/:id/messages?before=<id>&limit=50
+// Scrollback, oldest first. Pass next_before as before to load the page above.
+// Spoilers beyond the caller's progress are masked; anonymous callers see
+// every chapter-tagged message masked. Who may read a room is decided as
+// for joining it: not guests where the room is closed to them, and not
+// users banned from it.
+func (cc *ChatController) Messages(c *gin.Context) {
+	room := c.Param("id")
+	if !ValidRoom(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown room name"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	before, _ := strconv.ParseInt(c.Query("before"), 10, 64)
+
+	userID := GuestID
+	if id, ok := c.Get("user_id"); ok {
+		userID = fmt.Sprintf("%v", id)
+	}
+	if sys := cc.Hub.ReadRefusal(userID, room); sys != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": sys.Text, "code": sys.Code})
+		return
+	}
+	messages, err := cc.Hub.Store.Recent(room, before, limit)
+	if err == nil {
+		err = cc.Hub.Store.MaskSpoilers(room, userID, messages)
+	}
+	if err != nil {
+		log.Printf("Chat history error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		return
+	}
+
+	var next interface{}
+	if len(messages) == limit {
+		next = messages[0].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "messages": messages, "next_before": next})
+}
+
+// GET /chat/conversations
+// The caller's direct message conversations, most recent first.
+func (cc *ChatController) Conversations(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	list, err := cc.Hub.Store.Conversations(userID, 50)
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load conversations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": list})
+}
+
+// GET /chat/conversations/:user_id/messages?before=<id>&limit=50
+// Direct messages with one user, oldest first, paged like room scrollback.
+func (cc *ChatController) DirectMessages(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+	other := c.Param("user_id")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	before, _ := strconv.ParseInt(c.Query("before"), 10, 64)
+
+	messages, err := cc.Hub.Store.DirectHistory(userID, other, before, limit)
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		return
+	}
+
+	var next interface{}
+	if len(messages) == limit {
+		next = messages[0].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": other, "messages": messages, "next_before": next})
+}
+
+// POST /chat/conversations/:user_id/read
+// Marks the other user's messages read, up to "up_to" if given. The sender
+// gets a read receipt over the socket.
+func (cc *ChatController) MarkRead(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	var input struct {
+		UpTo int64 `json:"up_to"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	receipt, err := cc.Hub.Store.MarkRead(userID, c.Param("user_id"), input.UpTo)
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages read"})
+		return
+	}
+	if receipt == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Nothing unread"})
+		return
+	}
+	cc.Hub.SendReceipt(receipt)
+	c.JSON(http.StatusOK, receipt)
+}
+
+// GET /users/blocks
+func (cc *ChatController) ListBlocks(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	list, err := cc.Hub.Store.Blocks(userID)
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load blocks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blocks": list})
+}
+
+// POST /users/blocks
+// Blocking stops direct messages both ways.
+func (cc *ChatController) Block(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	var input struct {
+		UserID string `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot block yourself"})
+		return
+	}
+	name, err := cc.Hub.Store.Username(input.UserID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err == nil {
+		err = cc.Hub.Store.Block(userID, input.UserID)
+	}
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Blocked " + name})
+}
+
+// DELETE /users/blocks/:user_id
+func (cc *ChatController) Unblock(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	found, err := cc.Hub.Store.Unblock(userID, c.Param("user_id"))
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not blocked"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unblocked"})
+}
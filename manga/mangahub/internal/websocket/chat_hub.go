@@ -1,58 +1,616 @@
 package socket
 
 import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mangahub/internal/backplane"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-// Client represents a single chat participant
-type Client struct {
-	Conn     *websocket.Conn
-	UserID   string
-	Username string
+// DefaultRoom is the room every client starts in
+const DefaultRoom = "general"
+
+// MaxRoomsPerClient bounds how many rooms one connection can sit in
+const MaxRoomsPerClient = 20
+
+// StoreQueue bounds the database work waiting for the store worker. Past
+// it, frames that need the database are refused as busy.
+const StoreQueue = 1024
+
+// Room names are "general", "manga:<id>" or "topic:<name>"
+var roomName = regexp.MustCompile(`^(general|manga:[a-zA-Z0-9_-]{1,64}|topic:[a-z0-9-]{1,32})$`)
+
+// ValidRoom reports whether name is an allowed room name
+func ValidRoom(name string) bool {
+	return roomName.MatchString(name)
+}
+
+// MangaRoom is the room for discussing one manga
+func MangaRoom(mangaID string) string {
+	return "manga:" + mangaID
+}
+
+// RoomInfo is one entry of GET /chat/rooms
+type RoomInfo struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
 }
 
-// ChatMessage represents the JSON structure for messages
-type ChatMessage struct {
-	UserID    string `json:"user_id"`
-	Username  string `json:"username"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
+type clientFrame struct {
+	client  *Client
+	frame   ClientFrame
+	invalid bool // The frame could not be decoded
 }
 
+// userFrame is an encoded frame for some users' connections on other instances
+type userFrame struct {
+	UserIDs []string        `json:"user_ids"`
+	Frame   json.RawMessage `json:"frame"`
+}
+
+// Hub tracks the chat clients of this instance. With a Backplane set, room
+// traffic and presence are shared with the other instances.
 type Hub struct {
+	ID         string // Identifies this instance on the backplane
+	Backplane  backplane.Backplane
+	Store      *MessageStore
+	Filters    []Filter // Applied in order to every room and direct message
 	Clients    map[*Client]bool
-	Broadcast  chan ChatMessage
+	Rooms      map[string]map[*Client]bool
+	Broadcast  chan Envelope // Server-originated frames, "system" by default; Room picks the audience
 	Register   chan *Client
 	Unregister chan *Client
+	incoming   chan clientFrame
+	queries    chan func()
+	jobs       chan func() func() // For the store worker; see persist
+
+	// Per-user message and per-connection frame limits (token buckets); a
+	// rate of 0 turns a limit off. Set them before Run.
+	MessageRate     float64
+	MessageBurst    int
+	FrameRate       float64
+	FrameBurst      int
+	RoomChangeRate  float64 // Joins and leaves, per connection
+	RoomChangeBurst int
+
+	// MaxConnections caps each signed-in user's chat connections to this
+	// instance; 0 turns the cap off. Guests share one ID and are not capped.
+	MaxConnections int
+
+	users         map[string]*userPresence    // Signed-in users, by user ID
+	remote        map[string]presenceSnapshot // By instance ID
+	pending       []Envelope                  // Announcements held back until the current broadcast is done
+	settings      map[string]RoomSettings     // Rooms with non-default settings
+	sanctions     map[sanctionKey]int64       // Mutes and bans in force, to when they end
+	lastPost      map[string]time.Time        // For slow mode, by user ID and room
+	buckets       map[string]*tokenBucket     // Message rate limits, by user ID
+	presenceDirty bool
+	presenceSent  time.Time
 }
 
-func NewChatHub() *Hub {
-	return &Hub{
+// NewChatHub creates a hub that stores messages in db; with a nil db chat
+// is kept in memory only (no history)
+func NewChatHub(db *sql.DB) *Hub {
+	h := &Hub{
+		ID:         uuid.NewString(),
 		Clients:    make(map[*Client]bool),
-		Broadcast:  make(chan ChatMessage),
+		Rooms:      make(map[string]map[*Client]bool),
+		Broadcast:  make(chan Envelope),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
+		incoming:   make(chan clientFrame),
+		queries:    make(chan func()),
+		jobs:       make(chan func() func(), StoreQueue),
+		users:      make(map[string]*userPresence),
+		remote:     make(map[string]presenceSnapshot),
+		settings:   make(map[string]RoomSettings),
+		sanctions:  make(map[sanctionKey]int64),
+		lastPost:   make(map[string]time.Time),
+		buckets:    make(map[string]*tokenBucket),
+
+		MessageRate:  DefaultMessageRate,
+		MessageBurst: DefaultMessageBurst,
+		FrameRate:    DefaultFrameRate,
+		FrameBurst:   DefaultFrameBurst,
+
+		RoomChangeRate:  DefaultRoomChangeRate,
+		RoomChangeBurst: DefaultRoomChangeBurst,
+		MaxConnections:  DefaultMaxConnections,
+	}
+	if db != nil {
+		h.Store = &MessageStore{DB: db}
+		settings, sanctions, err := h.Store.loadModeration()
+		if err != nil {
+			log.Printf("Chat moderation load error: %v", err)
+		} else {
+			h.settings, h.sanctions = settings, sanctions
+		}
 	}
+	return h
 }
 
+// Handle queues a frame read from client
+func (h *Hub) Handle(client *Client, frame ClientFrame) {
+	h.incoming <- clientFrame{client: client, frame: frame}
+}
+
+// onHub runs f on the hub goroutine and waits for it, so f can use hub state
+func (h *Hub) onHub(f func()) {
+	done := make(chan struct{})
+	h.queries <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// persist hands work that needs the database to the store worker, so a slow
+// query holds up only the frames waiting on it rather than every client.
+// Jobs run one at a time, in order, and the function each returns runs back
+// on the hub goroutine in the same order, where it may use hub state (which
+// can have changed meanwhile). It reports false when the queue is full.
+func (h *Hub) persist(work func() func()) bool {
+	select {
+	case h.jobs <- work:
+		return true
+	default:
+		return false
+	}
+}
+
+// storeWorker runs the persist jobs
+func (h *Hub) storeWorker() {
+	for work := range h.jobs {
+		if then := work(); then != nil {
+			h.queries <- then
+		}
+	}
+}
+
+// busy refuses a frame the store worker has no room for
+func (h *Hub) busy(client *Client, room string) {
+	h.reject(client, room, CodeUnavailable, "Chat is busy, try again")
+}
+
+// Run owns all hub state. Set Backplane before starting it.
 func (h *Hub) Run() {
+	go h.storeWorker()
+	var chatIn, presenceIn, directIn, moderationIn <-chan backplane.Message
+	if h.Backplane != nil {
+		chatIn = h.Backplane.Subscribe(backplane.TopicChat)
+		presenceIn = h.Backplane.Subscribe(backplane.TopicPresence)
+		directIn = h.Backplane.Subscribe(backplane.TopicDirect)
+		moderationIn = h.Backplane.Subscribe(backplane.TopicModeration)
+	}
+	presenceTick := time.NewTicker(presenceInterval)
+	defer presenceTick.Stop()
+	pruneTick := time.NewTicker(time.Minute)
+	defer pruneTick.Stop()
+
 	for {
 		select {
 		case client := <-h.Register:
+			if u := h.users[client.UserID]; u != nil && h.MaxConnections > 0 && len(u.clients) >= h.MaxConnections {
+				h.turnAway(client)
+				continue
+			}
 			h.Clients[client] = true
+			client.rooms = make(map[string]bool)
+			client.historyTo = make(map[string]int64)
+			h.connect(client)
+			if h.joinRefusal(client, DefaultRoom) == nil {
+				h.join(client, DefaultRoom)
+			}
 		case client := <-h.Unregister:
-			if _, ok := h.Clients[client]; ok {
-				delete(h.Clients, client)
-				client.Conn.Close()
+			if h.Clients[client] {
+				h.drop(client)
 			}
-		case message := <-h.Broadcast:
-			for client := range h.Clients {
-				err := client.Conn.WriteJSON(message)
+		case in := <-h.incoming:
+			if !h.Clients[in.client] {
+				continue
+			}
+			if in.invalid {
+				h.reject(in.client, "", CodeBadFrame, "Frames must be JSON objects")
+			} else {
+				h.handle(in.client, in.frame)
+			}
+		case env := <-h.Broadcast:
+			if env.Room == "" {
+				env.Room = DefaultRoom
+			}
+			if env.Type == "" {
+				env.Type = TypeSystem
+			}
+			env.Timestamp = time.Now().Unix()
+			h.send(env)
+		case msg, ok := <-chatIn:
+			if !ok {
+				chatIn = nil
+				continue
+			}
+			// Another instance's room traffic, for our members of the room.
+			// Tagged messages come unmasked; each instance masks for its own.
+			var env Envelope
+			if msg.Origin != h.ID && json.Unmarshal(msg.Data, &env) == nil && ValidRoom(env.Room) {
+				h.sendRoom(env, msg.Data)
+			}
+		case msg, ok := <-directIn:
+			if !ok {
+				directIn = nil
+				continue
+			}
+			var d userFrame
+			if msg.Origin != h.ID && json.Unmarshal(msg.Data, &d) == nil {
+				h.sendUsersLocal(d.UserIDs, d.Frame)
+			}
+		case msg, ok := <-moderationIn:
+			if !ok {
+				moderationIn = nil
+				continue
+			}
+			var m Moderation
+			if msg.Origin != h.ID && json.Unmarshal(msg.Data, &m) == nil {
+				h.apply(m)
+			}
+		case msg, ok := <-presenceIn:
+			if !ok {
+				presenceIn = nil
+				continue
+			}
+			var p presenceSnapshot
+			if msg.Origin != h.ID && json.Unmarshal(msg.Data, &p) == nil {
+				p.seen = time.Now()
+				h.remote[msg.Origin] = p
+			}
+		case <-presenceTick.C:
+			h.publishPresence()
+		case <-pruneTick.C:
+			h.pruneModeration()
+			h.pruneBuckets()
+		case query := <-h.queries:
+			query()
+		}
+		h.flush()
+	}
+}
+
+func (h *Hub) handle(client *Client, frame ClientFrame) {
+	// Direct messages go to users, not rooms
+	switch frame.Type {
+	case TypeDirect:
+		h.direct(client, frame)
+		return
+	case TypeRead:
+		h.read(client, frame)
+		return
+	}
+
+	room := strings.TrimSpace(frame.Room)
+	if room == "" {
+		room = DefaultRoom
+	}
+	if !ValidRoom(room) {
+		h.reject(client, room, CodeInvalidRoom, "Unknown room name; use general, manga:<id> or topic:<name>")
+		return
+	}
+
+	switch frame.Type {
+	case TypeJoin:
+		if client.rooms[room] {
+			return
+		}
+		if len(client.rooms) >= MaxRoomsPerClient {
+			h.reject(client, room, CodeTooManyRooms, "Too many rooms, leave one first")
+			return
+		}
+		if sys := h.joinRefusal(client, room); sys != nil {
+			h.refuse(client, room, sys)
+			return
+		}
+		if sys := h.roomChangeLimit(client); sys != nil {
+			h.refuse(client, room, sys)
+			return
+		}
+		h.join(client, room)
+	case TypeLeave:
+		if client.rooms[room] {
+			if sys := h.roomChangeLimit(client); sys != nil {
+				h.refuse(client, room, sys)
+				return
+			}
+			h.deliver(client, h.presenceEvent(StatusLeft, room, client))
+			h.leave(client, room)
+		}
+	case TypeTyping:
+		// A muted user's typing is dropped quietly; they hear about the mute when they post
+		if _, muted := h.muted(client.UserID, room); muted {
+			return
+		}
+		if h.canPost(client, room) && h.typingAllowed(client, room) {
+			h.send(Envelope{
+				Type:      TypeTyping,
+				Room:      room,
+				Typing:    &Typing{UserID: client.UserID, Username: client.Username, ExpiresIn: int(TypingTimeout / time.Second)},
+				Timestamp: time.Now().Unix(),
+			})
+		}
+	case "", TypeMessage:
+		if !h.canPost(client, room) {
+			return
+		}
+		if sys := validText(frame.Message); sys != nil {
+			h.refuse(client, room, sys)
+			return
+		}
+		if sys := validChapter(room, frame.Chapter); sys != nil {
+			h.refuse(client, room, sys)
+			return
+		}
+		if sys := h.postRefusal(client, room); sys != nil {
+			h.refuse(client, room, sys)
+			return
+		}
+		text, err := h.filter(frame.Message)
+		if err != nil {
+			h.reject(client, room, CodeFiltered, "Message refused: "+err.Error())
+			return
+		}
+		msg := ChatMessage{Room: room, UserID: client.UserID, Username: client.Username, Message: text, Chapter: frame.Chapter}
+		if h.Store == nil {
+			msg.Timestamp = time.Now().Unix()
+			h.posted(client, room)
+			h.post(client, msg)
+			return
+		}
+		queued := h.persist(func() func() {
+			err := h.Store.Save(&msg)
+			return func() {
 				if err != nil {
-					client.Conn.Close()
-					delete(h.Clients, client)
+					log.Printf("Chat store error: %v", err)
+					h.reject(client, room, CodeUnavailable, "Message could not be sent, try again")
+					return
 				}
+				h.post(client, msg)
 			}
+		})
+		if !queued {
+			h.busy(client, room)
+			return
 		}
+		// Slow mode counts from now, so messages sent while this one is
+		// being stored cannot slip past it
+		h.posted(client, room)
+	case TypeReveal:
+		h.reveal(client, frame.ID)
+	default:
+		h.reject(client, room, CodeUnknownType, "Unknown frame type: "+frame.Type)
+	}
+}
+
+// post sends a stored room message
+func (h *Hub) post(client *Client, msg ChatMessage) {
+	// The message ends their typing; the next keystroke shows it again at once
+	if u := h.users[client.UserID]; u != nil {
+		delete(u.typing, msg.Room)
+	}
+	h.send(Envelope{Type: TypeMessage, Room: msg.Room, Message: &msg, Timestamp: msg.Timestamp})
+}
+
+// canPost rejects guests, who may listen but not talk, and anyone not in the room
+func (h *Hub) canPost(client *Client, room string) bool {
+	if client.UserID == GuestID {
+		h.reject(client, room, CodeLoginNeeded, "Log in to chat")
+		return false
+	}
+	if !client.rooms[room] {
+		h.reject(client, room, CodeNotMember, "Join the room before posting to it")
+		return false
+	}
+	return true
+}
+
+func (h *Hub) join(client *Client, room string) {
+	if h.Rooms[room] == nil {
+		h.Rooms[room] = make(map[*Client]bool)
+	}
+	h.Rooms[room][client] = true
+	client.rooms[room] = true
+	h.presenceDirty = true
+	if h.entered(client, room) {
+		h.send(h.presenceEvent(StatusJoined, room, client))
+	}
+
+	// Catch the newcomer up on what was said before they arrived
+	if h.Store == nil || client.rooms == nil {
+		return
+	}
+	userID := client.UserID
+	queued := h.persist(func() func() {
+		history, err := h.Store.Recent(room, 0, HistoryOnJoin)
+		if err == nil {
+			err = h.Store.MaskSpoilers(room, userID, history)
+		}
+		return func() {
+			if err != nil {
+				log.Printf("Chat history error: %v", err)
+				return
+			}
+			if !client.rooms[room] {
+				return
+			}
+			// Messages stored before the history was read are in it; sendRoom
+			// skips them so they do not arrive twice
+			if n := len(history); n > 0 {
+				client.historyTo[room] = history[n-1].ID
+			}
+			h.deliver(client, Envelope{Type: TypeHistory, Room: room, Messages: history, Timestamp: time.Now().Unix()})
+		}
+	})
+	if !queued {
+		log.Printf("Chat history skipped for %s in %s: store queue full", client.Username, room)
+	}
+}
+
+// leave removes the client and, if it was the user's last tab there, tells
+// the rest of the room
+func (h *Hub) leave(client *Client, room string) {
+	if h.remove(client, room) {
+		h.send(h.presenceEvent(StatusLeft, room, client))
+	}
+}
+
+func (h *Hub) remove(client *Client, room string) (announce bool) {
+	delete(client.rooms, room)
+	delete(client.historyTo, room)
+	h.presenceDirty = true
+	if members := h.Rooms[room]; members != nil {
+		delete(members, client)
+		if len(members) == 0 {
+			delete(h.Rooms, room)
+		}
+	}
+	return h.exited(client, room)
+}
+
+// drop removes a client from the hub. It may be mid-broadcast (an eviction),
+// so its departures are queued for flush rather than sent here. Closing
+// send makes its write pump close the connection.
+func (h *Hub) drop(client *Client) {
+	for room := range client.rooms {
+		if h.remove(client, room) {
+			h.pending = append(h.pending, h.presenceEvent(StatusLeft, room, client))
+		}
+	}
+	client.rooms = nil
+	h.disconnect(client)
+	delete(h.Clients, client)
+	close(client.send)
+}
+
+// turnAway refuses a connection the user has too many of. The client was
+// never added, so its frames and unregistration are ignored; its write pump
+// sends the refusal and closes the connection.
+func (h *Hub) turnAway(client *Client) {
+	log.Printf("🚫 Refusing chat connection for %s: too many connections", client.Username)
+	sys := refusal(CodeTooManyConns, fmt.Sprintf("Too many chat connections (at most %d), close a tab first", h.MaxConnections))
+	if data, err := json.Marshal(Envelope{Type: TypeSystem, System: sys, Timestamp: time.Now().Unix()}); err == nil {
+		client.send <- data
+	}
+	client.closeCode, client.closeText = websocket.ClosePolicyViolation, "too many connections"
+	close(client.send)
+}
+
+// flush sends the queued announcements. Sending can evict more clients and
+// queue more, so it loops until the queue is empty.
+func (h *Hub) flush() {
+	for len(h.pending) > 0 {
+		env := h.pending[0]
+		h.pending = h.pending[1:]
+		h.send(env)
+	}
+}
+
+// reject tells the sender why their frame was refused
+func (h *Hub) reject(client *Client, room, code, text string) {
+	h.refuse(client, room, refusal(code, text))
+}
+
+func (h *Hub) refuse(client *Client, room string, sys *System) {
+	h.deliver(client, Envelope{Type: TypeSystem, Room: room, System: sys, Timestamp: time.Now().Unix()})
+}
+
+// deliver queues a frame for one client
+func (h *Hub) deliver(client *Client, env Envelope) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Chat encode error: %v", err)
+		return
+	}
+	h.enqueue(client, data)
+}
+
+// send delivers a frame to everyone in its room on every instance,
+// encoding it only once (twice for a chapter-tagged message)
+func (h *Hub) send(env Envelope) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Chat encode error: %v", err)
+		return
+	}
+	h.sendRoom(env, data)
+	if h.Backplane != nil {
+		if err := h.Backplane.Publish(backplane.Message{Topic: backplane.TopicChat, Origin: h.ID, Data: data}); err != nil {
+			log.Printf("Chat backplane error: %v", err)
+		}
+	}
+}
+
+// sendRoom delivers an encoded frame to this instance's members of its room
+func (h *Hub) sendRoom(env Envelope, data []byte) {
+	if env.Type == TypeMessage && env.Message != nil {
+		h.sendMessage(env, data)
+	} else {
+		h.sendLocal(env.Room, data, guestsSee(env.Type))
+	}
+}
+
+// sendLocal delivers an encoded frame to this instance's members of room,
+// leaving out guests unless they may see it
+func (h *Hub) sendLocal(room string, data []byte, guests bool) {
+	for client := range h.Rooms[room] {
+		if guests || client.UserID != GuestID {
+			h.enqueue(client, data)
+		}
+	}
+}
+
+// sendUsers delivers a frame to every connection of the given users on
+// every instance
+func (h *Hub) sendUsers(env Envelope, userIDs ...string) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Chat encode error: %v", err)
+		return
+	}
+	h.sendUsersLocal(userIDs, data)
+	if h.Backplane != nil {
+		msg, err := backplane.NewMessage(backplane.TopicDirect, h.ID, userFrame{UserIDs: userIDs, Frame: data})
+		if err == nil {
+			err = h.Backplane.Publish(msg)
+		}
+		if err != nil {
+			log.Printf("Chat backplane error: %v", err)
+		}
+	}
+}
+
+func (h *Hub) sendUsersLocal(userIDs []string, data []byte) {
+	for _, id := range userIDs {
+		if u := h.users[id]; u != nil {
+			for client := range u.clients {
+				h.enqueue(client, data)
+			}
+		}
+	}
+}
+
+// enqueue never blocks: a client whose buffer is full is too slow to keep
+// up, and is dropped rather than holding back everyone else
+func (h *Hub) enqueue(client *Client, data []byte) {
+	if !h.Clients[client] {
+		return
+	}
+	select {
+	case client.send <- data:
+	default:
+		log.Printf("🐢 Dropping slow chat client %s", client.Username)
+		h.drop(client)
 	}
 }
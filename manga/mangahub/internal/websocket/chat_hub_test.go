@@ -0,0 +1,182 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testClient registers a connection without a socket; the test reads what
+// the hub sends it from its send channel
+func testClient(h *Hub, userID string) *Client {
+	c := NewClient(nil, userID, "user"+userID)
+	h.Register <- c
+	return c
+}
+
+// drain reads a client's frames until the hub closes its channel
+func drain(c *Client) <-chan int {
+	done := make(chan int, 1)
+	go func() {
+		n := 0
+		for range c.send {
+			n++
+		}
+		done <- n
+	}()
+	return done
+}
+
+func TestSlowClientIsEvicted(t *testing.T) {
+	h := NewChatHub(nil)
+	go h.Run()
+	slow := testClient(h, "1")
+	fast := testClient(h, "2")
+	fastFrames := make(chan []byte, SendBuffer*2)
+	go func() {
+		for data := range fast.send {
+			fastFrames <- data
+		}
+	}()
+
+	// The slow client reads nothing, so its buffer fills and it is dropped;
+	// the fast one keeps up (the test waits for it) and stays
+	for i := 0; i < SendBuffer+10; i++ {
+		h.Broadcast <- Envelope{Message: &ChatMessage{Message: fmt.Sprint(i)}}
+		h.onHub(func() {})
+		for len(fast.send) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	var connected, evicted bool
+	h.onHub(func() { connected, evicted = h.Clients[fast], !h.Clients[slow] })
+	if !connected || !evicted {
+		t.Fatalf("fast client connected: %v, slow client evicted: %v", connected, evicted)
+	}
+	select {
+	case n := <-drain(slow):
+		if n != SendBuffer {
+			t.Fatalf("slow client got %d frames before being closed, want %d", n, SendBuffer)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow client's channel was not closed")
+	}
+
+	// Its departure is announced to the rest of the room
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-fastFrames:
+			var env Envelope
+			json.Unmarshal(data, &env)
+			if env.Type == TypePresence && env.Presence.UserID == "1" && env.Presence.Status == StatusLeft {
+				return
+			}
+		case <-deadline:
+			t.Fatal("no departure announced for the evicted client")
+		}
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	for _, members := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("members=%d", members), func(b *testing.B) {
+			h := NewChatHub(nil)
+			go h.Run()
+			clients := make([]*Client, members)
+			for i := range clients {
+				clients[i] = testClient(h, fmt.Sprint(i))
+				drain(clients[i])
+			}
+			env := Envelope{Message: &ChatMessage{Message: "hello"}}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.Broadcast <- env
+				// Measure the hub, not the readers: let them catch up before
+				// their buffers fill and they are evicted
+				if i%(SendBuffer/2) == 0 {
+					b.StopTimer()
+					for _, c := range clients {
+						for len(c.send) > 0 {
+							time.Sleep(time.Millisecond)
+						}
+					}
+					b.StartTimer()
+				}
+			}
+			b.StopTimer()
+			var connected int
+			h.onHub(func() { connected = len(h.Clients) })
+			if connected != members {
+				b.Fatalf("%d of %d clients evicted", members-connected, members)
+			}
+		})
+	}
+}
+
+// frames collects what the hub sends a client
+func frames(c *Client) <-chan Envelope {
+	out := make(chan Envelope, SendBuffer)
+	go func() {
+		defer close(out)
+		for data := range c.send {
+			var env Envelope
+			json.Unmarshal(data, &env)
+			out <- env
+		}
+	}()
+	return out
+}
+
+func TestConnectionsPerUserAreCapped(t *testing.T) {
+	h := NewChatHub(nil)
+	h.MaxConnections = 2
+	go h.Run()
+	drain(testClient(h, "1"))
+	drain(testClient(h, "1"))
+	drain(testClient(h, "2")) // Other users are not affected
+
+	third := frames(testClient(h, "1"))
+	env, ok := <-third
+	if !ok || env.System == nil || env.System.Code != CodeTooManyConns {
+		t.Fatalf("third connection got %+v", env)
+	}
+	if _, open := <-third; open {
+		t.Fatal("third connection was not closed")
+	}
+	var connected int
+	h.onHub(func() { connected = len(h.Clients) })
+	if connected != 3 {
+		t.Fatalf("%d clients connected, want 3", connected)
+	}
+}
+
+func TestJoinAndLeaveAreRateLimited(t *testing.T) {
+	h := NewChatHub(nil)
+	h.RoomChangeRate, h.RoomChangeBurst = 1, 4
+	go h.Run()
+	c := testClient(h, "1")
+	got := frames(c)
+
+	// Two rounds use the burst; after that the joins are refused, and the
+	// leaves do nothing (and cost nothing)
+	for i := 0; i < 4; i++ {
+		h.Handle(c, ClientFrame{Type: TypeJoin, Room: "manga:1"})
+		h.Handle(c, ClientFrame{Type: TypeLeave, Room: "manga:1"})
+	}
+	h.onHub(func() { close(c.send) })
+
+	var limited int
+	for env := range got {
+		if env.System != nil && env.System.Code == CodeRateLimited {
+			limited++
+			if env.System.RetryAfter < 1 {
+				t.Errorf("no retry_after in %+v", env.System)
+			}
+		}
+	}
+	if limited != 2 {
+		t.Fatalf("%d joins refused, want 2", limited)
+	}
+}
@@ -0,0 +1,125 @@
+package socket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connection timing, as in the gorilla/websocket chat example
+const (
+	writeWait  = 10 * time.Second    // Time allowed to write one frame
+	pongWait   = 60 * time.Second    // Time allowed between pongs before the peer counts as gone
+	pingPeriod = (pongWait * 9) / 10 // Pings go out a little more often than pongWait
+
+	// SendBuffer is how many frames may wait for a client before the hub
+	// gives up on it as a slow consumer
+	SendBuffer = 256
+)
+
+// Client represents a single chat participant
+type Client struct {
+	Conn      *websocket.Conn
+	UserID    string
+	Username  string
+	Moderator bool // Exempt from slow mode
+
+	send        chan []byte // Encoded frames; only the hub sends to or closes it
+	closeCode   int         // Close frame sent once send is closed; set by the hub before closing it
+	closeText   string
+	rooms       map[string]bool  // Only touched by the hub goroutine
+	historyTo   map[string]int64 // Last message ID of the history sent on joining, by room; hub only
+	roomChanges tokenBucket      // Join and leave budget; hub only
+}
+
+func NewClient(conn *websocket.Conn, userID, username string) *Client {
+	return &Client{
+		Conn:     conn,
+		UserID:   userID,
+		Username: username,
+		send:     make(chan []byte, SendBuffer),
+
+		closeCode: websocket.CloseTryAgainLater,
+		closeText: "too slow",
+	}
+}
+
+// Serve registers the client and starts its pumps. The connection is owned
+// by them from here on: the read pump for reads, the write pump for writes.
+func (h *Hub) Serve(client *Client) {
+	h.Register <- client
+	go client.writePump()
+	go client.readPump(h)
+}
+
+// readPump hands incoming frames to the hub until the connection fails or
+// stops answering pings
+func (c *Client) readPump(h *Hub) {
+	defer func() {
+		h.Unregister <- c
+		c.Conn.Close()
+	}()
+	c.Conn.SetReadLimit(MaxFrameBytes)
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	var frames tokenBucket
+	for {
+		_, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseMessageTooBig) {
+				log.Printf("Chat read error (%s): %v", c.Username, err)
+			}
+			return
+		}
+		// A client flooding frames of any kind is cut off rather than warned:
+		// the warnings would only add to the flood
+		if h.FrameRate > 0 {
+			if ok, _ := frames.take(h.FrameRate, h.FrameBurst); !ok {
+				log.Printf("🚫 Closing chat client %s: too many frames", c.Username)
+				c.Conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many frames"), time.Now().Add(writeWait))
+				return
+			}
+		}
+		var frame ClientFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			h.incoming <- clientFrame{client: c, invalid: true}
+			continue
+		}
+		h.Handle(c, frame)
+	}
+}
+
+// writePump is the only writer on the connection. It drains the send
+// channel and pings the peer; it closes the connection once the hub closes
+// send or a write fails.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText))
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
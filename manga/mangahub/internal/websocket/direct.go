@@ -0,0 +1,316 @@
+package socket
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// DirectMessage is one private message between two users
+type DirectMessage struct {
+	ID           int64  `json:"id"`
+	FromID       string `json:"from_id"`
+	FromUsername string `json:"from_username"`
+	ToID         string `json:"to_id"`
+	Message      string `json:"message"`
+	Timestamp    int64  `json:"timestamp"`
+	ReadAt       *int64 `json:"read_at"` // Null until the recipient reads it
+}
+
+// Receipt says a user has read a conversation up to a message
+type Receipt struct {
+	ReaderID string `json:"reader_id"`
+	SenderID string `json:"sender_id"`
+	UpTo     int64  `json:"up_to"`
+	ReadAt   int64  `json:"read_at"`
+}
+
+// Conversation is one entry of GET /chat/conversations
+type Conversation struct {
+	UserID      string        `json:"user_id"`
+	Username    string        `json:"username"`
+	LastMessage DirectMessage `json:"last_message"`
+	Unread      int           `json:"unread"`
+}
+
+// Block is one entry of GET /users/blocks
+type Block struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+const directColumns = `id, sender_id, sender_username, recipient_id, message, created_at, read_at`
+
+func scanDirect(row interface{ Scan(...interface{}) error }) (DirectMessage, error) {
+	var msg DirectMessage
+	var readAt sql.NullInt64
+	err := row.Scan(&msg.ID, &msg.FromID, &msg.FromUsername, &msg.ToID, &msg.Message, &msg.Timestamp, &readAt)
+	if readAt.Valid {
+		msg.ReadAt = &readAt.Int64
+	}
+	return msg, err
+}
+
+// Username looks up a user; sql.ErrNoRows means there is no such user
+func (s *MessageStore) Username(userID string) (string, error) {
+	var name string
+	err := s.DB.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&name)
+	return name, err
+}
+
+// Role looks up a user's role; sql.ErrNoRows means there is no such user
+func (s *MessageStore) Role(userID string) (string, error) {
+	var role string
+	err := s.DB.QueryRow("SELECT IFNULL(role, '') FROM users WHERE id = ?", userID).Scan(&role)
+	return role, err
+}
+
+// SaveDirect stores msg and fills in its ID and timestamp
+func (s *MessageStore) SaveDirect(msg *DirectMessage) error {
+	msg.Timestamp = time.Now().Unix()
+	res, err := s.DB.Exec(`INSERT INTO direct_messages (sender_id, sender_username, recipient_id, message, created_at)
+		VALUES (?, ?, ?, ?, ?)`, msg.FromID, msg.FromUsername, msg.ToID, msg.Message, msg.Timestamp)
+	if err != nil {
+		return err
+	}
+	msg.ID, err = res.LastInsertId()
+	return err
+}
+
+// DirectHistory returns up to limit messages between two users older than
+// the before ID (all if before is 0), oldest first
+func (s *MessageStore) DirectHistory(userID, otherID string, before int64, limit int) ([]DirectMessage, error) {
+	query := `SELECT ` + directColumns + ` FROM direct_messages
+		WHERE ((sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?))`
+	args := []interface{}{userID, otherID, otherID, userID}
+	if before > 0 {
+		query += " AND id < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []DirectMessage{}
+	for rows.Next() {
+		msg, err := scanDirect(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, msg)
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, rows.Err()
+}
+
+// Conversations lists the people a user has exchanged messages with, most
+// recent first, with the last message and how many of theirs are unread
+func (s *MessageStore) Conversations(userID string, limit int) ([]Conversation, error) {
+	rows, err := s.DB.Query(`
+		SELECT c.other, COALESCE(u.username, ''),
+			m.id, m.sender_id, m.sender_username, m.recipient_id, m.message, m.created_at, m.read_at,
+			(SELECT COUNT(*) FROM direct_messages
+				WHERE sender_id = c.other AND recipient_id = ? AND read_at IS NULL)
+		FROM (
+			SELECT CASE WHEN sender_id = ? THEN recipient_id ELSE sender_id END AS other, MAX(id) AS last_id
+			FROM direct_messages WHERE sender_id = ? OR recipient_id = ?
+			GROUP BY other
+		) c
+		JOIN direct_messages m ON m.id = c.last_id
+		LEFT JOIN users u ON u.id = CAST(c.other AS INTEGER)
+		ORDER BY c.last_id DESC LIMIT ?`, userID, userID, userID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Conversation{}
+	for rows.Next() {
+		var conv Conversation
+		var readAt sql.NullInt64
+		m := &conv.LastMessage
+		if err := rows.Scan(&conv.UserID, &conv.Username, &m.ID, &m.FromID, &m.FromUsername, &m.ToID,
+			&m.Message, &m.Timestamp, &readAt, &conv.Unread); err != nil {
+			return nil, err
+		}
+		if readAt.Valid {
+			m.ReadAt = &readAt.Int64
+		}
+		list = append(list, conv)
+	}
+	return list, rows.Err()
+}
+
+// MarkRead marks what senderID sent readerID as read, up to the upTo ID (all
+// if 0). The receipt is nil when there was nothing unread.
+func (s *MessageStore) MarkRead(readerID, senderID string, upTo int64) (*Receipt, error) {
+	query := `SELECT MAX(id) FROM direct_messages WHERE sender_id = ? AND recipient_id = ? AND read_at IS NULL`
+	args := []interface{}{senderID, readerID}
+	if upTo > 0 {
+		query += " AND id <= ?"
+		args = append(args, upTo)
+	}
+	var last sql.NullInt64
+	if err := s.DB.QueryRow(query, args...).Scan(&last); err != nil || !last.Valid {
+		return nil, err
+	}
+
+	r := &Receipt{ReaderID: readerID, SenderID: senderID, UpTo: last.Int64, ReadAt: time.Now().Unix()}
+	_, err := s.DB.Exec(`UPDATE direct_messages SET read_at = ?
+		WHERE sender_id = ? AND recipient_id = ? AND read_at IS NULL AND id <= ?`,
+		r.ReadAt, senderID, readerID, r.UpTo)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Blocked reports whether either user has blocked the other
+func (s *MessageStore) Blocked(userID, otherID string) (bool, error) {
+	var n int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM user_blocks
+		WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)`,
+		userID, otherID, otherID, userID).Scan(&n)
+	return n > 0, err
+}
+
+func (s *MessageStore) Block(userID, otherID string) error {
+	_, err := s.DB.Exec(`INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?)`,
+		userID, otherID, time.Now().Unix())
+	return err
+}
+
+// Unblock reports whether there was a block to remove
+func (s *MessageStore) Unblock(userID, otherID string) (bool, error) {
+	res, err := s.DB.Exec(`DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?`, userID, otherID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *MessageStore) Blocks(userID string) ([]Block, error) {
+	rows, err := s.DB.Query(`SELECT b.blocked_id, COALESCE(u.username, ''), b.created_at
+		FROM user_blocks b LEFT JOIN users u ON u.id = CAST(b.blocked_id AS INTEGER)
+		WHERE b.blocker_id = ? ORDER BY b.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Block{}
+	for rows.Next() {
+		var b Block
+		if err := rows.Scan(&b.UserID, &b.Username, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, rows.Err()
+}
+
+// direct stores a private message and sends it to both users' connections,
+// so the sender's other tabs see it too
+func (h *Hub) direct(client *Client, frame ClientFrame) {
+	if client.UserID == GuestID {
+		h.reject(client, "", CodeLoginNeeded, "Log in to chat")
+		return
+	}
+	if h.Store == nil {
+		h.reject(client, "", CodeUnavailable, "Direct messages are not available")
+		return
+	}
+	to := strings.TrimSpace(frame.To)
+	if to == client.UserID {
+		h.reject(client, "", CodeNoSuchUser, "You cannot message yourself")
+		return
+	}
+	if sys := validText(frame.Message); sys != nil {
+		h.refuse(client, "", sys)
+		return
+	}
+	if sys := h.postRefusal(client, ""); sys != nil {
+		h.refuse(client, "", sys)
+		return
+	}
+	text, err := h.filter(frame.Message)
+	if err != nil {
+		h.reject(client, "", CodeFiltered, "Message refused: "+err.Error())
+		return
+	}
+	msg := DirectMessage{FromID: client.UserID, FromUsername: client.Username, ToID: to, Message: text}
+	queued := h.persist(func() func() {
+		refuse := func(code, text string) func() {
+			return func() { h.reject(client, "", code, text) }
+		}
+		if _, err := h.Store.Username(to); err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Chat store error: %v", err)
+			}
+			return refuse(CodeNoSuchUser, "No such user")
+		}
+		// Neither side learns who blocked whom
+		blocked, err := h.Store.Blocked(msg.FromID, to)
+		if err != nil || blocked {
+			if err != nil {
+				log.Printf("Chat store error: %v", err)
+			}
+			return refuse(CodeBlocked, "You cannot message this user")
+		}
+		if err := h.Store.SaveDirect(&msg); err != nil {
+			log.Printf("Chat store error: %v", err)
+			return refuse(CodeUnavailable, "Message could not be sent, try again")
+		}
+		return func() {
+			h.sendUsers(Envelope{Type: TypeDirect, Direct: &msg, Timestamp: msg.Timestamp}, msg.FromID, to)
+		}
+	})
+	if !queued {
+		h.busy(client, "")
+	}
+}
+
+// read marks a conversation read from a socket frame
+func (h *Hub) read(client *Client, frame ClientFrame) {
+	if client.UserID == GuestID || h.Store == nil {
+		return
+	}
+	readerID, senderID := client.UserID, strings.TrimSpace(frame.To)
+	queued := h.persist(func() func() {
+		receipt, err := h.Store.MarkRead(readerID, senderID, frame.UpTo)
+		return func() {
+			if err != nil {
+				log.Printf("Chat store error: %v", err)
+				h.reject(client, "", CodeUnavailable, "Could not mark messages read, try again")
+				return
+			}
+			h.sendReceipt(receipt)
+		}
+	})
+	if !queued {
+		h.busy(client, "")
+	}
+}
+
+// sendReceipt tells the sender their messages were read, and the reader's
+// other tabs that they are no longer unread
+func (h *Hub) sendReceipt(r *Receipt) {
+	if r != nil {
+		h.sendUsers(Envelope{Type: TypeRead, Receipt: r, Timestamp: r.ReadAt}, r.ReaderID, r.SenderID)
+	}
+}
+
+// SendReceipt pushes a receipt for messages marked read over REST
+func (h *Hub) SendReceipt(r *Receipt) {
+	h.onHub(func() { h.sendReceipt(r) })
+}
@@ -0,0 +1,144 @@
+package socket
+
+import "time"
+
+// Frame types. Clients send "message", "join", "leave", "typing", "dm",
+// "read" and "reveal"; the hub sends "message", "history", "presence",
+// "typing", "dm", "read", "reveal", "moderation" and "system".
+const (
+	TypeMessage    = "message"
+	TypeJoin       = "join"
+	TypeLeave      = "leave"
+	TypeTyping     = "typing"
+	TypeDirect     = "dm"
+	TypeRead       = "read"
+	TypeHistory    = "history"
+	TypePresence   = "presence"
+	TypeSystem     = "system"
+	TypeModeration = "moderation"
+	TypeReveal     = "reveal"
+)
+
+// Presence statuses: a user joined or left a room (first tab in, last tab out)
+const (
+	StatusJoined = "joined"
+	StatusLeft   = "left"
+)
+
+// System levels
+const (
+	LevelInfo  = "info"
+	LevelError = "error"
+)
+
+// Error codes in "system" frames with level "error", for clients to act on
+// without parsing the text
+const (
+	CodeBadFrame      = "bad_frame"    // Not valid JSON
+	CodeUnknownType   = "unknown_type" // Unknown frame type
+	CodeInvalidRoom   = "invalid_room"
+	CodeTooManyRooms  = "too_many_rooms"
+	CodeTooManyConns  = "too_many_connections" // Sent just before the server closes the connection
+	CodeNotMember     = "not_member"           // Join the room first
+	CodeLoginNeeded   = "login_required"
+	CodeEmpty         = "empty"
+	CodeTooLong       = "too_long"
+	CodeRateLimited   = "rate_limited" // See retry_after
+	CodeSlowMode      = "slow_mode"    // See retry_after
+	CodeMuted         = "muted"
+	CodeBanned        = "banned"
+	CodeGuestsClosed  = "guests_closed"
+	CodeFiltered      = "filtered"
+	CodeBadChapter    = "bad_chapter"
+	CodeBlocked       = "blocked"
+	CodeNoSuchUser    = "no_such_user"
+	CodeNoSuchMessage = "no_such_message"
+	CodeUnavailable   = "unavailable" // Try again later
+)
+
+// Typing indicators: a user's typing events are passed on at most once per
+// TypingThrottle per room, and clients hide the indicator after TypingTimeout
+const (
+	TypingThrottle = 3 * time.Second
+	TypingTimeout  = 5 * time.Second
+)
+
+// ClientFrame is what clients send
+//
+//	{"type": "join", "room": "manga:one-piece"}
+//	{"type": "message", "room": "manga:one-piece", "message": "Chapter 1100!"}
+//	{"type": "message", "room": "manga:one-piece", "chapter": 1100, "message": "||He's back||"}
+//	{"type": "reveal", "id": 812}
+//	{"type": "typing", "room": "manga:one-piece"}
+//	{"type": "dm", "to": "42", "message": "Have you read it yet?"}
+//	{"type": "read", "to": "42", "up_to": 17}
+type ClientFrame struct {
+	Type    string `json:"type"` // Empty means "message"
+	Room    string `json:"room,omitempty"`
+	To      string `json:"to,omitempty"` // User ID, for "dm" and "read"
+	Message string `json:"message,omitempty"`
+	UpTo    int64  `json:"up_to,omitempty"`   // Last message read; 0 means all
+	Chapter int    `json:"chapter,omitempty"` // Chapter a manga room message discusses
+	ID      int64  `json:"id,omitempty"`      // Message to "reveal"
+}
+
+// ChatMessage is one message said in a room, as stored and as sent
+type ChatMessage struct {
+	ID        int64  `json:"id,omitempty"` // Assigned when the message is stored
+	Room      string `json:"room"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Message   string `json:"message"`
+	Chapter   int    `json:"chapter,omitempty"` // The chapter it discusses, if tagged
+	Masked    bool   `json:"masked,omitempty"`  // Text withheld: the reader is not that far yet
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Envelope is every frame the hub sends. Type says which payload is set.
+type Envelope struct {
+	Type       string         `json:"type"`
+	Room       string         `json:"room,omitempty"`
+	Message    *ChatMessage   `json:"message,omitempty"`    // "message", "reveal"
+	Messages   []ChatMessage  `json:"messages,omitempty"`   // "history"
+	Presence   *Presence      `json:"presence,omitempty"`   // "presence"
+	Typing     *Typing        `json:"typing,omitempty"`     // "typing"
+	System     *System        `json:"system,omitempty"`     // "system"
+	Direct     *DirectMessage `json:"direct,omitempty"`     // "dm"
+	Receipt    *Receipt       `json:"receipt,omitempty"`    // "read"
+	Moderation *Moderation    `json:"moderation,omitempty"` // "moderation"
+	Timestamp  int64          `json:"timestamp"`
+}
+
+// Presence is a user joining or leaving a room
+type Presence struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Status   string `json:"status"`
+}
+
+// Typing says a user is typing in a room
+type Typing struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	ExpiresIn int    `json:"expires_in"` // Seconds
+}
+
+// guestsSee reports whether guests get frames of a type. They see what is
+// said, not who is around.
+func guestsSee(frameType string) bool {
+	return frameType != TypePresence && frameType != TypeTyping
+}
+
+// System is a notice from the server: an announcement, or an error about the
+// client's last frame
+type System struct {
+	Level      string `json:"level"`
+	Code       string `json:"code,omitempty"` // On errors
+	Text       string `json:"text"`
+	RetryAfter int    `json:"retry_after,omitempty"` // Seconds, when waiting helps
+}
+
+// refusal is the error notice for a refused frame
+func refusal(code, text string) *System {
+	return &System{Level: LevelError, Code: code, Text: text}
+}
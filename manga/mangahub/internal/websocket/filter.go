@@ -0,0 +1,126 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Filter checks a message before it is stored and sent. It returns the text
+// to use, which it may rewrite, or an error whose text is shown to the sender.
+type Filter func(text string) (string, error)
+
+// DefaultBannedWords is the profanity list used when none is configured
+var DefaultBannedWords = []string{"fuck", "shit", "bitch", "cunt", "asshole", "bastard"}
+
+// ErrLinkNotAllowed is returned by LinkFilter
+var ErrLinkNotAllowed = errors.New("links are not allowed in chat")
+
+// linkPattern finds URLs with a scheme (no host submatch) and dotted names
+// that may be bare domains: host, its last label, then any port or path
+var linkPattern = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://\S+|((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+([a-z]{2,63}))\b(:\d+|/\S*)?)`)
+
+// linkTLDs are the endings that make a bare dotted name a link by
+// themselves. Other names ("Dr.Stone", "wait.what") only count with "www.",
+// a port or a path, since chat is full of words run together at a full stop.
+var linkTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "info": true, "biz": true, "io": true,
+	"co": true, "me": true, "tv": true, "gg": true, "to": true, "cc": true,
+	"ws": true, "xyz": true, "app": true, "dev": true, "site": true, "online": true,
+	"club": true, "top": true, "lol": true, "moe": true, "ru": true, "cn": true,
+	"uk": true, "de": true, "fr": true, "jp": true, "kr": true, "br": true,
+	"in": true, "us": true, "eu": true, "ly": true,
+}
+
+// isLink tells links from other dotted words among linkPattern's matches
+func isLink(m []string) bool {
+	host, tld, rest := strings.ToLower(m[1]), strings.ToLower(m[2]), m[3]
+	return host == "" || strings.HasPrefix(host, "www.") || rest != "" || linkTLDs[tld]
+}
+
+// ProfanityFilter masks the listed words with asterisks. Words match whole
+// and case-insensitively.
+func ProfanityFilter(words []string) Filter {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return func(text string) (string, error) { return text, nil }
+	}
+	pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return func(text string) (string, error) {
+		return pattern.ReplaceAllStringFunc(text, func(w string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(w))
+		}), nil
+	}
+}
+
+// LinkFilter refuses messages with links, except to the allowed hosts and
+// their subdomains
+func LinkFilter(allowedHosts []string) Filter {
+	return func(text string) (string, error) {
+		for _, m := range linkPattern.FindAllStringSubmatch(text, -1) {
+			if !isLink(m) {
+				continue
+			}
+			link := m[0]
+			if m[1] != "" {
+				link = "http://" + link
+			}
+			u, err := url.Parse(link)
+			if err != nil || !hostAllowed(strings.ToLower(u.Hostname()), allowedHosts) {
+				return "", ErrLinkNotAllowed
+			}
+		}
+		return text, nil
+	}
+}
+
+func hostAllowed(host string, allowed []string) bool {
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a != "" && (host == a || strings.HasSuffix(host, "."+a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildFilters makes the pipeline from filter names, in order: "profanity"
+// (masks bannedWords, or DefaultBannedWords if empty) and "links" (refuses
+// links outside linkHosts). "none" adds nothing.
+func BuildFilters(names, bannedWords, linkHosts []string) ([]Filter, error) {
+	var filters []Filter
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "profanity":
+			if len(bannedWords) == 0 {
+				bannedWords = DefaultBannedWords
+			}
+			filters = append(filters, ProfanityFilter(bannedWords))
+		case "links":
+			filters = append(filters, LinkFilter(linkHosts))
+		default:
+			return nil, fmt.Errorf("unknown chat filter %q", name)
+		}
+	}
+	return filters, nil
+}
+
+// filter runs text through the hub's pipeline
+func (h *Hub) filter(text string) (string, error) {
+	for _, f := range h.Filters {
+		var err error
+		if text, err = f(text); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
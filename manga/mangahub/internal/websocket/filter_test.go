@@ -0,0 +1,33 @@
+package socket
+
+import "testing"
+
+func TestLinkFilter(t *testing.T) {
+	filter := LinkFilter([]string{"mangadex.org"})
+	for text, allowed := range map[string]bool{
+		"read it at https://mangadex.org/title/1": true,
+		"chapter.mangadex.org/12":                 true,
+		"see evil.com/x":                          false,
+		"EVIL.COM":                                false,
+		"http://evil.com":                         false,
+		"www.evil.com":                            false,
+		"ftp://evil.com/file":                     false,
+		"evil.com:8080":                           false,
+		"mangadex.org.evil.com/x":                 false,
+		"ch.12 was great, e.g. the ending":        true,
+		"version 1.2.3":                           true,
+		"it.Really was that good":                 true,
+		"wait.what happened":                      true,
+		"Dr.Stone ended":                          true,
+		"so good.Next week?":                      true,
+		"www.evil.moe":                            false,
+		"evil.stone/x":                            false,
+		"evil.stone:8080":                         false,
+		"evil.to":                                 false,
+	} {
+		_, err := filter(text)
+		if (err == nil) != allowed {
+			t.Errorf("%q: allowed %v, want %v", text, err == nil, allowed)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package socket
+
+import (
+	"database/sql"
+	"time"
+)
+
+// HistoryOnJoin is how many recent messages a client gets when it joins a room
+const HistoryOnJoin = 50
+
+// MessageStore keeps chat messages in the chat_messages table
+type MessageStore struct {
+	DB *sql.DB
+}
+
+// Save stores msg and fills in its server-assigned ID and timestamp
+func (s *MessageStore) Save(msg *ChatMessage) error {
+	msg.Timestamp = time.Now().Unix()
+	res, err := s.DB.Exec(`INSERT INTO chat_messages (room, user_id, username, message, chapter, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, msg.Room, msg.UserID, msg.Username, msg.Message, msg.Chapter, msg.Timestamp)
+	if err != nil {
+		return err
+	}
+	msg.ID, err = res.LastInsertId()
+	return err
+}
+
+// Recent returns up to limit messages of a room older than the before ID
+// (all if before is 0), oldest first so they can be rendered in order
+func (s *MessageStore) Recent(room string, before int64, limit int) ([]ChatMessage, error) {
+	query := `SELECT id, room, user_id, username, message, chapter, created_at FROM chat_messages WHERE room = ? AND deleted_at IS NULL`
+	args := []interface{}{room}
+	if before > 0 {
+		query += " AND id < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []ChatMessage{}
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.Room, &msg.UserID, &msg.Username, &msg.Message, &msg.Chapter, &msg.Timestamp); err != nil {
+			return nil, err
+		}
+		list = append(list, msg)
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, rows.Err()
+}
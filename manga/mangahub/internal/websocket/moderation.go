@@ -0,0 +1,516 @@
+package socket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mangahub/internal/backplane"
+	"math"
+	"time"
+)
+
+// Moderation actions, as sent in "moderation" frames and kept in the log
+const (
+	ActionDelete   = "delete"
+	ActionMute     = "mute"
+	ActionUnmute   = "unmute"
+	ActionBan      = "ban"
+	ActionUnban    = "unban"
+	ActionSettings = "settings"
+)
+
+// Sanction kinds
+const (
+	SanctionMute = "mute"
+	SanctionBan  = "ban"
+)
+
+// Report statuses
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// MaxSlowMode bounds a room's slow mode
+const MaxSlowMode = time.Hour
+
+// ErrAlreadyReported is returned by Report for a second report of the same
+// message by the same user
+var ErrAlreadyReported = errors.New("message already reported")
+
+// RoomSettings are a room's moderation settings
+type RoomSettings struct {
+	SlowMode int  `json:"slow_mode"` // Seconds a user must wait between messages; 0 is off
+	Guests   bool `json:"guests"`    // Whether guests may listen in
+}
+
+var defaultRoomSettings = RoomSettings{Guests: true}
+
+// Moderation is one moderator action. Every instance's hub applies it and
+// tells the clients it concerns in a "moderation" frame.
+type Moderation struct {
+	Action    string        `json:"action"`
+	Room      string        `json:"room,omitempty"` // A mute without a room covers every room and direct messages
+	UserID    string        `json:"user_id,omitempty"`
+	MessageID int64         `json:"message_id,omitempty"`
+	Until     int64         `json:"until,omitempty"` // Unix time a mute or ban ends; 0 means until lifted
+	Reason    string        `json:"reason,omitempty"`
+	Settings  *RoomSettings `json:"settings,omitempty"`
+}
+
+// Sanction is a mute or ban in force
+type Sanction struct {
+	ID          int64  `json:"id"`
+	Kind        string `json:"kind"`
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
+	Room        string `json:"room"`
+	Reason      string `json:"reason"`
+	ModeratorID string `json:"moderator_id"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at"` // 0 means until lifted
+}
+
+// Report is a user's complaint about a chat message
+type Report struct {
+	ID         int64       `json:"id"`
+	ReporterID string      `json:"reporter_id"`
+	Reason     string      `json:"reason"`
+	Status     string      `json:"status"`
+	CreatedAt  int64       `json:"created_at"`
+	ResolvedBy *string     `json:"resolved_by"`
+	ResolvedAt *int64      `json:"resolved_at"`
+	Message    ChatMessage `json:"message"`
+	Deleted    bool        `json:"message_deleted"`
+}
+
+// LogEntry is one row of the moderation audit log
+type LogEntry struct {
+	ID           int64  `json:"id"`
+	ModeratorID  string `json:"moderator_id"`
+	Action       string `json:"action"`
+	Room         string `json:"room"`
+	TargetUserID string `json:"target_user_id"`
+	MessageID    *int64 `json:"message_id"`
+	Reason       string `json:"reason"`
+	Detail       string `json:"detail"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+type sanctionKey struct {
+	kind, userID, room string
+}
+
+// --- Store ---
+
+// loadModeration reads the room settings and sanctions in force
+func (s *MessageStore) loadModeration() (map[string]RoomSettings, map[sanctionKey]int64, error) {
+	settings := make(map[string]RoomSettings)
+	rows, err := s.DB.Query("SELECT room, slow_mode, guests FROM chat_room_settings")
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var room string
+		var rs RoomSettings
+		if err := rows.Scan(&room, &rs.SlowMode, &rs.Guests); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		settings[room] = rs
+	}
+	rows.Close()
+
+	sanctions := make(map[sanctionKey]int64)
+	rows, err = s.DB.Query(`SELECT kind, user_id, room, expires_at FROM chat_sanctions
+		WHERE lifted_at IS NULL AND (expires_at = 0 OR expires_at > ?)`, time.Now().Unix())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key sanctionKey
+		var until int64
+		if err := rows.Scan(&key.kind, &key.userID, &key.room, &until); err != nil {
+			return nil, nil, err
+		}
+		sanctions[key] = until
+	}
+	return settings, sanctions, rows.Err()
+}
+
+func (s *MessageStore) SaveRoomSettings(room string, rs RoomSettings) error {
+	_, err := s.DB.Exec(`INSERT INTO chat_room_settings (room, slow_mode, guests, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(room) DO UPDATE SET slow_mode = excluded.slow_mode, guests = excluded.guests, updated_at = excluded.updated_at`,
+		room, rs.SlowMode, rs.Guests, time.Now().Unix())
+	return err
+}
+
+// AddSanction records a mute or ban, replacing one of the same kind for the
+// same user and room
+func (s *MessageStore) AddSanction(kind string, m Moderation, moderatorID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	if _, err := tx.Exec(`UPDATE chat_sanctions SET lifted_at = ?
+		WHERE kind = ? AND user_id = ? AND room = ? AND lifted_at IS NULL`, now, kind, m.UserID, m.Room); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO chat_sanctions (kind, user_id, room, reason, moderator_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, kind, m.UserID, m.Room, m.Reason, moderatorID, now, m.Until); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LiftSanction ends a mute or ban early; false means none was in force
+func (s *MessageStore) LiftSanction(kind, userID, room string) (bool, error) {
+	now := time.Now().Unix()
+	res, err := s.DB.Exec(`UPDATE chat_sanctions SET lifted_at = ?
+		WHERE kind = ? AND user_id = ? AND room = ? AND lifted_at IS NULL AND (expires_at = 0 OR expires_at > ?)`,
+		now, kind, userID, room, now)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Sanctions lists the mutes and bans in force, newest first
+func (s *MessageStore) Sanctions() ([]Sanction, error) {
+	rows, err := s.DB.Query(`SELECT s.id, s.kind, s.user_id, COALESCE(u.username, ''), s.room, s.reason,
+			s.moderator_id, s.created_at, s.expires_at
+		FROM chat_sanctions s LEFT JOIN users u ON u.id = CAST(s.user_id AS INTEGER)
+		WHERE s.lifted_at IS NULL AND (s.expires_at = 0 OR s.expires_at > ?)
+		ORDER BY s.id DESC`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Sanction{}
+	for rows.Next() {
+		var sn Sanction
+		if err := rows.Scan(&sn.ID, &sn.Kind, &sn.UserID, &sn.Username, &sn.Room, &sn.Reason,
+			&sn.ModeratorID, &sn.CreatedAt, &sn.ExpiresAt); err != nil {
+			return nil, err
+		}
+		list = append(list, sn)
+	}
+	return list, rows.Err()
+}
+
+// Message returns a room message that has not been deleted; sql.ErrNoRows
+// if there is none
+func (s *MessageStore) Message(id int64) (ChatMessage, error) {
+	var msg ChatMessage
+	err := s.DB.QueryRow(`SELECT id, room, user_id, username, message, chapter, created_at FROM chat_messages
+		WHERE id = ? AND deleted_at IS NULL`, id).
+		Scan(&msg.ID, &msg.Room, &msg.UserID, &msg.Username, &msg.Message, &msg.Chapter, &msg.Timestamp)
+	return msg, err
+}
+
+// DeleteMessage hides a room message from history and scrollback. The row is
+// kept so reports still show what was said.
+func (s *MessageStore) DeleteMessage(id int64) (ChatMessage, error) {
+	msg, err := s.Message(id)
+	if err != nil {
+		return msg, err
+	}
+	_, err = s.DB.Exec("UPDATE chat_messages SET deleted_at = ? WHERE id = ?", time.Now().Unix(), id)
+	return msg, err
+}
+
+func (s *MessageStore) Report(messageID int64, reporterID, reason string) (int64, error) {
+	var n int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM chat_reports WHERE message_id = ? AND reporter_id = ?",
+		messageID, reporterID).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return 0, ErrAlreadyReported
+	}
+	res, err := s.DB.Exec(`INSERT INTO chat_reports (message_id, reporter_id, reason, status, created_at)
+		VALUES (?, ?, ?, ?, ?)`, messageID, reporterID, reason, ReportOpen, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+const reportQuery = `SELECT r.id, r.reporter_id, r.reason, r.status, r.created_at, r.resolved_by, r.resolved_at,
+		m.id, m.room, m.user_id, m.username, m.message, m.chapter, m.created_at, m.deleted_at IS NOT NULL
+	FROM chat_reports r JOIN chat_messages m ON m.id = r.message_id`
+
+func scanReport(row interface{ Scan(...interface{}) error }) (Report, error) {
+	var r Report
+	var resolvedBy sql.NullString
+	var resolvedAt sql.NullInt64
+	m := &r.Message
+	err := row.Scan(&r.ID, &r.ReporterID, &r.Reason, &r.Status, &r.CreatedAt, &resolvedBy, &resolvedAt,
+		&m.ID, &m.Room, &m.UserID, &m.Username, &m.Message, &m.Chapter, &m.Timestamp, &r.Deleted)
+	if resolvedBy.Valid {
+		r.ResolvedBy = &resolvedBy.String
+	}
+	if resolvedAt.Valid {
+		r.ResolvedAt = &resolvedAt.Int64
+	}
+	return r, err
+}
+
+// Reports lists reports with the given status (all if empty), oldest first
+// so the queue is worked in order
+func (s *MessageStore) Reports(status string, limit int) ([]Report, error) {
+	query := reportQuery
+	var args []interface{}
+	if status != "" {
+		query += " WHERE r.status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY r.id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Report{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// ResolveReport closes an open report; sql.ErrNoRows if there is no open
+// report with that ID
+func (s *MessageStore) ResolveReport(id int64, status, moderatorID string) (Report, error) {
+	res, err := s.DB.Exec(`UPDATE chat_reports SET status = ?, resolved_by = ?, resolved_at = ?
+		WHERE id = ? AND status = ?`, status, moderatorID, time.Now().Unix(), id, ReportOpen)
+	if err != nil {
+		return Report{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Report{}, sql.ErrNoRows
+	}
+	return scanReport(s.DB.QueryRow(reportQuery+" WHERE r.id = ?", id))
+}
+
+// LogModeration adds an action to the audit log
+func (s *MessageStore) LogModeration(moderatorID, action string, m Moderation, detail string) error {
+	var messageID interface{}
+	if m.MessageID != 0 {
+		messageID = m.MessageID
+	}
+	_, err := s.DB.Exec(`INSERT INTO moderation_log (moderator_id, action, room, target_user_id, message_id, reason, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, moderatorID, action, m.Room, m.UserID, messageID, m.Reason, detail, time.Now().Unix())
+	return err
+}
+
+// ModerationLog returns up to limit log entries older than the before ID
+// (all if before is 0), newest first
+func (s *MessageStore) ModerationLog(before int64, limit int) ([]LogEntry, error) {
+	query := `SELECT id, moderator_id, action, room, target_user_id, message_id, reason, detail, created_at
+		FROM moderation_log`
+	var args []interface{}
+	if before > 0 {
+		query += " WHERE id < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []LogEntry{}
+	for rows.Next() {
+		var e LogEntry
+		var messageID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.ModeratorID, &e.Action, &e.Room, &e.TargetUserID, &messageID,
+			&e.Reason, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if messageID.Valid {
+			e.MessageID = &messageID.Int64
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// --- Hub ---
+
+// Moderate applies a moderator action on every instance. The caller has
+// already stored it.
+func (h *Hub) Moderate(m Moderation) {
+	h.onHub(func() {
+		h.apply(m)
+		if h.Backplane == nil {
+			return
+		}
+		msg, err := backplane.NewMessage(backplane.TopicModeration, h.ID, m)
+		if err == nil {
+			err = h.Backplane.Publish(msg)
+		}
+		if err != nil {
+			log.Printf("Chat backplane error: %v", err)
+		}
+	})
+}
+
+// RoomSettings returns a room's moderation settings
+func (h *Hub) RoomSettings(room string) RoomSettings {
+	var rs RoomSettings
+	h.onHub(func() { rs = h.roomSettings(room) })
+	return rs
+}
+
+func (h *Hub) roomSettings(room string) RoomSettings {
+	if rs, ok := h.settings[room]; ok {
+		return rs
+	}
+	return defaultRoomSettings
+}
+
+// apply updates this instance's moderation state and tells the clients
+// concerned. Removals are queued, as in drop.
+func (h *Hub) apply(m Moderation) {
+	data, err := json.Marshal(Envelope{Type: TypeModeration, Room: m.Room, Moderation: &m, Timestamp: time.Now().Unix()})
+	if err != nil {
+		log.Printf("Chat encode error: %v", err)
+		return
+	}
+
+	switch m.Action {
+	case ActionDelete:
+		h.sendLocal(m.Room, data, true)
+	case ActionMute:
+		h.sanctions[sanctionKey{SanctionMute, m.UserID, m.Room}] = m.Until
+		h.sendUsersLocal([]string{m.UserID}, data)
+	case ActionUnmute:
+		delete(h.sanctions, sanctionKey{SanctionMute, m.UserID, m.Room})
+		h.sendUsersLocal([]string{m.UserID}, data)
+	case ActionBan:
+		h.sanctions[sanctionKey{SanctionBan, m.UserID, m.Room}] = m.Until
+		h.sendUsersLocal([]string{m.UserID}, data)
+		if u := h.users[m.UserID]; u != nil {
+			for client := range u.clients {
+				if client.rooms[m.Room] && h.remove(client, m.Room) {
+					h.pending = append(h.pending, h.presenceEvent(StatusLeft, m.Room, client))
+				}
+			}
+		}
+	case ActionUnban:
+		delete(h.sanctions, sanctionKey{SanctionBan, m.UserID, m.Room})
+		h.sendUsersLocal([]string{m.UserID}, data)
+	case ActionSettings:
+		if m.Settings == nil {
+			return
+		}
+		h.settings[m.Room] = *m.Settings
+		if !m.Settings.Guests {
+			for client := range h.Rooms[m.Room] {
+				if client.UserID == GuestID {
+					h.remove(client, m.Room)
+					h.reject(client, m.Room, CodeGuestsClosed, "This room is now closed to guests")
+				}
+			}
+		}
+		h.sendLocal(m.Room, data, true)
+	}
+}
+
+// sanctioned reports whether a mute or ban is in force, and until when
+// (0 means until lifted)
+func (h *Hub) sanctioned(kind, userID, room string) (int64, bool) {
+	key := sanctionKey{kind, userID, room}
+	until, ok := h.sanctions[key]
+	if ok && until != 0 && until <= time.Now().Unix() {
+		delete(h.sanctions, key)
+		return 0, false
+	}
+	return until, ok
+}
+
+// muted checks the room mute first, then the one covering everything
+func (h *Hub) muted(userID, room string) (int64, bool) {
+	if until, ok := h.sanctioned(SanctionMute, userID, room); ok || room == "" {
+		return until, ok
+	}
+	return h.sanctioned(SanctionMute, userID, "")
+}
+
+// joinRefusal says why client may not join room, or nil if it may
+func (h *Hub) joinRefusal(client *Client, room string) *System {
+	if client.UserID == GuestID && !h.roomSettings(room).Guests {
+		return refusal(CodeGuestsClosed, "This room is closed to guests")
+	}
+	if until, banned := h.sanctioned(SanctionBan, client.UserID, room); banned {
+		return refusal(CodeBanned, "You are banned from this room"+untilText(until))
+	}
+	return nil
+}
+
+// ReadRefusal says why userID may not read a room's history, or nil if they
+// may: the rules for joining it apply
+func (h *Hub) ReadRefusal(userID, room string) *System {
+	var sys *System
+	h.onHub(func() { sys = h.joinRefusal(&Client{UserID: userID}, room) })
+	return sys
+}
+
+// postRefusal says why client may not post in room now (mute, slow mode or
+// rate limit), or nil if it may. room is "" for direct messages.
+func (h *Hub) postRefusal(client *Client, room string) *System {
+	if until, muted := h.muted(client.UserID, room); muted {
+		return refusal(CodeMuted, "You are muted"+untilText(until))
+	}
+	if room != "" && !client.Moderator {
+		slow := time.Duration(h.roomSettings(room).SlowMode) * time.Second
+		if wait := time.Until(h.lastPost[client.UserID+" "+room].Add(slow)); wait > 0 {
+			secs := int(math.Ceil(wait.Seconds()))
+			sys := refusal(CodeSlowMode, fmt.Sprintf("Slow mode is on: wait %d more seconds", secs))
+			sys.RetryAfter = secs
+			return sys
+		}
+	}
+	return h.rateLimit(client)
+}
+
+// posted starts the slow mode clock for a user in a room
+func (h *Hub) posted(client *Client, room string) {
+	if h.roomSettings(room).SlowMode > 0 {
+		h.lastPost[client.UserID+" "+room] = time.Now()
+	}
+}
+
+// pruneModeration forgets slow mode clocks that can no longer matter
+func (h *Hub) pruneModeration() {
+	for key, at := range h.lastPost {
+		if time.Since(at) > MaxSlowMode {
+			delete(h.lastPost, key)
+		}
+	}
+}
+
+func untilText(until int64) string {
+	if until == 0 {
+		return " until a moderator lifts it"
+	}
+	return " until " + time.Unix(until, 0).UTC().Format("2006-01-02 15:04 UTC")
+}
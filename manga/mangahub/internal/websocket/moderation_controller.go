@@ -0,0 +1,346 @@
+package socket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mangahub/internal/auth"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModerationController holds the moderator endpoints (behind chat:moderate)
+// and message reports (any signed-in user)
+type ModerationController struct {
+	Hub *Hub
+}
+
+type sanctionInput struct {
+	UserID   string `json:"user_id" binding:"required"`
+	Room     string `json:"room"`
+	Duration int64  `json:"duration"` // Seconds; 0 means until lifted
+	Reason   string `json:"reason"`
+}
+
+// record writes the audit log; a failure is logged but does not undo the action
+func (mc *ModerationController) record(moderatorID, action string, m Moderation, detail string) {
+	if err := mc.Hub.Store.LogModeration(moderatorID, action, m, detail); err != nil {
+		log.Printf("Moderation log error: %v", err)
+	}
+}
+
+// DELETE /chat/messages/:id?reason=
+// Removes a room message from history and from everyone's screen.
+func (mc *ModerationController) DeleteMessage(c *gin.Context) {
+	moderatorID := fmt.Sprintf("%v", c.MustGet("user_id"))
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	msg, err := mc.Hub.Store.DeleteMessage(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		return
+	}
+
+	m := Moderation{Action: ActionDelete, Room: msg.Room, UserID: msg.UserID, MessageID: msg.ID, Reason: c.Query("reason")}
+	mc.record(moderatorID, ActionDelete, m, msg.Message)
+	mc.Hub.Moderate(m)
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+}
+
+// POST /chat/mutes
+// {"user_id": "42", "room": "manga:1", "duration": 600, "reason": "spam"}
+// Without a room the mute covers every room and direct messages.
+func (mc *ModerationController) Mute(c *gin.Context) {
+	mc.sanction(c, SanctionMute, ActionMute)
+}
+
+// POST /chat/bans
+// {"user_id": "42", "room": "manga:1", "duration": 86400, "reason": "spoilers"}
+// The user is removed from the room and cannot rejoin until the ban ends.
+func (mc *ModerationController) Ban(c *gin.Context) {
+	mc.sanction(c, SanctionBan, ActionBan)
+}
+
+func (mc *ModerationController) sanction(c *gin.Context, kind, action string) {
+	moderatorID := fmt.Sprintf("%v", c.MustGet("user_id"))
+
+	var input sanctionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if kind == SanctionBan && input.Room == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A ban needs a room"})
+		return
+	}
+	if input.Room != "" && !ValidRoom(input.Room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown room name"})
+		return
+	}
+	if input.Duration < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Duration cannot be negative"})
+		return
+	}
+	if input.UserID == moderatorID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot " + kind + " yourself"})
+		return
+	}
+	role, err := mc.Hub.Store.Role(input.UserID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + kind + " user"})
+		return
+	}
+	// Moderators answer to those above them, not to each other
+	if auth.RoleRank(role) >= auth.RoleRank(c.GetString("role")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot " + kind + " a user whose role is equal to or above yours"})
+		return
+	}
+
+	m := Moderation{Action: action, Room: input.Room, UserID: input.UserID, Reason: input.Reason}
+	if input.Duration > 0 {
+		m.Until = time.Now().Unix() + input.Duration
+	}
+	if err := mc.Hub.Store.AddSanction(kind, m, moderatorID); err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + kind + " user"})
+		return
+	}
+	mc.record(moderatorID, action, m, "")
+	mc.Hub.Moderate(m)
+	c.JSON(http.StatusOK, m)
+}
+
+// DELETE /chat/mutes/:user_id?room=
+func (mc *ModerationController) Unmute(c *gin.Context) {
+	mc.lift(c, SanctionMute, ActionUnmute)
+}
+
+// DELETE /chat/bans/:user_id?room=
+func (mc *ModerationController) Unban(c *gin.Context) {
+	mc.lift(c, SanctionBan, ActionUnban)
+}
+
+func (mc *ModerationController) lift(c *gin.Context, kind, action string) {
+	moderatorID := fmt.Sprintf("%v", c.MustGet("user_id"))
+	m := Moderation{Action: action, Room: c.Query("room"), UserID: c.Param("user_id")}
+
+	found, err := mc.Hub.Store.LiftSanction(kind, m.UserID, m.Room)
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lift " + kind})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No " + kind + " in force"})
+		return
+	}
+	mc.record(moderatorID, action, m, "")
+	mc.Hub.Moderate(m)
+	c.JSON(http.StatusOK, gin.H{"message": "Lifted " + kind})
+}
+
+// GET /chat/sanctions
+// Mutes and bans in force.
+func (mc *ModerationController) ListSanctions(c *gin.Context) {
+	list, err := mc.Hub.Store.Sanctions()
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sanctions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sanctions": list})
+}
+
+// GET /chat/rooms/:id/settings
+func (mc *ModerationController) GetRoomSettings(c *gin.Context) {
+	room := c.Param("id")
+	if !ValidRoom(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown room name"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "settings": mc.Hub.RoomSettings(room)})
+}
+
+// PUT /chat/rooms/:id/settings
+// {"slow_mode": 30, "guests": false}; fields left out keep their value.
+func (mc *ModerationController) UpdateRoomSettings(c *gin.Context) {
+	moderatorID := fmt.Sprintf("%v", c.MustGet("user_id"))
+	room := c.Param("id")
+	if !ValidRoom(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown room name"})
+		return
+	}
+
+	var input struct {
+		SlowMode *int  `json:"slow_mode"`
+		Guests   *bool `json:"guests"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rs := mc.Hub.RoomSettings(room)
+	if input.SlowMode != nil {
+		if *input.SlowMode < 0 || *input.SlowMode > int(MaxSlowMode/time.Second) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("slow_mode must be 0 to %d seconds", int(MaxSlowMode/time.Second))})
+			return
+		}
+		rs.SlowMode = *input.SlowMode
+	}
+	if input.Guests != nil {
+		rs.Guests = *input.Guests
+	}
+
+	if err := mc.Hub.Store.SaveRoomSettings(room, rs); err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings"})
+		return
+	}
+	m := Moderation{Action: ActionSettings, Room: room, Settings: &rs}
+	detail, _ := json.Marshal(rs)
+	mc.record(moderatorID, ActionSettings, m, string(detail))
+	mc.Hub.Moderate(m)
+	c.JSON(http.StatusOK, gin.H{"room": room, "settings": rs})
+}
+
+// POST /chat/messages/:id/report
+// {"reason": "spoilers"}. Any signed-in user; one report per message each.
+func (mc *ModerationController) ReportMessage(c *gin.Context) {
+	userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if len(input.Reason) > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is too long"})
+		return
+	}
+
+	msg, err := mc.Hub.Store.Message(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err == nil && msg.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot report your own message"})
+		return
+	}
+	var reportID int64
+	if err == nil {
+		reportID, err = mc.Hub.Store.Report(id, userID, input.Reason)
+	}
+	if err == ErrAlreadyReported {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already reported this message"})
+		return
+	}
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to report message"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": reportID, "message": "Reported, a moderator will take a look"})
+}
+
+// GET /chat/reports?status=open&limit=50
+// The report queue, oldest first. status may be open (default), resolved,
+// dismissed or all.
+func (mc *ModerationController) ListReports(c *gin.Context) {
+	status := c.DefaultQuery("status", ReportOpen)
+	switch status {
+	case ReportOpen, ReportResolved, ReportDismissed:
+	case "all":
+		status = ""
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	list, err := mc.Hub.Store.Reports(status, limit)
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load reports"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reports": list})
+}
+
+// PUT /chat/reports/:id
+// {"status": "resolved"} or {"status": "dismissed"}. Deleting the message or
+// sanctioning its author are separate actions.
+func (mc *ModerationController) ResolveReport(c *gin.Context) {
+	moderatorID := fmt.Sprintf("%v", c.MustGet("user_id"))
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var input struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Status != ReportResolved && input.Status != ReportDismissed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be resolved or dismissed"})
+		return
+	}
+
+	report, err := mc.Hub.Store.ResolveReport(id, input.Status, moderatorID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open report with that ID"})
+		return
+	}
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update report"})
+		return
+	}
+	m := Moderation{Room: report.Message.Room, UserID: report.Message.UserID, MessageID: report.Message.ID}
+	mc.record(moderatorID, "report_"+input.Status, m, fmt.Sprintf("report %d", report.ID))
+	c.JSON(http.StatusOK, report)
+}
+
+// GET /chat/moderation/log?before=<id>&limit=100
+// The moderation audit log, newest first.
+func (mc *ModerationController) Log(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	before, _ := strconv.ParseInt(c.Query("before"), 10, 64)
+
+	entries, err := mc.Hub.Store.ModerationLog(before, limit)
+	if err != nil {
+		log.Printf("Chat store error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load the moderation log"})
+		return
+	}
+	var next interface{}
+	if len(entries) == limit {
+		next = entries[len(entries)-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "next_before": next})
+}
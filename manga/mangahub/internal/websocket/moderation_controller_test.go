@@ -0,0 +1,119 @@
+package socket
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mangahub/pkg/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// moderationTestServer runs the chat REST routes against a fresh database.
+// Requests act as the user in the X-Test-User header, with the role in
+// X-Test-Role; without them they are anonymous.
+func moderationTestServer(t *testing.T) (*gin.Engine, *sql.DB) {
+	t.Helper()
+	t.Chdir(t.TempDir()) // InitDB creates data/mangahub.db in the working directory
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	h := NewChatHub(db)
+	go h.Run()
+
+	session := func(c *gin.Context) {
+		if id := c.GetHeader("X-Test-User"); id != "" {
+			c.Set("user_id", id)
+			c.Set("role", c.GetHeader("X-Test-Role"))
+		}
+	}
+	mc := &ModerationController{Hub: h}
+	cc := &ChatController{Hub: h}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(session)
+	r.POST("/chat/mutes", mc.Mute)
+	r.POST("/chat/bans", mc.Ban)
+	r.PUT("/chat/rooms/:id/settings", mc.UpdateRoomSettings)
+	r.GET("/chat/rooms/:id/messages", cc.Messages)
+	return r, db
+}
+
+func addUser(t *testing.T, db *sql.DB, username, role string) string {
+	t.Helper()
+	res, err := db.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, '', ?)", username, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return fmt.Sprint(id)
+}
+
+func call(r *gin.Engine, method, path, userID, role string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if userID != "" {
+		req.Header.Set("X-Test-User", userID)
+		req.Header.Set("X-Test-Role", role)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSanctionNeedsHigherRole(t *testing.T) {
+	r, db := moderationTestServer(t)
+	user := addUser(t, db, "user", "user")
+	mod := addUser(t, db, "mod", "moderator")
+	otherMod := addUser(t, db, "mod2", "moderator")
+	admin := addUser(t, db, "admin", "admin")
+
+	for _, tc := range []struct {
+		by, role, target string
+		want             int
+	}{
+		{mod, "moderator", user, http.StatusOK},
+		{mod, "moderator", otherMod, http.StatusForbidden},
+		{mod, "moderator", admin, http.StatusForbidden},
+		{admin, "admin", otherMod, http.StatusOK},
+	} {
+		w := call(r, "POST", "/chat/mutes", tc.by, tc.role, gin.H{"user_id": tc.target, "duration": 60})
+		if w.Code != tc.want {
+			t.Errorf("%s muting %s: %d %s, want %d", tc.role, tc.target, w.Code, w.Body, tc.want)
+		}
+	}
+}
+
+func TestMessagesFollowJoinRules(t *testing.T) {
+	r, db := moderationTestServer(t)
+	user := addUser(t, db, "user", "user")
+	mod := addUser(t, db, "mod", "moderator")
+	room := "/chat/rooms/manga:1/messages"
+
+	if w := call(r, "GET", room, "", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("guest reading an open room: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "PUT", "/chat/rooms/manga:1/settings", mod, "moderator", gin.H{"guests": false}); w.Code != http.StatusOK {
+		t.Fatalf("closing the room: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "GET", room, "", "", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("guest reading a closed room: %d %s", w.Code, w.Body)
+	}
+
+	if w := call(r, "GET", room, user, "user", nil); w.Code != http.StatusOK {
+		t.Fatalf("user reading: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "POST", "/chat/bans", mod, "moderator", gin.H{"user_id": user, "room": "manga:1"}); w.Code != http.StatusOK {
+		t.Fatalf("ban: %d %s", w.Code, w.Body)
+	}
+	if w := call(r, "GET", room, user, "user", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("banned user reading: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,259 @@
+package socket
+
+import (
+	"log"
+	"mangahub/internal/backplane"
+	"sort"
+	"time"
+)
+
+// GuestID is the user ID of every guest connection. Guests are counted in
+// rooms but have no presence of their own.
+const GuestID = "GUEST"
+
+// Presence between instances: snapshots go out within a second of a change
+// and at least every heartbeat; an instance silent for presenceExpiry is forgotten
+const (
+	presenceInterval  = time.Second
+	presenceHeartbeat = 15 * time.Second
+	presenceExpiry    = 3 * presenceHeartbeat
+)
+
+// OnlineUser is one entry of GET /chat/presence
+type OnlineUser struct {
+	UserID   string   `json:"user_id"`
+	Username string   `json:"username"`
+	Rooms    []string `json:"rooms"`
+}
+
+// userPresence is one signed-in user on this instance, over all their tabs
+type userPresence struct {
+	Username string
+	clients  map[*Client]bool     // Their connections, for direct messages
+	rooms    map[string]int       // Open connections per room
+	typing   map[string]time.Time // Last typing event passed on, per room
+}
+
+// presenceSnapshot is who is where on one instance
+type presenceSnapshot struct {
+	Users  map[string]OnlineUser `json:"users"`  // By user ID
+	Guests map[string]int        `json:"guests"` // Guest connections per room
+	seen   time.Time
+}
+
+// connect counts a new connection of a signed-in user
+func (h *Hub) connect(client *Client) {
+	if client.UserID == GuestID {
+		return
+	}
+	u := h.users[client.UserID]
+	if u == nil {
+		u = &userPresence{
+			Username: client.Username,
+			clients:  make(map[*Client]bool),
+			rooms:    make(map[string]int),
+			typing:   make(map[string]time.Time),
+		}
+		h.users[client.UserID] = u
+	}
+	u.clients[client] = true
+}
+
+// disconnect forgets a connection once it has left all its rooms
+func (h *Hub) disconnect(client *Client) {
+	u := h.users[client.UserID]
+	if u == nil {
+		return
+	}
+	delete(u.clients, client)
+	if len(u.clients) == 0 {
+		delete(h.users, client.UserID)
+	}
+}
+
+// entered counts client into a room and reports whether it is the user's
+// first connection there, i.e. whether the room should hear about it
+func (h *Hub) entered(client *Client, room string) bool {
+	u := h.users[client.UserID]
+	if u == nil {
+		return false
+	}
+	u.rooms[room]++
+	return u.rooms[room] == 1
+}
+
+// exited is the reverse of entered: true when the user's last connection
+// in the room is gone
+func (h *Hub) exited(client *Client, room string) bool {
+	u := h.users[client.UserID]
+	if u == nil {
+		return false
+	}
+	u.rooms[room]--
+	if u.rooms[room] > 0 {
+		return false
+	}
+	delete(u.rooms, room)
+	delete(u.typing, room)
+	return true
+}
+
+// typingAllowed applies the per-user, per-room typing throttle
+func (h *Hub) typingAllowed(client *Client, room string) bool {
+	u := h.users[client.UserID]
+	if u == nil || time.Since(u.typing[room]) < TypingThrottle {
+		return false
+	}
+	u.typing[room] = time.Now()
+	return true
+}
+
+func (h *Hub) presenceEvent(status, room string, client *Client) Envelope {
+	return Envelope{
+		Type:      TypePresence,
+		Room:      room,
+		Presence:  &Presence{UserID: client.UserID, Username: client.Username, Status: status},
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+func (h *Hub) localPresence() presenceSnapshot {
+	p := presenceSnapshot{
+		Users:  make(map[string]OnlineUser, len(h.users)),
+		Guests: make(map[string]int),
+	}
+	for id, u := range h.users {
+		rooms := make([]string, 0, len(u.rooms))
+		for room := range u.rooms {
+			rooms = append(rooms, room)
+		}
+		p.Users[id] = OnlineUser{UserID: id, Username: u.Username, Rooms: rooms}
+	}
+	for room, members := range h.Rooms {
+		for client := range members {
+			if client.UserID == GuestID {
+				p.Guests[room]++
+			}
+		}
+	}
+	return p
+}
+
+// snapshots is this instance's presence plus that of every live instance
+// on the backplane
+func (h *Hub) snapshots() []presenceSnapshot {
+	all := []presenceSnapshot{h.localPresence()}
+	for id, p := range h.remote {
+		if time.Since(p.seen) > presenceExpiry {
+			delete(h.remote, id)
+			continue
+		}
+		all = append(all, p)
+	}
+	return all
+}
+
+// publishPresence shares this instance's snapshot when it changed, or as a
+// heartbeat so the others know it is still there
+func (h *Hub) publishPresence() {
+	if h.Backplane == nil || (!h.presenceDirty && time.Since(h.presenceSent) < presenceHeartbeat) {
+		return
+	}
+	msg, err := backplane.NewMessage(backplane.TopicPresence, h.ID, h.localPresence())
+	if err == nil {
+		err = h.Backplane.Publish(msg)
+	}
+	if err != nil {
+		log.Printf("Chat backplane error: %v", err)
+		return
+	}
+	h.presenceDirty = false
+	h.presenceSent = time.Now()
+}
+
+// ListRooms returns the rooms that currently have members on any instance,
+// busiest first. A user with several tabs counts once; guests count per tab.
+func (h *Hub) ListRooms() []RoomInfo {
+	var rooms []RoomInfo
+	h.onHub(func() {
+		users := make(map[string]map[string]bool)
+		counts := make(map[string]int)
+		for _, p := range h.snapshots() {
+			for id, u := range p.Users {
+				for _, room := range u.Rooms {
+					if users[room] == nil {
+						users[room] = make(map[string]bool)
+					}
+					users[room][id] = true
+				}
+			}
+			for room, n := range p.Guests {
+				counts[room] += n
+			}
+		}
+		for room, ids := range users {
+			counts[room] += len(ids)
+		}
+		rooms = make([]RoomInfo, 0, len(counts))
+		for name, members := range counts {
+			rooms = append(rooms, RoomInfo{Name: name, Members: members})
+		}
+	})
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Members != rooms[j].Members {
+			return rooms[i].Members > rooms[j].Members
+		}
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms
+}
+
+// Online returns the signed-in users connected to any instance who share a
+// room with viewerID, by username, listing only the rooms they share; with
+// room set, only those in that room. It reports false when the viewer is
+// not in room (or, without one, in any room).
+func (h *Hub) Online(viewerID, room string) ([]OnlineUser, bool) {
+	merged := make(map[string]*OnlineUser)
+	rooms := make(map[string]map[string]bool)
+	h.onHub(func() {
+		for _, p := range h.snapshots() {
+			for id, u := range p.Users {
+				if merged[id] == nil {
+					merged[id] = &OnlineUser{UserID: id, Username: u.Username}
+					rooms[id] = make(map[string]bool)
+				}
+				for _, r := range u.Rooms {
+					rooms[id][r] = true
+				}
+			}
+		}
+	})
+
+	visible := rooms[viewerID]
+	if room != "" {
+		if !visible[room] {
+			return nil, false
+		}
+		visible = map[string]bool{room: true}
+	}
+	if len(visible) == 0 {
+		return nil, false
+	}
+
+	list := []OnlineUser{}
+	for id, u := range merged {
+		u.Rooms = []string{}
+		for r := range rooms[id] {
+			if visible[r] {
+				u.Rooms = append(u.Rooms, r)
+			}
+		}
+		if len(u.Rooms) == 0 {
+			continue
+		}
+		sort.Strings(u.Rooms)
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list, true
+}
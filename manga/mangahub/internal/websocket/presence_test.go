@@ -0,0 +1,39 @@
+package socket
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOnlineOnlyShowsSharedRooms(t *testing.T) {
+	h := NewChatHub(nil)
+	go h.Run()
+	for _, c := range []*Client{testClient(h, "1"), testClient(h, "2"), testClient(h, "3")} {
+		drain(c)
+		switch c.UserID {
+		case "1":
+			h.Handle(c, ClientFrame{Type: TypeJoin, Room: "manga:1"})
+		case "3":
+			h.Handle(c, ClientFrame{Type: TypeLeave, Room: DefaultRoom})
+			h.Handle(c, ClientFrame{Type: TypeJoin, Room: "manga:2"})
+		}
+	}
+
+	online, ok := h.Online("2", "")
+	want := []OnlineUser{
+		{UserID: "1", Username: "user1", Rooms: []string{DefaultRoom}},
+		{UserID: "2", Username: "user2", Rooms: []string{DefaultRoom}},
+	}
+	if !ok || !reflect.DeepEqual(online, want) {
+		t.Fatalf("user 2 sees %+v, want %+v", online, want)
+	}
+	if _, ok := h.Online("2", "manga:1"); ok {
+		t.Fatal("user 2 sees a room they are not in")
+	}
+	if online, ok := h.Online("1", "manga:1"); !ok || len(online) != 1 || online[0].UserID != "1" {
+		t.Fatalf("user 1 sees %+v in manga:1", online)
+	}
+	if _, ok := h.Online("4", ""); ok {
+		t.Fatal("a user without a connection sees who is online")
+	}
+}
@@ -0,0 +1,109 @@
+package socket
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits on what a client may send. A frame larger than MaxFrameBytes closes
+// the connection (1009); the others are refused with an error frame.
+const (
+	MaxMessageLength = 1000 // Characters per room or direct message
+	MaxFrameBytes    = 8 << 10
+
+	DefaultMessageRate  = 1.0 // Messages per second per user, sustained
+	DefaultMessageBurst = 5   // Messages a user may send at once
+	DefaultFrameRate    = 10.0
+	DefaultFrameBurst   = 20
+
+	DefaultRoomChangeRate  = 1.0 // Joins and leaves per second per connection, sustained
+	DefaultRoomChangeBurst = MaxRoomsPerClient
+
+	DefaultMaxConnections = 10 // Chat connections per signed-in user on one gateway
+)
+
+// tokenBucket allows burst events at once and rate per second after that.
+// It is not safe for concurrent use.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token if there is one; otherwise it says how long until
+// there will be
+func (b *tokenBucket) take(rate float64, burst int) (bool, time.Duration) {
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled, so forgetting it changes nothing
+func (b *tokenBucket) full(rate float64, burst int) bool {
+	return b.tokens+time.Since(b.last).Seconds()*rate >= float64(burst)
+}
+
+// validText refuses empty and overlong messages
+func validText(text string) *System {
+	if strings.TrimSpace(text) == "" {
+		return refusal(CodeEmpty, "Message is empty")
+	}
+	if utf8.RuneCountInString(text) > MaxMessageLength {
+		return refusal(CodeTooLong, fmt.Sprintf("Message is too long (at most %d characters)", MaxMessageLength))
+	}
+	return nil
+}
+
+// rateLimit spends one of the user's message tokens, shared by all their
+// connections, or refuses with how long to wait
+func (h *Hub) rateLimit(client *Client) *System {
+	if h.MessageRate <= 0 {
+		return nil
+	}
+	b := h.buckets[client.UserID]
+	if b == nil {
+		b = &tokenBucket{}
+		h.buckets[client.UserID] = b
+	}
+	if ok, wait := b.take(h.MessageRate, h.MessageBurst); !ok {
+		sys := refusal(CodeRateLimited, "You are sending messages too fast")
+		sys.RetryAfter = int(math.Ceil(wait.Seconds()))
+		return sys
+	}
+	return nil
+}
+
+// roomChangeLimit spends one of the connection's join and leave tokens, or
+// refuses with how long to wait. Every change is announced to the room and
+// a join loads its history, so they are limited like messages.
+func (h *Hub) roomChangeLimit(client *Client) *System {
+	if h.RoomChangeRate <= 0 {
+		return nil
+	}
+	if ok, wait := client.roomChanges.take(h.RoomChangeRate, h.RoomChangeBurst); !ok {
+		sys := refusal(CodeRateLimited, "You are joining and leaving rooms too fast")
+		sys.RetryAfter = int(math.Ceil(wait.Seconds()))
+		return sys
+	}
+	return nil
+}
+
+// pruneBuckets forgets users whose buckets have refilled
+func (h *Hub) pruneBuckets() {
+	for id, b := range h.buckets {
+		if b.full(h.MessageRate, h.MessageBurst) {
+			delete(h.buckets, id)
+		}
+	}
+}
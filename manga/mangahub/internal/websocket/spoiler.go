@@ -0,0 +1,195 @@
+package socket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// Spoiler protection. A message in a manga room may be tagged with the
+// chapter it discusses. Readers whose user_progress.current_chapter is behind
+// that chapter get it masked, without its text, and can ask for the text with
+// a "reveal" frame. Guests have no progress, so every tagged message is
+// masked for them. Inline ||spoiler|| spans are markup for clients to hide
+// until clicked; the hub passes them through.
+
+// mangaOf returns the manga a room is about, or "" if it is not a manga room
+func mangaOf(room string) string {
+	if !strings.HasPrefix(room, "manga:") {
+		return ""
+	}
+	return strings.TrimPrefix(room, "manga:")
+}
+
+// validChapter refuses chapter tags outside manga rooms
+func validChapter(room string, chapter int) *System {
+	if chapter < 0 {
+		return refusal(CodeBadChapter, "Chapter must be a positive number")
+	}
+	if chapter > 0 && mangaOf(room) == "" {
+		return refusal(CodeBadChapter, "Chapter tags are only for manga rooms")
+	}
+	return nil
+}
+
+// Progress returns the chapter each of the users has read up to in a manga,
+// by user ID. Users who have not started it are left out.
+func (s *MessageStore) Progress(mangaID string, userIDs []string) (map[string]int, error) {
+	progress := make(map[string]int)
+	if len(userIDs) == 0 {
+		return progress, nil
+	}
+	args := []interface{}{mangaID}
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+	rows, err := s.DB.Query(`SELECT user_id, current_chapter FROM user_progress
+		WHERE manga_id = ? AND user_id IN (?`+strings.Repeat(", ?", len(userIDs)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var chapter sql.NullInt64
+		if err := rows.Scan(&id, &chapter); err != nil {
+			return nil, err
+		}
+		progress[id] = int(chapter.Int64)
+	}
+	return progress, rows.Err()
+}
+
+// MaskSpoilers masks the messages of room that are beyond how far userID has
+// read. Their own messages are never masked.
+func (s *MessageStore) MaskSpoilers(room, userID string, msgs []ChatMessage) error {
+	mangaID := mangaOf(room)
+	if mangaID == "" || !anyTagged(msgs) {
+		return nil
+	}
+	progress := map[string]int{}
+	if userID != GuestID {
+		var err error
+		if progress, err = s.Progress(mangaID, []string{userID}); err != nil {
+			return err
+		}
+	}
+	for i := range msgs {
+		if spoils(msgs[i], userID, progress) {
+			msgs[i] = msgs[i].masked()
+		}
+	}
+	return nil
+}
+
+func anyTagged(msgs []ChatMessage) bool {
+	for _, msg := range msgs {
+		if msg.Chapter > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// spoils reports whether msg is beyond what userID has read
+func spoils(msg ChatMessage, userID string, progress map[string]int) bool {
+	return msg.Chapter > 0 && msg.UserID != userID && msg.Chapter > progress[userID]
+}
+
+// masked is msg as shown to a reader who has not reached its chapter
+func (msg ChatMessage) masked() ChatMessage {
+	msg.Message = ""
+	msg.Masked = true
+	return msg
+}
+
+// tagged reports whether env is a chapter-tagged room message
+func tagged(env Envelope) bool {
+	return env.Type == TypeMessage && env.Message != nil && env.Message.Chapter > 0
+}
+
+// sendMessage delivers a room message, already encoded as data, to this
+// instance's members of its room, masked for those who have not read as far
+// as its chapter tag. Their progress is looked up on the store worker, and
+// untagged messages queue there too, so none overtakes a tagged one still
+// waiting. Without their progress everyone but the author gets it masked.
+func (h *Hub) sendMessage(env Envelope, data []byte) {
+	var members []*Client
+	var ids []string
+	seen := make(map[string]bool)
+	for client := range h.Rooms[env.Room] {
+		members = append(members, client)
+		if client.UserID != GuestID && !seen[client.UserID] {
+			seen[client.UserID] = true
+			ids = append(ids, client.UserID)
+		}
+	}
+	deliver := func(progress map[string]int) {
+		msg := *env.Message
+		var masked []byte
+		if tagged(env) {
+			hidden := msg.masked()
+			env.Message = &hidden
+			var err error
+			if masked, err = json.Marshal(env); err != nil {
+				log.Printf("Chat encode error: %v", err)
+				return
+			}
+		}
+		// Members who left meanwhile are skipped, and so are those whose
+		// history on joining already had the message
+		for _, client := range members {
+			switch {
+			case !client.rooms[env.Room] || msg.ID != 0 && msg.ID <= client.historyTo[env.Room]:
+			case spoils(msg, client.UserID, progress):
+				h.enqueue(client, masked)
+			default:
+				h.enqueue(client, data)
+			}
+		}
+	}
+
+	mangaID := mangaOf(env.Room)
+	queued := h.persist(func() func() {
+		var progress map[string]int
+		if tagged(env) && h.Store != nil {
+			var err error
+			if progress, err = h.Store.Progress(mangaID, ids); err != nil {
+				log.Printf("Chat progress error: %v", err)
+			}
+		}
+		return func() { deliver(progress) }
+	})
+	if !queued {
+		deliver(nil)
+	}
+}
+
+// reveal sends a member of a message's room its text, for a masked message
+// they chose to read anyway
+func (h *Hub) reveal(client *Client, id int64) {
+	if h.Store == nil {
+		h.reject(client, "", CodeUnavailable, "Messages cannot be revealed here")
+		return
+	}
+	queued := h.persist(func() func() {
+		msg, err := h.Store.Message(id)
+		return func() {
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Chat store error: %v", err)
+				h.reject(client, "", CodeUnavailable, "Message could not be loaded, try again")
+				return
+			}
+			if err == sql.ErrNoRows || !client.rooms[msg.Room] {
+				h.reject(client, "", CodeNoSuchMessage, "No such message in your rooms")
+				return
+			}
+			h.deliver(client, Envelope{Type: TypeReveal, Room: msg.Room, Message: &msg, Timestamp: time.Now().Unix()})
+		}
+	})
+	if !queued {
+		h.busy(client, "")
+	}
+}
@@ -0,0 +1,135 @@
+package socket
+
+import (
+	"mangahub/pkg/database"
+	"testing"
+	"time"
+)
+
+// spoilerTestHub runs a hub with a fresh store, where user 1 has read up to
+// chapter 2 of manga "op", user 2 up to chapter 7 and user 3 up to chapter 3
+func spoilerTestHub(t *testing.T) *Hub {
+	t.Helper()
+	t.Chdir(t.TempDir()) // InitDB creates data/mangahub.db in the working directory
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`INSERT INTO user_progress (user_id, manga_id, current_chapter, status) VALUES
+		('1', 'op', 2, 'reading'), ('2', 'op', 7, 'reading'), ('3', 'op', 3, 'reading')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewChatHub(db)
+	go h.Run()
+	return h
+}
+
+// await returns the next frame of the given type, skipping presence and the like
+func await(t *testing.T, got <-chan Envelope, typ string) Envelope {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case env := <-got:
+			if env.Type == typ {
+				return env
+			}
+		case <-deadline:
+			t.Fatalf("no %s frame", typ)
+		}
+	}
+}
+
+// joined connects userID, joins room and waits for that room's history
+// (clients start in the default room, which sends its own)
+func joined(t *testing.T, h *Hub, userID, room string) (*Client, <-chan Envelope, Envelope) {
+	t.Helper()
+	c := testClient(h, userID)
+	got := frames(c)
+	h.Handle(c, ClientFrame{Type: TypeJoin, Room: room})
+	for {
+		if history := await(t, got, TypeHistory); history.Room == room {
+			return c, got, history
+		}
+	}
+}
+
+func TestChapterTaggedMessagesAreMaskedByProgress(t *testing.T) {
+	h := spoilerTestHub(t)
+	author, authorGot, _ := joined(t, h, "1", "manga:op")
+	_, aheadGot, _ := joined(t, h, "2", "manga:op")
+	_, behindGot, _ := joined(t, h, "3", "manga:op")
+	_, unreadGot, _ := joined(t, h, "4", "manga:op") // Has not started the manga
+	_, guestGot, _ := joined(t, h, GuestID, "manga:op")
+
+	// The author is only at chapter 2, but never has their own message hidden
+	h.Handle(author, ClientFrame{Room: "manga:op", Message: "what a twist", Chapter: 5})
+	for name, got := range map[string]<-chan Envelope{
+		"author": authorGot, "ahead": aheadGot, "behind": behindGot, "unread": unreadGot, "guest": guestGot,
+	} {
+		msg := await(t, got, TypeMessage).Message
+		wantMasked := name != "author" && name != "ahead"
+		if msg.Masked != wantMasked || (msg.Message == "") != wantMasked || msg.Chapter != 5 || msg.ID == 0 {
+			t.Errorf("%s got %+v, masked should be %v", name, msg, wantMasked)
+		}
+	}
+
+	h.Handle(author, ClientFrame{Room: "manga:op", Message: "no spoilers here"})
+	if msg := await(t, guestGot, TypeMessage).Message; msg.Masked || msg.Message != "no spoilers here" {
+		t.Errorf("untagged message reached a guest as %+v", msg)
+	}
+}
+
+func TestHistoryIsMaskedForEachReader(t *testing.T) {
+	h := spoilerTestHub(t)
+	author, authorGot, _ := joined(t, h, "1", "manga:op")
+	h.Handle(author, ClientFrame{Room: "manga:op", Message: "what a twist", Chapter: 5})
+	await(t, authorGot, TypeMessage)
+
+	for _, tc := range []struct {
+		userID string
+		masked bool
+	}{
+		{"1", false}, // Their own message, in a second tab
+		{"2", false},
+		{"3", true},
+		{GuestID, true},
+	} {
+		_, _, history := joined(t, h, tc.userID, "manga:op")
+		if len(history.Messages) != 1 {
+			t.Fatalf("user %s got %d messages of history", tc.userID, len(history.Messages))
+		}
+		if msg := history.Messages[0]; msg.Masked != tc.masked || (msg.Message == "") != tc.masked {
+			t.Errorf("user %s got %+v in history, masked should be %v", tc.userID, msg, tc.masked)
+		}
+	}
+}
+
+func TestRevealIsForRoomMembers(t *testing.T) {
+	h := spoilerTestHub(t)
+	author, authorGot, _ := joined(t, h, "1", "manga:op")
+	reader, readerGot, _ := joined(t, h, "3", "manga:op")
+	h.Handle(author, ClientFrame{Room: "manga:op", Message: "what a twist", Chapter: 5})
+	await(t, authorGot, TypeMessage)
+	masked := await(t, readerGot, TypeMessage).Message
+	if !masked.Masked {
+		t.Fatalf("reader got %+v unmasked", masked)
+	}
+
+	h.Handle(reader, ClientFrame{Type: TypeReveal, ID: masked.ID})
+	if env := await(t, readerGot, TypeReveal); env.Message.Message != "what a twist" || env.Room != "manga:op" {
+		t.Fatalf("reveal got %+v", env.Message)
+	}
+
+	outsider, outsiderGot, _ := joined(t, h, "2", "manga:other")
+	h.Handle(outsider, ClientFrame{Type: TypeReveal, ID: masked.ID})
+	if env := await(t, outsiderGot, TypeSystem); env.System.Code != CodeNoSuchMessage {
+		t.Fatalf("outsider's reveal got %+v", env.System)
+	}
+	h.Handle(reader, ClientFrame{Type: TypeReveal, ID: masked.ID + 100})
+	if env := await(t, readerGot, TypeSystem); env.System.Code != CodeNoSuchMessage {
+		t.Fatalf("reveal of a missing message got %+v", env.System)
+	}
+}
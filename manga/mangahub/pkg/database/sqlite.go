@@ -54,11 +54,283 @@ func InitDB() (*sql.DB, error) {
     current_chapter INTEGER,
     status TEXT,
     PRIMARY KEY(user_id, manga_id)
-);`
+);
+	CREATE TABLE IF NOT EXISTS chapters (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		manga_id TEXT NOT NULL,
+		number INTEGER NOT NULL,
+		title TEXT,
+		created_at INTEGER NOT NULL,
+		UNIQUE(manga_id, number)
+	);
+	CREATE TABLE IF NOT EXISTS feed_tokens (
+		token TEXT PRIMARY KEY,
+		user_id TEXT UNIQUE NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		title TEXT NOT NULL,
+		body TEXT,
+		link TEXT,
+		read_at INTEGER,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, id);
+	CREATE TABLE IF NOT EXISTS follows (
+		user_id TEXT NOT NULL,
+		target_type TEXT NOT NULL,
+		target TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY(user_id, target_type, target)
+	);
+	CREATE INDEX IF NOT EXISTS idx_follows_target ON follows(target_type, target);
+	CREATE TABLE IF NOT EXISTS notification_prefs (
+		user_id TEXT PRIMARY KEY,
+		channels TEXT NOT NULL DEFAULT 'websocket',
+		webhook_url TEXT,
+		muted INTEGER NOT NULL DEFAULT 0,
+		digest INTEGER NOT NULL DEFAULT 0,
+		digest_sent_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		family_id TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		access_jti TEXT,
+		expires_at INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		used_at INTEGER,
+		revoked_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS signing_keys (
+		kid TEXT PRIMARY KEY,
+		alg TEXT NOT NULL,
+		private_pem TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		revoked_at INTEGER,
+		mfa INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+	CREATE TABLE IF NOT EXISTS email_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		used_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_tokens_user ON email_tokens(user_id, purpose);
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		user_id TEXT,
+		ip TEXT NOT NULL,
+		success INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON login_attempts(username, id);
+	CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip, created_at);
+	CREATE TABLE IF NOT EXISTS user_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		email TEXT,
+		created_at INTEGER NOT NULL,
+		last_login_at INTEGER NOT NULL,
+		created_user INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY(provider, subject)
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+	CREATE TABLE IF NOT EXISTS chat_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		room TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		message TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_chat_messages_room ON chat_messages(room, id);
+	CREATE TABLE IF NOT EXISTS direct_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sender_id TEXT NOT NULL,
+		sender_username TEXT NOT NULL,
+		recipient_id TEXT NOT NULL,
+		message TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		read_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_direct_messages_pair ON direct_messages(sender_id, recipient_id, id);
+	CREATE INDEX IF NOT EXISTS idx_direct_messages_recipient ON direct_messages(recipient_id, id);
+	CREATE TABLE IF NOT EXISTS user_blocks (
+		blocker_id TEXT NOT NULL,
+		blocked_id TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY(blocker_id, blocked_id)
+	);
+	CREATE TABLE IF NOT EXISTS chat_room_settings (
+		room TEXT PRIMARY KEY,
+		slow_mode INTEGER NOT NULL DEFAULT 0,
+		guests INTEGER NOT NULL DEFAULT 1,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS chat_sanctions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		room TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL DEFAULT '',
+		moderator_id TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0,
+		lifted_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_chat_sanctions_user ON chat_sanctions(user_id, kind, room);
+	CREATE TABLE IF NOT EXISTS chat_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL,
+		reporter_id TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'open',
+		created_at INTEGER NOT NULL,
+		resolved_by TEXT,
+		resolved_at INTEGER,
+		UNIQUE(message_id, reporter_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_chat_reports_status ON chat_reports(status, id);
+	CREATE TABLE IF NOT EXISTS moderation_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		moderator_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		room TEXT NOT NULL DEFAULT '',
+		target_user_id TEXT NOT NULL DEFAULT '',
+		message_id INTEGER,
+		reason TEXT NOT NULL DEFAULT '',
+		detail TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS mfa_challenges (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+	CREATE TABLE IF NOT EXISTS oidc_states (
+		state_hash TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		verifier TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);`
 
 	if _, err := db.Exec(query); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
+	// 6. Add columns that older databases were created without
+	if err := ensureColumn(db, "manga", "created_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "users", "email", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "users", "email_verified", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "users", "totp_secret", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "refresh_tokens", "mfa", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "user_identities", "created_user", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "api_keys", "mfa", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "chat_messages", "deleted_at", "INTEGER"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "chat_messages", "chapter", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	// ALTER TABLE cannot add a UNIQUE column, so uniqueness comes from an index
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email)"); err != nil {
+		return nil, fmt.Errorf("failed to index emails: %w", err)
+	}
+
 	return db, nil
 }
+
+// ensureColumn adds a column to an existing table if it is not there yet.
+// CREATE TABLE IF NOT EXISTS never touches tables that already exist, so
+// schema additions to old tables have to go through here.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package models
+
+// Notification is a single entry in a user's inbox
+type Notification struct {
+	ID        int64  `json:"id"`
+	UserID    string `json:"user_id"`
+	Type      string `json:"type"` // e.g., "new_manga", "new_chapter", "reply", "system"
+	Title     string `json:"title"`
+	Body      string `json:"body,omitempty"`
+	Link      string `json:"link,omitempty"`
+	Read      bool   `json:"read"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Follow is a user's subscription to a manga, an author or a genre
+type Follow struct {
+	UserID     string `json:"user_id"`
+	TargetType string `json:"type"` // "manga", "author" or "genre"
+	Target     string `json:"target"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// NotificationPrefs controls how (and whether) alerts reach a user
+type NotificationPrefs struct {
+	UserID     string   `json:"user_id"`
+	Channels   []string `json:"channels"` // e.g., "websocket", "udp", "webhook"
+	WebhookURL string   `json:"webhook_url,omitempty"`
+	Muted      bool     `json:"muted"`  // Keep in inbox only, never push
+	Digest     bool     `json:"digest"` // Batch pushes into a periodic summary
+}
+
+// NotificationPacket is the UDP wire format. Seq increases by one for every
+// packet sent to the same subscriber, so gaps reveal lost datagrams.
+type NotificationPacket struct {
+	Seq          uint64       `json:"seq"`
+	Notification Notification `json:"notification"`
+	Signature    string       `json:"sig,omitempty"` // Multicast only, see notifyclient.SignPacket
+}
@@ -6,4 +6,5 @@ type ProgressUpdate struct {
 	MangaID  string `json:"manga_id"`
 	Progress string `json:"progress"`
 	Chapter  string `json:"chapter"`
+	Token    string `json:"token,omitempty"` // Caller's JWT, forwarded so the TCP server can verify it
 }
@@ -0,0 +1,96 @@
+package notifyclient
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Announcement is a structured admin notification sent to the UDP server.
+// It must be HMAC-SHA256 signed with the key shared with the server.
+type Announcement struct {
+	Type      string `json:"type"` // e.g., "system"
+	Title     string `json:"title"`
+	Payload   string `json:"payload,omitempty"`
+	Timestamp int64  `json:"timestamp"` // Unix seconds; old packets are refused
+	Nonce     string `json:"nonce"`     // Random, makes every packet unique
+	Signature string `json:"signature"` // Hex HMAC of signingInput()
+}
+
+// Verification errors, so receivers can count rejections by reason
+var (
+	ErrUnsigned     = errors.New("announcement is not signed")
+	ErrBadSignature = errors.New("announcement signature does not match")
+)
+
+// signingInput is the canonical byte string covered by the signature. Each
+// field is prefixed with its length, so no two different announcements
+// (e.g. with text moved between Title and Payload) share an input.
+func (a *Announcement) signingInput() []byte {
+	var b []byte
+	for _, field := range []string{a.Type, a.Title, a.Payload, strconv.FormatInt(a.Timestamp, 10), a.Nonce} {
+		b = strconv.AppendInt(b, int64(len(field)), 10)
+		b = append(b, ':')
+		b = append(b, field...)
+	}
+	return b
+}
+
+// Sign stamps the announcement with the current time, a fresh nonce and its signature
+func (a *Announcement) Sign(key []byte) error {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	a.Nonce = hex.EncodeToString(nonce)
+	a.Timestamp = time.Now().Unix()
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(a.signingInput())
+	a.Signature = hex.EncodeToString(mac.Sum(nil))
+	return nil
+}
+
+// Verify checks the signature in constant time. Freshness and replay checks
+// are up to the receiver, which knows what it has already seen.
+func (a *Announcement) Verify(key []byte) error {
+	if a.Signature == "" || a.Nonce == "" {
+		return ErrUnsigned
+	}
+	got, err := hex.DecodeString(a.Signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(a.signingInput())
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// SendAnnouncement signs the announcement and sends it to the UDP server at addr
+func SendAnnouncement(addr string, key []byte, a Announcement) error {
+	if err := a.Sign(key); err != nil {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(data)
+	return err
+}
@@ -0,0 +1,407 @@
+// Package notifyclient is the client side of the MangaHub UDP notification
+// protocol: it registers with the server, keeps the registration alive,
+// detects gaps in the sequence numbers, asks for retransmission with NACKs and
+// hands every notification to the caller exactly once, in order. It also
+// defines the signed Announcement format admins use to publish notifications.
+package notifyclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mangahub/pkg/models"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NackSize is what NACKs and MNACKs are padded to. The server answers a NACK
+// with at most three times its size in packets, so padding is what lets one
+// NACK bring back several.
+const NackSize = 1200
+
+// Handler receives each notification once, in sequence order
+type Handler func(seq uint64, n models.Notification)
+
+type Client struct {
+	Conn   net.PacketConn // Any PacketConn works, e.g. a LossyConn for testing
+	Server net.Addr       // May be nil for a multicast-only listener
+	Token  string         // Optional JWT; registers the endpoint for the user's own alerts
+
+	// PacketKey verifies signed packets (multicast); packets that do not
+	// carry its signature are dropped. Unicast packets are not signed.
+	PacketKey []byte
+
+	KeepAlive time.Duration // PING interval, defaults to a third of the server TTL
+	NACKDelay time.Duration // How long a gap may stay open before it is NACKed (default 200ms)
+	MaxNACKs  int           // NACKs per gap before giving up on it (default 5)
+
+	nackCmd  string                         // "NACK" for unicast, "MNACK" for multicast
+	next     uint64                         // Next sequence number to hand to the handler
+	pending  map[uint64]models.Notification // Received ahead of a gap
+	tail     uint64                         // Server's last sequence, from REGISTERED and PONG
+	gapSince time.Time                      // When the current gap was first seen
+	nacks    int                            // NACKs sent for the current gap
+	lastPing time.Time
+}
+
+// Dial opens a UDP socket for talking to the notification server at addr
+func Dial(addr, token string) (*Client, error) {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Conn: conn, Server: server, Token: token, nackCmd: "NACK"}, nil
+}
+
+// ListenMulticast joins a multicast group (e.g. "239.255.42.99:12346") on the
+// named interface ("" for the system default). key is the server's
+// multicast key; only packets signed with it are delivered. serverAddr is
+// optional; when set, Register with it to repair gaps with MNACKs. Without
+// it no registration is needed.
+func ListenMulticast(group, ifaceName, serverAddr string, key []byte) (*Client, error) {
+	if len(key) == 0 {
+		return nil, errors.New("notifyclient: multicast packets cannot be verified without a key")
+	}
+	groupAddr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	var iface *net.Interface
+	if ifaceName != "" {
+		if iface, err = net.InterfaceByName(ifaceName); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.ListenMulticastUDP("udp4", iface, groupAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{Conn: conn, PacketKey: key, nackCmd: "MNACK"}
+	if serverAddr != "" {
+		if c.Server, err = net.ResolveUDPAddr("udp", serverAddr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	// Start from whatever sequence the group is at when the first packet arrives
+	c.registered(0, 0)
+	c.next = 0
+	return c, nil
+}
+
+// Register announces this endpoint to the server and waits for the
+// acknowledgement. The server first answers with a cookie, which is sent
+// back to prove the address is ours.
+func (c *Client) Register() error {
+	buf := make([]byte, 2048)
+	for attempt := 0; attempt < 3; attempt++ {
+		if err := c.send(c.registerMessage("")); err != nil {
+			return err
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, from, err := c.Conn.ReadFrom(buf)
+			if err != nil {
+				break // Timed out: send REGISTER again
+			}
+			if !c.fromServer(from) {
+				continue
+			}
+			reply := strings.Fields(string(buf[:n]))
+			if len(reply) > 0 && reply[0] == "ERROR" {
+				return errors.New(strings.Join(reply, " "))
+			}
+			if len(reply) == 2 && reply[0] == "COOKIE" {
+				if err := c.send(c.registerMessage(reply[1])); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(reply) == 3 && reply[0] == "REGISTERED" {
+				ttl, _ := strconv.Atoi(reply[1])
+				lastSeq, _ := strconv.ParseUint(reply[2], 10, 64)
+				c.registered(time.Duration(ttl)*time.Second, lastSeq)
+				return nil
+			}
+		}
+	}
+	return errors.New("notification server did not answer REGISTER")
+}
+
+func (c *Client) registered(ttl time.Duration, lastSeq uint64) {
+	if c.KeepAlive == 0 && ttl > 0 {
+		c.KeepAlive = ttl / 3
+	}
+	if c.NACKDelay == 0 {
+		c.NACKDelay = 200 * time.Millisecond
+	}
+	if c.MaxNACKs == 0 {
+		c.MaxNACKs = 5
+	}
+	if c.pending == nil {
+		c.pending = make(map[uint64]models.Notification)
+	}
+	// Fresh client, or the server forgot us and restarted our sequence
+	if c.next == 0 || lastSeq+1 < c.next {
+		c.next = lastSeq + 1
+		c.tail = lastSeq
+		c.pending = make(map[uint64]models.Notification)
+	} else {
+		c.noteTail(lastSeq)
+	}
+	c.lastPing = time.Now()
+}
+
+// noteTail records the server's last sequence number. Packets between next
+// and it were lost at the tail, with nothing after them to reveal the gap,
+// so they are NACKed like any other gap.
+func (c *Client) noteTail(lastSeq uint64) {
+	if lastSeq > c.tail {
+		c.tail = lastSeq
+	}
+	if c.gapOpen() && c.gapSince.IsZero() {
+		c.gapSince = time.Now().Add(-c.NACKDelay) // NACK on the next tick
+	}
+}
+
+// gapOpen reports whether packets before the last known one are missing
+func (c *Client) gapOpen() bool {
+	return len(c.pending) > 0 || (c.tail > 0 && c.tail >= c.next)
+}
+
+// gapEnd is the last sequence number of the first gap
+func (c *Client) gapEnd() uint64 {
+	if len(c.pending) > 0 {
+		return c.firstPending() - 1
+	}
+	return c.tail
+}
+
+// Run reads notifications until the connection is closed, calling handler for
+// each one. Register must have succeeded first.
+func (c *Client) Run(handler Handler) error {
+	if c.pending == nil {
+		return errors.New("notifyclient: Register before Run")
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		c.Conn.SetReadDeadline(time.Now().Add(c.NACKDelay))
+		n, from, err := c.Conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				return err
+			}
+		} else {
+			c.handleDatagram(buf[:n], from, handler)
+		}
+		c.tick(handler)
+	}
+}
+
+// Close unregisters from the server and closes the socket
+func (c *Client) Close() error {
+	c.send("UNREGISTER")
+	return c.Conn.Close()
+}
+
+// handleDatagram acts on one datagram from addr. Anyone can send to our
+// port with a forged source, so only the server is listened to; multicast
+// packets, which come from the group, are trusted by their signature instead.
+func (c *Client) handleDatagram(data []byte, from net.Addr, handler Handler) {
+	if len(data) > 0 && data[0] == '{' {
+		var pkt models.NotificationPacket
+		if err := json.Unmarshal(data, &pkt); err != nil {
+			return
+		}
+		if c.PacketKey != nil {
+			if VerifyPacket(c.PacketKey, pkt) != nil {
+				return // Forged, or from someone else sending to the group
+			}
+		} else if !c.fromServer(from) {
+			return
+		}
+		c.receive(pkt, handler)
+		return
+	}
+	if !c.fromServer(from) {
+		return
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return
+	}
+	switch fields[0] {
+	case "PONG":
+		// The server's last sequence reveals packets lost at the tail
+		if len(fields) == 2 {
+			lastSeq, _ := strconv.ParseUint(fields[1], 10, 64)
+			c.noteTail(lastSeq)
+		}
+	case "LOST":
+		if len(fields) == 3 {
+			last, _ := strconv.ParseUint(fields[2], 10, 64)
+			if last >= c.next {
+				c.skipTo(last+1, handler)
+			}
+		}
+	case "ERROR":
+		if strings.Contains(string(data), "not registered") {
+			c.send(c.registerMessage(""))
+		}
+	case "COOKIE":
+		if len(fields) == 2 {
+			c.send(c.registerMessage(fields[1]))
+		}
+	case "REGISTERED":
+		if len(fields) == 3 {
+			ttl, _ := strconv.Atoi(fields[1])
+			lastSeq, _ := strconv.ParseUint(fields[2], 10, 64)
+			c.registered(time.Duration(ttl)*time.Second, lastSeq)
+		}
+	}
+}
+
+// receive drops duplicates, buffers packets that arrive ahead of a gap and
+// delivers everything that is now contiguous
+func (c *Client) receive(pkt models.NotificationPacket, handler Handler) {
+	if c.next == 0 {
+		c.next = pkt.Seq // Multicast: first packet sets the baseline
+	}
+	if pkt.Seq < c.next {
+		return // Duplicate or retransmission we no longer need
+	}
+	if _, seen := c.pending[pkt.Seq]; seen {
+		return
+	}
+	c.pending[pkt.Seq] = pkt.Notification
+
+	c.flush(handler)
+	if c.gapOpen() && c.gapSince.IsZero() {
+		c.gapSince = time.Now()
+	}
+}
+
+func (c *Client) flush(handler Handler) {
+	advanced := false
+	for {
+		n, ok := c.pending[c.next]
+		if !ok {
+			break
+		}
+		delete(c.pending, c.next)
+		handler(c.next, n)
+		c.next++
+		advanced = true
+	}
+	// Progress closes the old gap; a remaining one starts its own NACK budget
+	if advanced || !c.gapOpen() {
+		c.gapSince = time.Time{}
+		c.nacks = 0
+	}
+}
+
+// skipTo gives up on everything before seq and delivers what is buffered after it
+func (c *Client) skipTo(seq uint64, handler Handler) {
+	for s := range c.pending {
+		if s < seq {
+			delete(c.pending, s)
+		}
+	}
+	c.next = seq
+	c.gapSince = time.Time{}
+	c.nacks = 0
+	c.flush(handler)
+	if c.gapOpen() {
+		c.gapSince = time.Now()
+	}
+}
+
+// tick runs timers: NACKs for open gaps and keepalive PINGs
+func (c *Client) tick(handler Handler) {
+	now := time.Now()
+
+	if !c.gapSince.IsZero() && now.Sub(c.gapSince) >= c.NACKDelay*time.Duration(c.nacks+1) {
+		// Retried on every tick until the gap is filled, LOST arrives or the
+		// NACK budget runs out
+		if c.nacks >= c.MaxNACKs {
+			// The server is not answering for this gap; move past it
+			c.skipTo(c.gapEnd()+1, handler)
+		} else if c.gapOpen() {
+			c.nackUpTo(c.gapEnd())
+		} else {
+			c.gapSince = time.Time{}
+			c.nacks = 0
+		}
+	}
+
+	if c.KeepAlive > 0 && now.Sub(c.lastPing) >= c.KeepAlive {
+		c.send("PING")
+		c.lastPing = now
+	}
+}
+
+func (c *Client) nackUpTo(last uint64) {
+	nack := fmt.Sprintf("%s %d %d ", c.nackCmd, c.next, last)
+	c.send(nack + strings.Repeat(" ", max(NackSize-len(nack), 0)))
+	c.nacks++
+}
+
+func (c *Client) firstPending() uint64 {
+	var first uint64
+	for s := range c.pending {
+		if first == 0 || s < first {
+			first = s
+		}
+	}
+	if first == 0 {
+		return c.next
+	}
+	return first
+}
+
+// registerMessage is REGISTER or MREGISTER, with the server's cookie once
+// there is one
+func (c *Client) registerMessage(cookie string) string {
+	msg := "REGISTER"
+	if c.nackCmd == "MNACK" {
+		msg = "MREGISTER"
+	}
+	if cookie != "" {
+		msg += " " + cookie
+	}
+	if c.Token != "" && c.nackCmd != "MNACK" {
+		msg += " " + c.Token
+	}
+	return msg
+}
+
+// fromServer reports whether a datagram came from the server's address
+func (c *Client) fromServer(from net.Addr) bool {
+	server, ok := c.Server.(*net.UDPAddr)
+	if !ok {
+		return c.Server != nil && from != nil && from.String() == c.Server.String()
+	}
+	addr, ok := from.(*net.UDPAddr)
+	return ok && addr.Port == server.Port && addr.IP.Equal(server.IP)
+}
+
+func (c *Client) send(msg string) error {
+	if c.Server == nil {
+		return nil // Multicast listener without a server to talk back to
+	}
+	_, err := c.Conn.WriteTo([]byte(msg), c.Server)
+	return err
+}
@@ -0,0 +1,227 @@
+package notifyclient_test
+
+import (
+	"fmt"
+	"mangahub/internal/udp"
+	"mangahub/pkg/models"
+	"mangahub/pkg/notifyclient"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer runs a notification server on a loopback port
+func startServer(t *testing.T) *udp.NotificationServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A replay buffer as long as the test, so retries are never answered LOST
+	s := &udp.NotificationServer{Conn: conn, ReplayBuffer: 1000}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+// collect runs the client and returns a channel of the sequence numbers it delivers
+func collect(t *testing.T, c *notifyclient.Client) <-chan uint64 {
+	t.Helper()
+	got := make(chan uint64, 1024)
+	go c.Run(func(seq uint64, n models.Notification) {
+		if n.Title != fmt.Sprintf("n%d", seq) {
+			t.Errorf("packet %d carried %q", seq, n.Title)
+		}
+		got <- seq
+	})
+	t.Cleanup(func() { c.Close() })
+	return got
+}
+
+// expect reads want sequence numbers and checks they are 1..want in order
+func expect(t *testing.T, got <-chan uint64, want int, timeout time.Duration) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for i := uint64(1); i <= uint64(want); i++ {
+		select {
+		case seq := <-got:
+			if seq != i {
+				t.Fatalf("delivered %d, want %d", seq, i)
+			}
+		case <-deadline:
+			t.Fatalf("only %d of %d notifications delivered", i-1, want)
+		}
+	}
+	select {
+	case seq := <-got:
+		t.Fatalf("extra delivery of %d", seq)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRecoversThroughLossyConn(t *testing.T) {
+	server := startServer(t)
+	c, err := notifyclient.Dial(server.Conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register(); err != nil {
+		t.Fatal(err)
+	}
+	// Loss starts after registration, so it hits notifications, PONGs and
+	// retransmissions alike
+	c.Conn = &notifyclient.LossyConn{
+		PacketConn:    c.Conn,
+		DropRate:      0.3,
+		DuplicateRate: 0.1,
+		ReorderRate:   0.1,
+		Rand:          rand.New(rand.NewSource(1)),
+	}
+	c.KeepAlive = 50 * time.Millisecond // Tail losses are found through PONGs
+	c.NACKDelay = 20 * time.Millisecond
+	c.MaxNACKs = 50
+	got := collect(t, c)
+
+	const total = 100
+	for i := 1; i <= total; i++ {
+		server.Publish(models.Notification{Title: fmt.Sprintf("n%d", i)})
+		time.Sleep(2 * time.Millisecond)
+	}
+	expect(t, got, total, 10*time.Second)
+}
+
+// fakeServer answers the client by hand, so the test decides exactly what is lost
+type fakeServer struct {
+	t      *testing.T
+	conn   net.PacketConn
+	client net.Addr
+}
+
+func (f *fakeServer) read() string {
+	f.t.Helper()
+	buf := make([]byte, 2048)
+	f.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, addr, err := f.conn.ReadFrom(buf)
+	if err != nil {
+		f.t.Fatalf("client went quiet: %v", err)
+	}
+	f.client = addr
+	if strings.HasPrefix(string(buf[:n]), "NACK") && n != notifyclient.NackSize {
+		f.t.Fatalf("NACK of %d bytes, want it padded to %d", n, notifyclient.NackSize)
+	}
+	return strings.TrimSpace(string(buf[:n]))
+}
+
+// await reads until the client sends something starting with prefix
+func (f *fakeServer) await(prefix string) string {
+	f.t.Helper()
+	for {
+		if msg := f.read(); strings.HasPrefix(msg, prefix) {
+			return msg
+		} else if strings.HasPrefix(msg, "PING") {
+			f.send("PONG 2")
+		}
+	}
+}
+
+func (f *fakeServer) send(msg string) {
+	f.conn.WriteTo([]byte(msg), f.client)
+}
+
+func (f *fakeServer) packet(seq uint64) {
+	f.send(fmt.Sprintf(`{"seq":%d,"notification":{"title":"n%d"}}`, seq, seq))
+}
+
+func TestRetriesTailLossNACK(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := &fakeServer{t: t, conn: conn}
+
+	c, err := notifyclient.Dial(conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := make(chan error, 1)
+	go func() { registered <- c.Register() }()
+	server.await("REGISTER")
+	server.send("REGISTERED 60 0")
+	if err := <-registered; err != nil {
+		t.Fatal(err)
+	}
+	c.KeepAlive = 30 * time.Millisecond
+	c.NACKDelay = 20 * time.Millisecond
+	got := collect(t, c)
+
+	// Packet 2 is the last one and never arrives; only PONG reveals it
+	server.packet(1)
+	server.await("PING")
+	server.send("PONG 2")
+	if nack := server.await("NACK"); nack != "NACK 2 2" {
+		t.Fatalf("first NACK %q", nack)
+	}
+	// The retransmission is lost too: the client must ask again
+	if nack := server.await("NACK"); nack != "NACK 2 2" {
+		t.Fatalf("second NACK %q", nack)
+	}
+	server.packet(2)
+	expect(t, got, 2, 2*time.Second)
+}
+
+func TestIgnoresSpoofedDatagrams(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := &fakeServer{t: t, conn: conn}
+	spoofer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+
+	c, err := notifyclient.Dial(conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := make(chan error, 1)
+	go func() { registered <- c.Register() }()
+	server.await("REGISTER")
+	spoofer.WriteTo([]byte("ERROR spoofed"), server.client)
+	time.Sleep(20 * time.Millisecond)
+	server.send("REGISTERED 60 0")
+	if err := <-registered; err != nil {
+		t.Fatalf("spoofed ERROR accepted: %v", err)
+	}
+	c.NACKDelay = 20 * time.Millisecond
+	got := collect(t, c)
+
+	server.packet(1)
+	// Would skip every real notification to come, start endless NACKs, or
+	// deliver a notification the server never sent
+	for _, msg := range []string{
+		"LOST 0 18446744073709551614",
+		"PONG 1000",
+		`{"seq":2,"notification":{"title":"forged"}}`,
+	} {
+		spoofer.WriteTo([]byte(msg), server.client)
+	}
+	time.Sleep(50 * time.Millisecond)
+	server.packet(2)
+	server.packet(3)
+	expect(t, got, 3, 2*time.Second)
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := conn.ReadFrom(buf); err == nil {
+		t.Fatalf("client sent %q after spoofed datagrams", buf[:n])
+	}
+}
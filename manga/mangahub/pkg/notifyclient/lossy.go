@@ -0,0 +1,83 @@
+package notifyclient
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// LossyConn wraps a PacketConn and randomly drops, duplicates and delays
+// incoming datagrams. Wrap either side of the connection with it to exercise
+// the sequence/NACK logic without a real bad network.
+type LossyConn struct {
+	net.PacketConn
+	DropRate      float64       // Probability an incoming datagram is discarded
+	DuplicateRate float64       // Probability it is delivered twice
+	ReorderRate   float64       // Probability it is held back behind the next one
+	Rand          *rand.Rand    // Optional, for reproducible runs
+	MaxDelay      time.Duration // Upper bound for held-back datagrams
+
+	mu      sync.Mutex
+	backlog []datagram
+}
+
+type datagram struct {
+	data []byte
+	addr net.Addr
+}
+
+func (l *LossyConn) roll() float64 {
+	if l.Rand != nil {
+		return l.Rand.Float64()
+	}
+	return rand.Float64()
+}
+
+func (l *LossyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		l.mu.Lock()
+		if len(l.backlog) > 0 {
+			d := l.backlog[0]
+			l.backlog = l.backlog[1:]
+			l.mu.Unlock()
+			return copy(p, d.data), d.addr, nil
+		}
+		l.mu.Unlock()
+
+		n, addr, err := l.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+
+		switch r := l.roll(); {
+		case r < l.DropRate:
+			continue
+		case r < l.DropRate+l.DuplicateRate:
+			l.queue(p[:n], addr)
+			return n, addr, nil
+		case r < l.DropRate+l.DuplicateRate+l.ReorderRate:
+			// Hand it out after whatever arrives next (or after MaxDelay)
+			l.queueLater(p[:n], addr)
+			continue
+		}
+		return n, addr, nil
+	}
+}
+
+func (l *LossyConn) queue(data []byte, addr net.Addr) {
+	l.mu.Lock()
+	l.backlog = append(l.backlog, datagram{data: append([]byte(nil), data...), addr: addr})
+	l.mu.Unlock()
+}
+
+func (l *LossyConn) queueLater(data []byte, addr net.Addr) {
+	data = append([]byte(nil), data...)
+	delay := l.MaxDelay
+	if delay == 0 {
+		delay = 50 * time.Millisecond
+	}
+	time.AfterFunc(time.Duration(l.roll()*float64(delay)), func() {
+		l.queue(data, addr)
+	})
+}
@@ -0,0 +1,51 @@
+package notifyclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mangahub/pkg/models"
+)
+
+// Multicast packets are signed with a key shared with LAN listeners. It must
+// not be the announcement key: listeners holding it could forge announcements.
+
+// SignPacket encodes pkt with its signature: an HMAC-SHA256 of the packet's
+// JSON encoding without one
+func SignPacket(key []byte, pkt models.NotificationPacket) ([]byte, error) {
+	pkt.Signature = ""
+	data, err := json.Marshal(pkt)
+	if err != nil {
+		return nil, err
+	}
+	pkt.Signature = packetMAC(key, data)
+	return json.Marshal(pkt)
+}
+
+// VerifyPacket checks a decoded packet's signature in constant time
+func VerifyPacket(key []byte, pkt models.NotificationPacket) error {
+	if pkt.Signature == "" {
+		return ErrUnsigned
+	}
+	got, err := hex.DecodeString(pkt.Signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+	pkt.Signature = ""
+	data, err := json.Marshal(pkt)
+	if err != nil {
+		return err
+	}
+	want, _ := hex.DecodeString(packetMAC(key, data))
+	if !hmac.Equal(got, want) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func packetMAC(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
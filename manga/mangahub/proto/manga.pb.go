@@ -1,7 +1,7 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
 // 	protoc-gen-go v1.36.11
-// 	protoc        v6.33.2
+// 	protoc        (unknown)
 // source: proto/manga.proto
 
 package proto
@@ -349,6 +349,66 @@ func (x *ProgressResponse) GetSuccess() bool {
 	return false
 }
 
+type BackplaneMessage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Topic         string                 `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
+	Origin        string                 `protobuf:"bytes,2,opt,name=origin,proto3" json:"origin,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackplaneMessage) Reset() {
+	*x = BackplaneMessage{}
+	mi := &file_proto_manga_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackplaneMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackplaneMessage) ProtoMessage() {}
+
+func (x *BackplaneMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manga_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackplaneMessage.ProtoReflect.Descriptor instead.
+func (*BackplaneMessage) Descriptor() ([]byte, []int) {
+	return file_proto_manga_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BackplaneMessage) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *BackplaneMessage) GetOrigin() string {
+	if x != nil {
+		return x.Origin
+	}
+	return ""
+}
+
+func (x *BackplaneMessage) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 var File_proto_manga_proto protoreflect.FileDescriptor
 
 const file_proto_manga_proto_rawDesc = "" +
@@ -373,11 +433,17 @@ const file_proto_manga_proto_rawDesc = "" +
 	"\x0eSearchResponse\x12.\n" +
 	"\aresults\x18\x01 \x03(\v2\x14.manga.MangaResponseR\aresults\",\n" +
 	"\x10ProgressResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess2\xc7\x01\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"T\n" +
+	"\x10BackplaneMessage\x12\x14\n" +
+	"\x05topic\x18\x01 \x01(\tR\x05topic\x12\x16\n" +
+	"\x06origin\x18\x02 \x01(\tR\x06origin\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data2\xc7\x01\n" +
 	"\fMangaService\x128\n" +
 	"\bGetManga\x12\x16.manga.GetMangaRequest\x1a\x14.manga.MangaResponse\x12:\n" +
 	"\vSearchManga\x12\x14.manga.SearchRequest\x1a\x15.manga.SearchResponse\x12A\n" +
-	"\x0eUpdateProgress\x12\x16.manga.ProgressRequest\x1a\x17.manga.ProgressResponseB\x10Z\x0emangahub/protob\x06proto3"
+	"\x0eUpdateProgress\x12\x16.manga.ProgressRequest\x1a\x17.manga.ProgressResponse2L\n" +
+	"\tBackplane\x12?\n" +
+	"\aConnect\x12\x17.manga.BackplaneMessage\x1a\x17.manga.BackplaneMessage(\x010\x01B\x10Z\x0emangahub/protob\x06proto3"
 
 var (
 	file_proto_manga_proto_rawDescOnce sync.Once
@@ -391,7 +457,7 @@ func file_proto_manga_proto_rawDescGZIP() []byte {
 	return file_proto_manga_proto_rawDescData
 }
 
-var file_proto_manga_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_manga_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_proto_manga_proto_goTypes = []any{
 	(*GetMangaRequest)(nil),  // 0: manga.GetMangaRequest
 	(*SearchRequest)(nil),    // 1: manga.SearchRequest
@@ -399,17 +465,20 @@ var file_proto_manga_proto_goTypes = []any{
 	(*MangaResponse)(nil),    // 3: manga.MangaResponse
 	(*SearchResponse)(nil),   // 4: manga.SearchResponse
 	(*ProgressResponse)(nil), // 5: manga.ProgressResponse
+	(*BackplaneMessage)(nil), // 6: manga.BackplaneMessage
 }
 var file_proto_manga_proto_depIdxs = []int32{
 	3, // 0: manga.SearchResponse.results:type_name -> manga.MangaResponse
 	0, // 1: manga.MangaService.GetManga:input_type -> manga.GetMangaRequest
 	1, // 2: manga.MangaService.SearchManga:input_type -> manga.SearchRequest
 	2, // 3: manga.MangaService.UpdateProgress:input_type -> manga.ProgressRequest
-	3, // 4: manga.MangaService.GetManga:output_type -> manga.MangaResponse
-	4, // 5: manga.MangaService.SearchManga:output_type -> manga.SearchResponse
-	5, // 6: manga.MangaService.UpdateProgress:output_type -> manga.ProgressResponse
-	4, // [4:7] is the sub-list for method output_type
-	1, // [1:4] is the sub-list for method input_type
+	6, // 4: manga.Backplane.Connect:input_type -> manga.BackplaneMessage
+	3, // 5: manga.MangaService.GetManga:output_type -> manga.MangaResponse
+	4, // 6: manga.MangaService.SearchManga:output_type -> manga.SearchResponse
+	5, // 7: manga.MangaService.UpdateProgress:output_type -> manga.ProgressResponse
+	6, // 8: manga.Backplane.Connect:output_type -> manga.BackplaneMessage
+	5, // [5:9] is the sub-list for method output_type
+	1, // [1:5] is the sub-list for method input_type
 	1, // [1:1] is the sub-list for extension type_name
 	1, // [1:1] is the sub-list for extension extendee
 	0, // [0:1] is the sub-list for field type_name
@@ -426,9 +495,9 @@ func file_proto_manga_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_manga_proto_rawDesc), len(file_proto_manga_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   7,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_proto_manga_proto_goTypes,
 		DependencyIndexes: file_proto_manga_proto_depIdxs,
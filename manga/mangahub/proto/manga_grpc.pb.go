@@ -1,7 +1,7 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 // versions:
 // - protoc-gen-go-grpc v1.6.0
-// - protoc             v6.33.2
+// - protoc             (unknown)
 // source: proto/manga.proto
 
 package proto
@@ -195,3 +195,99 @@ var MangaService_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/manga.proto",
 }
+
+const (
+	Backplane_Connect_FullMethodName = "/manga.Backplane/Connect"
+)
+
+// BackplaneClient is the client API for Backplane service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BackplaneClient interface {
+	Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[BackplaneMessage, BackplaneMessage], error)
+}
+
+type backplaneClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBackplaneClient(cc grpc.ClientConnInterface) BackplaneClient {
+	return &backplaneClient{cc}
+}
+
+func (c *backplaneClient) Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[BackplaneMessage, BackplaneMessage], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Backplane_ServiceDesc.Streams[0], Backplane_Connect_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BackplaneMessage, BackplaneMessage]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Backplane_ConnectClient = grpc.BidiStreamingClient[BackplaneMessage, BackplaneMessage]
+
+// BackplaneServer is the server API for Backplane service.
+// All implementations must embed UnimplementedBackplaneServer
+// for forward compatibility.
+type BackplaneServer interface {
+	Connect(grpc.BidiStreamingServer[BackplaneMessage, BackplaneMessage]) error
+	mustEmbedUnimplementedBackplaneServer()
+}
+
+// UnimplementedBackplaneServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBackplaneServer struct{}
+
+func (UnimplementedBackplaneServer) Connect(grpc.BidiStreamingServer[BackplaneMessage, BackplaneMessage]) error {
+	return status.Error(codes.Unimplemented, "method Connect not implemented")
+}
+func (UnimplementedBackplaneServer) mustEmbedUnimplementedBackplaneServer() {}
+func (UnimplementedBackplaneServer) testEmbeddedByValue()                   {}
+
+// UnsafeBackplaneServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BackplaneServer will
+// result in compilation errors.
+type UnsafeBackplaneServer interface {
+	mustEmbedUnimplementedBackplaneServer()
+}
+
+func RegisterBackplaneServer(s grpc.ServiceRegistrar, srv BackplaneServer) {
+	// If the following call panics, it indicates UnimplementedBackplaneServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Backplane_ServiceDesc, srv)
+}
+
+func _Backplane_Connect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BackplaneServer).Connect(&grpc.GenericServerStream[BackplaneMessage, BackplaneMessage]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Backplane_ConnectServer = grpc.BidiStreamingServer[BackplaneMessage, BackplaneMessage]
+
+// Backplane_ServiceDesc is the grpc.ServiceDesc for Backplane service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Backplane_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "manga.Backplane",
+	HandlerType: (*BackplaneServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Connect",
+			Handler:       _Backplane_Connect_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/manga.proto",
+}
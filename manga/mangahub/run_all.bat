@@ -4,6 +4,9 @@ echo 🚀 Starting MangaHub Distributed System...
 :: Ensure Data folder exists
 if not exist "data" mkdir "data"
 
+:: Shared secret the gateway uses to call the gRPC server (change it outside development)
+if "%MANGAHUB_SERVICE_TOKEN%"=="" set MANGAHUB_SERVICE_TOKEN=mangahub-dev-service-token
+
 :: Step 1: Seed the database (runs and closes)
 echo 💾 Seeding Database...
 go run cmd/seed/main.go